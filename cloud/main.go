@@ -9,6 +9,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
@@ -21,7 +22,10 @@ import (
 	"github.com/digital-dream-labs/vector-cloud/internal/logcollector"
 	"github.com/digital-dream-labs/vector-cloud/internal/robot"
 	"github.com/digital-dream-labs/vector-cloud/internal/token"
+	"github.com/digital-dream-labs/vector-cloud/internal/token/identity"
 	"github.com/digital-dream-labs/vector-cloud/internal/voice"
+	"github.com/digital-dream-labs/vector-cloud/internal/voice/stream"
+	"github.com/digital-dream-labs/vector-cloud/internal/voice/stream/localconn"
 
 	"github.com/gwatts/rootcerts"
 )
@@ -107,7 +111,24 @@ func main() {
 
 	awsRegion := flag.String("region", "us-west-2", "AWS Region")
 
+	offlineASR := flag.String("offline-asr", "", "command that transcribes PCM audio on stdin, to match intents on the robot instead of chipper")
+	offlineGrammar := flag.String("offline-grammar", "", "JSON grammar file used to match intents offline")
+	recordDir := flag.String("record-voice", "", "directory to save voice requests to, for later replay")
+	prerollMs := flag.Uint("preroll-ms", 0, "ms of mic audio from just before a hotword to include at the start of its request")
+	vad := flag.Bool("vad", false, "detect the end of speech on the robot instead of waiting for the mic")
+	localeConfig := flag.String("locale-config", "", "JSON file mapping locales to recognition languages")
+	encryptToken := flag.Bool("encrypt-token", false, "encrypt the stored account token with the robot's device key")
+	multiUser := flag.Bool("multi-user", false, "store tokens for several users of the robot")
+	codecConfig := flag.String("codec-config", "", "JSON file selecting the audio codecs used for uploads")
+	jdocsCache := flag.String("jdocs-cache", "", "directory to keep jdocs in, so they can be read and written while offline")
+
 	flag.Parse()
+	if *offlineASR != "" && len(strings.Fields(*offlineASR)) == 0 {
+		flagError("-offline-asr must name a command")
+	}
+	if *multiUser && *encryptToken {
+		flagError("-encrypt-token can't be used with -multi-user")
+	}
 
 	micSock := getSocketWithRetry(ipc.GetSocketPath("mic_sock"), "cp_mic")
 	defer micSock.Close()
@@ -154,6 +175,42 @@ func main() {
 		voiceOpts = append(voiceOpts, voice.WithHandler(voice.HandlerAmazon))
 	}
 
+	if *offlineASR != "" {
+		var grammar *localconn.Grammar
+		if *offlineGrammar != "" {
+			var err error
+			if grammar, err = localconn.LoadGrammar(*offlineGrammar); err != nil {
+				log.Println("Error loading offline grammar, using default:", err)
+			}
+		}
+		args := strings.Fields(*offlineASR)
+		engine := localconn.NewEngine(localconn.NewCommandRecognizer(args[0], args[1:]...), grammar)
+		voiceOpts = append(voiceOpts, voice.WithLocalIntents(engine))
+	}
+	if *prerollMs > 0 {
+		voiceOpts = append(voiceOpts, voice.WithPrerollMs(*prerollMs))
+	}
+	if *vad {
+		voiceOpts = append(voiceOpts, voice.WithVAD(stream.VADOpts{}))
+	}
+	if *recordDir != "" {
+		voiceOpts = append(voiceOpts, voice.WithRecordDir(*recordDir))
+	}
+	if *localeConfig != "" {
+		if locales, err := voice.LoadLocaleRegistry(*localeConfig); err != nil {
+			log.Println("Error loading locale config, using default:", err)
+		} else {
+			voiceOpts = append(voiceOpts, voice.WithLocales(locales))
+		}
+	}
+	if *codecConfig != "" {
+		if codecOpts, err := voice.LoadCodecConfig(*codecConfig); err != nil {
+			log.Println("Error loading codec config, using default:", err)
+		} else {
+			voiceOpts = append(voiceOpts, codecOpts...)
+		}
+	}
+
 	if err := config.SetGlobal(""); err != nil {
 		log.Println("Could not load server config! This is not good!:", err)
 		if certErrorFunc != nil && certErrorFunc() {
@@ -161,11 +218,36 @@ func main() {
 		}
 	}
 
+	if *multiUser {
+		if provider, err := identity.NewMultiUserFileProvider("", "", identity.ConfigOptions()...); err != nil {
+			log.Println("Error creating multi-user token storage, using default:", err)
+		} else {
+			options = append(options, cloudproc.WithIdentityProvider(provider))
+		}
+	} else if *encryptToken {
+		if provider, err := identity.NewEncryptedFileProvider("", "", identity.ConfigOptions()...); err != nil {
+			log.Println("Error creating encrypted token storage, using default:", err)
+		} else {
+			options = append(options, cloudproc.WithIdentityProvider(provider))
+		}
+	}
+
 	options = append(options, cloudproc.WithVoice(process))
 	options = append(options, cloudproc.WithVoiceOptions(voiceOpts...))
-	tokenOpts := []token.Option{token.WithServer()}
+	// let the engine prompt for re-pairing if the robot is logged out remotely
+	engineSender := &voice.IPCMsgSender{Conn: aiSock}
+	tokenOpts := []token.Option{token.WithServer(), token.WithRevocationHandler(func(userID string) {
+		msg := cloud.NewMessageWithAccountRevoked(&cloud.AccountRevoked{UserId: userID})
+		if err := engineSender.Send(msg); err != nil {
+			log.Println("Error notifying engine of revoked account:", err)
+		}
+	})}
 	options = append(options, cloudproc.WithTokenOptions(tokenOpts...))
-	options = append(options, cloudproc.WithJdocs(jdocs.WithServer()))
+	jdocsOpts := []jdocs.Option{jdocs.WithServer()}
+	if *jdocsCache != "" {
+		jdocsOpts = append(jdocsOpts, jdocs.WithCacheDir(*jdocsCache))
+	}
+	options = append(options, cloudproc.WithJdocs(jdocsOpts...))
 
 	logcollectorOpts := []logcollector.Option{logcollector.WithServer()}
 	logcollectorOpts = append(logcollectorOpts, logcollector.WithHTTPClient(getHTTPClient()))
@@ -180,6 +262,13 @@ func main() {
 	log.Println("All processes exited, shutting down")
 }
 
+// flagError reports a bad combination of flags the way the flag package does
+func flagError(msg string) {
+	fmt.Fprintln(flag.CommandLine.Output(), msg)
+	flag.Usage()
+	os.Exit(2)
+}
+
 func signalHandler() {
 	ch := make(chan os.Signal, 1)
 	signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
@@ -4,7 +4,6 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
-	"io/ioutil"
 	"net"
 	"net/http"
 	"os"
@@ -154,31 +153,20 @@ func main() {
 		cleanExit()
 	}()
 
-	if _, err := os.Stat(robot.GatewayCert); os.IsNotExist(err) {
-		log.Println("Cannot find cert:", robot.GatewayCert)
-		os.Exit(1)
-	}
-	if _, err := os.Stat(robot.GatewayKey); os.IsNotExist(err) {
-		log.Println("Cannot find key: ", robot.GatewayKey)
-		os.Exit(1)
-	}
-
-	pair, err := tls.LoadX509KeyPair(robot.GatewayCert, robot.GatewayKey)
+	pair, err := robot.TLSCertificate(robot.GatewayKeySource())
 	if err != nil {
-		log.Println("Failed to initialize key pair")
+		log.Println("Failed to initialize key pair:", err)
 		os.Exit(1)
 	}
 	demoKeyPair = &pair
 	demoCertPool = x509.NewCertPool()
-	caCert, err := ioutil.ReadFile(robot.GatewayCert)
-	if err != nil {
-		log.Println(err)
-		os.Exit(1)
-	}
-	ok := demoCertPool.AppendCertsFromPEM(caCert)
-	if !ok {
-		log.Println("Error: Bad certificates.")
-		panic("Bad certificates.")
+	for _, der := range pair.Certificate {
+		caCert, err := x509.ParseCertificate(der)
+		if err != nil {
+			log.Println("Error: Bad certificates.")
+			panic("Bad certificates.")
+		}
+		demoCertPool.AddCert(caCert)
 	}
 	addr := fmt.Sprintf("localhost:%d", Port)
 
@@ -213,11 +201,7 @@ func main() {
 		rate.NewLimiter(rate.Every(10*time.Minute), 25),
 	)
 
-	creds, err := credentials.NewServerTLSFromFile(robot.GatewayCert, robot.GatewayKey)
-	if err != nil {
-		log.Println("Error creating server tls:", err)
-		os.Exit(1)
-	}
+	creds := credentials.NewTLS(&tls.Config{Certificates: []tls.Certificate{*demoKeyPair}})
 	grpcServer := grpc.NewServer(
 		grpc.Creds(creds),
 		grpc.UnaryInterceptor(LoggingUnaryInterceptor),
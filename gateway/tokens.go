@@ -24,7 +24,10 @@ import (
 const (
 	jdocDomainSocket = "jdocs_server"
 	jdocSocketSuffix = "gateway_client"
-	tokensFile       = "/data/vic-gateway/token-hashes.json"
+	// jdocWatchSuffix names the connection changes to the tokens document are pushed on,
+	// kept apart from jdocIPC so that they don't get mixed up with responses
+	jdocWatchSuffix = "gateway_watch"
+	tokensDocName   = "vic.AppTokens"
 )
 
 // ClientToken holds the tuple of the client token hash and the
@@ -43,6 +46,8 @@ type ClientToken struct {
 type ClientTokenManager struct {
 	ClientTokens      []ClientToken      `json:"client_tokens"`
 	jdocIPC           IpcManager         `json:"-"`
+	watchIPC          IpcManager         `json:"-"`
+	tokensChanged     chan []byte        `json:"-"`
 	checkValid        chan struct{}      `json:"-"`
 	notifyValid       chan struct{}      `json:"-"`
 	updateNowChan     chan chan struct{} `json:"-"`
@@ -76,7 +81,9 @@ func (ctm *ClientTokenManager) Init() error {
 	ctm.checkValid = make(chan struct{})
 	ctm.notifyValid = make(chan struct{})
 	ctm.updateNowChan = make(chan chan struct{})
+	ctm.tokensChanged = make(chan []byte)
 	ctm.jdocIPC.Connect(ipc.GetSocketPath(jdocDomainSocket), jdocSocketSuffix)
+	ctm.watchIPC.Connect(ipc.GetSocketPath(jdocDomainSocket), jdocWatchSuffix)
 	err := ctm.readTokensFile()
 	if err != nil {
 		return ctm.UpdateTokens()
@@ -85,11 +92,12 @@ func (ctm *ClientTokenManager) Init() error {
 }
 
 func (ctm *ClientTokenManager) Close() error {
+	ctm.watchIPC.Close()
 	return ctm.jdocIPC.Close()
 }
 
 func (ctm *ClientTokenManager) readTokensFile() error {
-	clientTokens, err := ioutil.ReadFile(tokensFile)
+	clientTokens, err := ioutil.ReadFile(robot.GatewayTokensFile)
 	if err != nil {
 		return err
 	}
@@ -97,7 +105,7 @@ func (ctm *ClientTokenManager) readTokensFile() error {
 }
 
 func (ctm *ClientTokenManager) writeTokensFile(data []byte) error {
-	return ioutil.WriteFile(tokensFile, data, 0600)
+	return ioutil.WriteFile(robot.GatewayTokensFile, data, 0600)
 }
 
 func (ctm *ClientTokenManager) CheckToken(clientToken string) (string, error) {
@@ -141,7 +149,7 @@ func (ctm *ClientTokenManager) DecodeTokenJdoc(jdoc []byte) error {
 // UpdateTokens polls the server for new tokens, and will update as necessary
 func (ctm *ClientTokenManager) UpdateTokens() error {
 	if ctm.forceClearFile {
-		err := os.Remove(tokensFile)
+		err := os.Remove(robot.GatewayTokensFile)
 		if err == nil {
 			ctm.forceClearFile = false
 		}
@@ -155,7 +163,7 @@ func (ctm *ClientTokenManager) UpdateTokens() error {
 		Thing:   fmt.Sprintf("vic:%s", esn),
 		Items: []cloud_clad.ReadItem{
 			cloud_clad.ReadItem{
-				DocName:      "vic.AppTokens",
+				DocName:      tokensDocName,
 				MyDocVersion: 0,
 			},
 		},
@@ -241,8 +249,23 @@ func (ctm *ClientTokenManager) ForceUpdate(response chan struct{}) {
 	ctm.updateNowChan <- response
 }
 
+// purgedTokens returns whether the tokens file was deleted out from under us, which
+// vic-cloud does when the robot's account has been revoked
+func (ctm *ClientTokenManager) purgedTokens() bool {
+	if len(ctm.ClientTokens) == 0 {
+		return false
+	}
+	_, err := os.Stat(robot.GatewayTokensFile)
+	return os.IsNotExist(err)
+}
+
 func (ctm *ClientTokenManager) updateListener() {
 	for range ctm.checkValid {
+		if ctm.purgedTokens() {
+			log.Println("Client tokens file was removed, clearing tokens")
+			ctm.recentTokenIndex = 0
+			ctm.ClientTokens = []ClientToken{}
+		}
 		if time.Since(ctm.lastUpdatedTokens) > time.Hour && ctm.limiter.Allow() {
 			ctm.updateNowChan <- ctm.notifyValid
 		} else {
@@ -251,10 +274,78 @@ func (ctm *ClientTokenManager) updateListener() {
 	}
 }
 
+// watchTokens subscribes to changes to the tokens document, so that tokens added or
+// revoked from the app take effect right away rather than at the next poll. If the
+// subscription's connection closes, it reconnects and subscribes again.
+func (ctm *ClientTokenManager) watchTokens() {
+	for {
+		for {
+			err := ctm.subscribeTokens()
+			if err == nil {
+				break
+			}
+			log.Printf("Unable to subscribe to token changes, retrying: %s\n", err.Error())
+			time.Sleep(time.Minute)
+		}
+		ctm.readTokenChanges()
+		log.Println("Token change subscription closed, resubscribing")
+		ctm.watchIPC.Close()
+		time.Sleep(5 * time.Second)
+		ctm.watchIPC.Connect(ipc.GetSocketPath(jdocDomainSocket), jdocWatchSuffix)
+	}
+}
+
+// readTokenChanges passes on changes to the tokens document until the subscription's
+// connection closes
+func (ctm *ClientTokenManager) readTokenChanges() {
+	for {
+		msgBuffer := ctm.watchIPC.conn.ReadBlock()
+		if msgBuffer == nil {
+			return
+		}
+		var msg cloud_clad.DocResponse
+		if err := msg.Unpack(bytes.NewBuffer(msgBuffer)); err != nil {
+			log.Errorf("Unable to unpack token change: %s\n", err.Error())
+			continue
+		}
+		change := msg.GetChanged()
+		if change == nil || change.DocName != tokensDocName || change.Status != cloud_clad.ReadStatus_Changed {
+			continue
+		}
+		ctm.tokensChanged <- []byte(change.Doc.JsonDoc)
+	}
+}
+
+// subscribeTokens subscribes to the tokens document of the robot's active user; it
+// doesn't ask for the user id over jdocIPC, which UpdateTokens may be using
+func (ctm *ClientTokenManager) subscribeTokens() error {
+	esn, err := robot.ReadESN()
+	if err != nil {
+		return err
+	}
+	request := cloud_clad.NewDocRequestWithSubscribe(&cloud_clad.SubscribeRequest{
+		Thing: fmt.Sprintf("vic:%s", esn),
+		Items: []cloud_clad.ReadItem{{DocName: tokensDocName}},
+	})
+	var buf bytes.Buffer
+	if err := request.Pack(&buf); err != nil {
+		return err
+	}
+	_, err = ctm.watchIPC.conn.Write(buf.Bytes())
+	return err
+}
+
 func (ctm *ClientTokenManager) StartUpdateListener() {
 	go ctm.updateListener()
+	go ctm.watchTokens()
 	for {
 		select {
+		case data := <-ctm.tokensChanged:
+			if err := ctm.DecodeTokenJdoc(data); err == nil {
+				if err := ctm.writeTokensFile(data); err != nil {
+					log.Printf("Unable to write tokens file: %s\n", err.Error())
+				}
+			}
 		case response := <-ctm.updateNowChan:
 			err := ctm.UpdateTokens()
 			if err != nil {
@@ -187,6 +187,7 @@ type DocError uint8
 
 const (
 	DocError_ErrorConnecting DocError = iota
+	DocError_InvalidDoc
 )
 
 // ENUM WriteStatus
@@ -197,6 +198,7 @@ const (
 	WriteStatus_RejectedDocVersion
 	WriteStatus_RejectedFmtVersion
 	WriteStatus_Error
+	WriteStatus_Aborted
 )
 
 // STRUCTURE WriteResponse
@@ -554,16 +556,191 @@ func (d *DeleteRequest) String() string {
 		"DocName: {", d.DocName, "}")
 }
 
+// STRUCTURE SubscribeRequest
+type SubscribeRequest struct {
+	Account string
+	Thing   string
+	Items   []ReadItem
+}
+
+func (s *SubscribeRequest) Size() uint32 {
+	var result uint32
+	result += 2                      // Account length (uint_16)
+	result += uint32(len(s.Account)) // uint_8 array
+	result += 2                      // Thing length (uint_16)
+	result += uint32(len(s.Thing))   // uint_8 array
+	result += 2                      // Items length (uint_16)
+	for idx := range s.Items {
+		result += s.Items[idx].Size()
+	}
+	return result
+}
+
+func (s *SubscribeRequest) Unpack(buf *bytes.Buffer) error {
+	var AccountLen uint16
+	if err := binary.Read(buf, binary.LittleEndian, &AccountLen); err != nil {
+		return err
+	}
+	s.Account = string(buf.Next(int(AccountLen)))
+	if len(s.Account) != int(AccountLen) {
+		return errors.New("string byte mismatch")
+	}
+	var ThingLen uint16
+	if err := binary.Read(buf, binary.LittleEndian, &ThingLen); err != nil {
+		return err
+	}
+	s.Thing = string(buf.Next(int(ThingLen)))
+	if len(s.Thing) != int(ThingLen) {
+		return errors.New("string byte mismatch")
+	}
+	var ItemsLen uint16
+	if err := binary.Read(buf, binary.LittleEndian, &ItemsLen); err != nil {
+		return err
+	}
+	s.Items = make([]ReadItem, ItemsLen)
+	for idx := range s.Items {
+		if err := s.Items[idx].Unpack(buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SubscribeRequest) Pack(buf *bytes.Buffer) error {
+	if len(s.Account) > 65535 {
+		return errors.New("max_length overflow in field Account")
+	}
+	if err := binary.Write(buf, binary.LittleEndian, uint16(len(s.Account))); err != nil {
+		return err
+	}
+	if _, err := buf.WriteString(s.Account); err != nil {
+		return err
+	}
+	if len(s.Thing) > 65535 {
+		return errors.New("max_length overflow in field Thing")
+	}
+	if err := binary.Write(buf, binary.LittleEndian, uint16(len(s.Thing))); err != nil {
+		return err
+	}
+	if _, err := buf.WriteString(s.Thing); err != nil {
+		return err
+	}
+	if len(s.Items) > 65535 {
+		return errors.New("max_length overflow in field Items")
+	}
+	if err := binary.Write(buf, binary.LittleEndian, uint16(len(s.Items))); err != nil {
+		return err
+	}
+	for idx := range s.Items {
+		if err := s.Items[idx].Pack(buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SubscribeRequest) String() string {
+	return fmt.Sprint("Account: {", s.Account, "} ",
+		"Thing: {", s.Thing, "} ",
+		"Items: {", s.Items, "}")
+}
+
+// STRUCTURE BatchRequest
+type BatchRequest struct {
+	Atomic  bool
+	Writes  []WriteRequest
+	Deletes []DeleteRequest
+}
+
+func (b *BatchRequest) Size() uint32 {
+	var result uint32
+	result += 1 // Atomic bool
+	result += 2 // Writes length (uint_16)
+	for idx := range b.Writes {
+		result += b.Writes[idx].Size()
+	}
+	result += 2 // Deletes length (uint_16)
+	for idx := range b.Deletes {
+		result += b.Deletes[idx].Size()
+	}
+	return result
+}
+
+func (b *BatchRequest) Unpack(buf *bytes.Buffer) error {
+	if err := binary.Read(buf, binary.LittleEndian, &b.Atomic); err != nil {
+		return err
+	}
+	var WritesLen uint16
+	if err := binary.Read(buf, binary.LittleEndian, &WritesLen); err != nil {
+		return err
+	}
+	b.Writes = make([]WriteRequest, WritesLen)
+	for idx := range b.Writes {
+		if err := b.Writes[idx].Unpack(buf); err != nil {
+			return err
+		}
+	}
+	var DeletesLen uint16
+	if err := binary.Read(buf, binary.LittleEndian, &DeletesLen); err != nil {
+		return err
+	}
+	b.Deletes = make([]DeleteRequest, DeletesLen)
+	for idx := range b.Deletes {
+		if err := b.Deletes[idx].Unpack(buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *BatchRequest) Pack(buf *bytes.Buffer) error {
+	if err := binary.Write(buf, binary.LittleEndian, b.Atomic); err != nil {
+		return err
+	}
+	if len(b.Writes) > 65535 {
+		return errors.New("max_length overflow in field Writes")
+	}
+	if err := binary.Write(buf, binary.LittleEndian, uint16(len(b.Writes))); err != nil {
+		return err
+	}
+	for idx := range b.Writes {
+		if err := b.Writes[idx].Pack(buf); err != nil {
+			return err
+		}
+	}
+	if len(b.Deletes) > 65535 {
+		return errors.New("max_length overflow in field Deletes")
+	}
+	if err := binary.Write(buf, binary.LittleEndian, uint16(len(b.Deletes))); err != nil {
+		return err
+	}
+	for idx := range b.Deletes {
+		if err := b.Deletes[idx].Pack(buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *BatchRequest) String() string {
+	return fmt.Sprint("Atomic: {", b.Atomic, "} ",
+		"Writes: {", b.Writes, "} ",
+		"Deletes: {", b.Deletes, "}")
+}
+
 // UNION DocRequest
 type DocRequestTag uint8
 
 const (
-	DocRequestTag_Write     DocRequestTag = iota // 0
-	DocRequestTag_Read                           // 1
-	DocRequestTag_DeleteReq                      // 2
-	DocRequestTag_User                           // 3
-	DocRequestTag_Thing                          // 4
-	DocRequestTag_INVALID   DocRequestTag = 255
+	DocRequestTag_Write       DocRequestTag = iota // 0
+	DocRequestTag_Read                             // 1
+	DocRequestTag_DeleteReq                        // 2
+	DocRequestTag_User                             // 3
+	DocRequestTag_Thing                            // 4
+	DocRequestTag_Subscribe                        // 5
+	DocRequestTag_Unsubscribe                      // 6
+	DocRequestTag_Batch                            // 7
+	DocRequestTag_INVALID     DocRequestTag = 255
 )
 
 type DocRequest struct {
@@ -631,6 +808,24 @@ func (m *DocRequest) unpackStruct(tag DocRequestTag, buf *bytes.Buffer) (clad.St
 			return nil, err
 		}
 		return &ret, nil
+	case DocRequestTag_Subscribe:
+		var ret SubscribeRequest
+		if err := ret.Unpack(buf); err != nil {
+			return nil, err
+		}
+		return &ret, nil
+	case DocRequestTag_Unsubscribe:
+		var ret Void
+		if err := ret.Unpack(buf); err != nil {
+			return nil, err
+		}
+		return &ret, nil
+	case DocRequestTag_Batch:
+		var ret BatchRequest
+		if err := ret.Unpack(buf); err != nil {
+			return nil, err
+		}
+		return &ret, nil
 	default:
 		return nil, errors.New("invalid tag to unpackStruct")
 	}
@@ -667,6 +862,12 @@ func (t DocRequestTag) String() string {
 		return "User"
 	case DocRequestTag_Thing:
 		return "Thing"
+	case DocRequestTag_Subscribe:
+		return "Subscribe"
+	case DocRequestTag_Unsubscribe:
+		return "Unsubscribe"
+	case DocRequestTag_Batch:
+		return "Batch"
 	default:
 		return "INVALID"
 	}
@@ -777,6 +978,63 @@ func NewDocRequestWithThing(value *Void) *DocRequest {
 	return &ret
 }
 
+func (m *DocRequest) GetSubscribe() *SubscribeRequest {
+	if m.tag == nil || *m.tag != DocRequestTag_Subscribe {
+		return nil
+	}
+	return m.value.(*SubscribeRequest)
+}
+
+func (m *DocRequest) SetSubscribe(value *SubscribeRequest) {
+	newTag := DocRequestTag_Subscribe
+	m.tag = &newTag
+	m.value = value
+}
+
+func NewDocRequestWithSubscribe(value *SubscribeRequest) *DocRequest {
+	var ret DocRequest
+	ret.SetSubscribe(value)
+	return &ret
+}
+
+func (m *DocRequest) GetUnsubscribe() *Void {
+	if m.tag == nil || *m.tag != DocRequestTag_Unsubscribe {
+		return nil
+	}
+	return m.value.(*Void)
+}
+
+func (m *DocRequest) SetUnsubscribe(value *Void) {
+	newTag := DocRequestTag_Unsubscribe
+	m.tag = &newTag
+	m.value = value
+}
+
+func NewDocRequestWithUnsubscribe(value *Void) *DocRequest {
+	var ret DocRequest
+	ret.SetUnsubscribe(value)
+	return &ret
+}
+
+func (m *DocRequest) GetBatch() *BatchRequest {
+	if m.tag == nil || *m.tag != DocRequestTag_Batch {
+		return nil
+	}
+	return m.value.(*BatchRequest)
+}
+
+func (m *DocRequest) SetBatch(value *BatchRequest) {
+	newTag := DocRequestTag_Batch
+	m.tag = &newTag
+	m.value = value
+}
+
+func NewDocRequestWithBatch(value *BatchRequest) *DocRequest {
+	var ret DocRequest
+	ret.SetBatch(value)
+	return &ret
+}
+
 // STRUCTURE ErrorResponse
 type ErrorResponse struct {
 	Err DocError
@@ -888,17 +1146,205 @@ func (t *ThingResponse) String() string {
 	return fmt.Sprint("ThingName: {", t.ThingName, "}")
 }
 
+// STRUCTURE DocChange
+type DocChange struct {
+	Account string
+	Thing   string
+	DocName string
+	Status  ReadStatus
+	Doc     Doc
+}
+
+func (d *DocChange) Size() uint32 {
+	var result uint32
+	result += 2                      // Account length (uint_16)
+	result += uint32(len(d.Account)) // uint_8 array
+	result += 2                      // Thing length (uint_16)
+	result += uint32(len(d.Thing))   // uint_8 array
+	result += 1                      // DocName length (uint_8)
+	result += uint32(len(d.DocName)) // uint_8 array
+	result += 1                      // Status ReadStatus
+	result += d.Doc.Size()
+	return result
+}
+
+func (d *DocChange) Unpack(buf *bytes.Buffer) error {
+	var AccountLen uint16
+	if err := binary.Read(buf, binary.LittleEndian, &AccountLen); err != nil {
+		return err
+	}
+	d.Account = string(buf.Next(int(AccountLen)))
+	if len(d.Account) != int(AccountLen) {
+		return errors.New("string byte mismatch")
+	}
+	var ThingLen uint16
+	if err := binary.Read(buf, binary.LittleEndian, &ThingLen); err != nil {
+		return err
+	}
+	d.Thing = string(buf.Next(int(ThingLen)))
+	if len(d.Thing) != int(ThingLen) {
+		return errors.New("string byte mismatch")
+	}
+	var DocNameLen uint8
+	if err := binary.Read(buf, binary.LittleEndian, &DocNameLen); err != nil {
+		return err
+	}
+	d.DocName = string(buf.Next(int(DocNameLen)))
+	if len(d.DocName) != int(DocNameLen) {
+		return errors.New("string byte mismatch")
+	}
+	if err := binary.Read(buf, binary.LittleEndian, &d.Status); err != nil {
+		return err
+	}
+	if err := d.Doc.Unpack(buf); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (d *DocChange) Pack(buf *bytes.Buffer) error {
+	if len(d.Account) > 65535 {
+		return errors.New("max_length overflow in field Account")
+	}
+	if err := binary.Write(buf, binary.LittleEndian, uint16(len(d.Account))); err != nil {
+		return err
+	}
+	if _, err := buf.WriteString(d.Account); err != nil {
+		return err
+	}
+	if len(d.Thing) > 65535 {
+		return errors.New("max_length overflow in field Thing")
+	}
+	if err := binary.Write(buf, binary.LittleEndian, uint16(len(d.Thing))); err != nil {
+		return err
+	}
+	if _, err := buf.WriteString(d.Thing); err != nil {
+		return err
+	}
+	if len(d.DocName) > 255 {
+		return errors.New("max_length overflow in field DocName")
+	}
+	if err := binary.Write(buf, binary.LittleEndian, uint8(len(d.DocName))); err != nil {
+		return err
+	}
+	if _, err := buf.WriteString(d.DocName); err != nil {
+		return err
+	}
+	if err := binary.Write(buf, binary.LittleEndian, d.Status); err != nil {
+		return err
+	}
+	if err := d.Doc.Pack(buf); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (d *DocChange) String() string {
+	return fmt.Sprint("Account: {", d.Account, "} ",
+		"Thing: {", d.Thing, "} ",
+		"DocName: {", d.DocName, "} ",
+		"Status: {", d.Status, "} ",
+		"Doc: {", d.Doc, "}")
+}
+
+// STRUCTURE BatchResponse
+type BatchResponse struct {
+	Committed bool
+	Writes    []WriteResponse
+	Deletes   []WriteResponse
+}
+
+func (b *BatchResponse) Size() uint32 {
+	var result uint32
+	result += 1 // Committed bool
+	result += 2 // Writes length (uint_16)
+	for idx := range b.Writes {
+		result += b.Writes[idx].Size()
+	}
+	result += 2 // Deletes length (uint_16)
+	for idx := range b.Deletes {
+		result += b.Deletes[idx].Size()
+	}
+	return result
+}
+
+func (b *BatchResponse) Unpack(buf *bytes.Buffer) error {
+	if err := binary.Read(buf, binary.LittleEndian, &b.Committed); err != nil {
+		return err
+	}
+	var WritesLen uint16
+	if err := binary.Read(buf, binary.LittleEndian, &WritesLen); err != nil {
+		return err
+	}
+	b.Writes = make([]WriteResponse, WritesLen)
+	for idx := range b.Writes {
+		if err := b.Writes[idx].Unpack(buf); err != nil {
+			return err
+		}
+	}
+	var DeletesLen uint16
+	if err := binary.Read(buf, binary.LittleEndian, &DeletesLen); err != nil {
+		return err
+	}
+	b.Deletes = make([]WriteResponse, DeletesLen)
+	for idx := range b.Deletes {
+		if err := b.Deletes[idx].Unpack(buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *BatchResponse) Pack(buf *bytes.Buffer) error {
+	if err := binary.Write(buf, binary.LittleEndian, b.Committed); err != nil {
+		return err
+	}
+	if len(b.Writes) > 65535 {
+		return errors.New("max_length overflow in field Writes")
+	}
+	if err := binary.Write(buf, binary.LittleEndian, uint16(len(b.Writes))); err != nil {
+		return err
+	}
+	for idx := range b.Writes {
+		if err := b.Writes[idx].Pack(buf); err != nil {
+			return err
+		}
+	}
+	if len(b.Deletes) > 65535 {
+		return errors.New("max_length overflow in field Deletes")
+	}
+	if err := binary.Write(buf, binary.LittleEndian, uint16(len(b.Deletes))); err != nil {
+		return err
+	}
+	for idx := range b.Deletes {
+		if err := b.Deletes[idx].Pack(buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *BatchResponse) String() string {
+	return fmt.Sprint("Committed: {", b.Committed, "} ",
+		"Writes: {", b.Writes, "} ",
+		"Deletes: {", b.Deletes, "}")
+}
+
 // UNION DocResponse
 type DocResponseTag uint8
 
 const (
-	DocResponseTag_Write      DocResponseTag = iota // 0
-	DocResponseTag_Read                             // 1
-	DocResponseTag_DeleteResp                       // 2
-	DocResponseTag_Err                              // 3
-	DocResponseTag_User                             // 4
-	DocResponseTag_Thing                            // 5
-	DocResponseTag_INVALID    DocResponseTag = 255
+	DocResponseTag_Write       DocResponseTag = iota // 0
+	DocResponseTag_Read                              // 1
+	DocResponseTag_DeleteResp                        // 2
+	DocResponseTag_Err                               // 3
+	DocResponseTag_User                              // 4
+	DocResponseTag_Thing                             // 5
+	DocResponseTag_Subscribe                         // 6
+	DocResponseTag_Unsubscribe                       // 7
+	DocResponseTag_Changed                           // 8
+	DocResponseTag_Batch                             // 9
+	DocResponseTag_INVALID     DocResponseTag = 255
 )
 
 type DocResponse struct {
@@ -972,6 +1418,30 @@ func (m *DocResponse) unpackStruct(tag DocResponseTag, buf *bytes.Buffer) (clad.
 			return nil, err
 		}
 		return &ret, nil
+	case DocResponseTag_Subscribe:
+		var ret Void
+		if err := ret.Unpack(buf); err != nil {
+			return nil, err
+		}
+		return &ret, nil
+	case DocResponseTag_Unsubscribe:
+		var ret Void
+		if err := ret.Unpack(buf); err != nil {
+			return nil, err
+		}
+		return &ret, nil
+	case DocResponseTag_Changed:
+		var ret DocChange
+		if err := ret.Unpack(buf); err != nil {
+			return nil, err
+		}
+		return &ret, nil
+	case DocResponseTag_Batch:
+		var ret BatchResponse
+		if err := ret.Unpack(buf); err != nil {
+			return nil, err
+		}
+		return &ret, nil
 	default:
 		return nil, errors.New("invalid tag to unpackStruct")
 	}
@@ -1010,6 +1480,14 @@ func (t DocResponseTag) String() string {
 		return "User"
 	case DocResponseTag_Thing:
 		return "Thing"
+	case DocResponseTag_Subscribe:
+		return "Subscribe"
+	case DocResponseTag_Unsubscribe:
+		return "Unsubscribe"
+	case DocResponseTag_Changed:
+		return "Changed"
+	case DocResponseTag_Batch:
+		return "Batch"
 	default:
 		return "INVALID"
 	}
@@ -1138,3 +1616,79 @@ func NewDocResponseWithThing(value *ThingResponse) *DocResponse {
 	ret.SetThing(value)
 	return &ret
 }
+
+func (m *DocResponse) GetSubscribe() *Void {
+	if m.tag == nil || *m.tag != DocResponseTag_Subscribe {
+		return nil
+	}
+	return m.value.(*Void)
+}
+
+func (m *DocResponse) SetSubscribe(value *Void) {
+	newTag := DocResponseTag_Subscribe
+	m.tag = &newTag
+	m.value = value
+}
+
+func NewDocResponseWithSubscribe(value *Void) *DocResponse {
+	var ret DocResponse
+	ret.SetSubscribe(value)
+	return &ret
+}
+
+func (m *DocResponse) GetUnsubscribe() *Void {
+	if m.tag == nil || *m.tag != DocResponseTag_Unsubscribe {
+		return nil
+	}
+	return m.value.(*Void)
+}
+
+func (m *DocResponse) SetUnsubscribe(value *Void) {
+	newTag := DocResponseTag_Unsubscribe
+	m.tag = &newTag
+	m.value = value
+}
+
+func NewDocResponseWithUnsubscribe(value *Void) *DocResponse {
+	var ret DocResponse
+	ret.SetUnsubscribe(value)
+	return &ret
+}
+
+func (m *DocResponse) GetChanged() *DocChange {
+	if m.tag == nil || *m.tag != DocResponseTag_Changed {
+		return nil
+	}
+	return m.value.(*DocChange)
+}
+
+func (m *DocResponse) SetChanged(value *DocChange) {
+	newTag := DocResponseTag_Changed
+	m.tag = &newTag
+	m.value = value
+}
+
+func NewDocResponseWithChanged(value *DocChange) *DocResponse {
+	var ret DocResponse
+	ret.SetChanged(value)
+	return &ret
+}
+
+func (m *DocResponse) GetBatch() *BatchResponse {
+	if m.tag == nil || *m.tag != DocResponseTag_Batch {
+		return nil
+	}
+	return m.value.(*BatchResponse)
+}
+
+func (m *DocResponse) SetBatch(value *BatchResponse) {
+	newTag := DocResponseTag_Batch
+	m.tag = &newTag
+	m.value = value
+}
+
+func NewDocResponseWithBatch(value *BatchResponse) *DocResponse {
+	var ret DocResponse
+	ret.SetBatch(value)
+	return &ret
+}
@@ -452,23 +452,137 @@ func (c *ConnectionResult) String() string {
 		"ExpectedPackets: {", c.ExpectedPackets, "}")
 }
 
+// STRUCTURE PartialTranscript
+type PartialTranscript struct {
+	Session    string
+	Transcript string
+	IsFinal    bool
+}
+
+func (p *PartialTranscript) Size() uint32 {
+	var result uint32
+	result += 1                         // Session length (uint_8)
+	result += uint32(len(p.Session))    // uint_8 array
+	result += 2                         // Transcript length (uint_16)
+	result += uint32(len(p.Transcript)) // uint_8 array
+	result += 1                         // IsFinal bool
+	return result
+}
+
+func (p *PartialTranscript) Unpack(buf *bytes.Buffer) error {
+	var SessionLen uint8
+	if err := binary.Read(buf, binary.LittleEndian, &SessionLen); err != nil {
+		return err
+	}
+	p.Session = string(buf.Next(int(SessionLen)))
+	if len(p.Session) != int(SessionLen) {
+		return errors.New("string byte mismatch")
+	}
+	var TranscriptLen uint16
+	if err := binary.Read(buf, binary.LittleEndian, &TranscriptLen); err != nil {
+		return err
+	}
+	p.Transcript = string(buf.Next(int(TranscriptLen)))
+	if len(p.Transcript) != int(TranscriptLen) {
+		return errors.New("string byte mismatch")
+	}
+	if err := binary.Read(buf, binary.LittleEndian, &p.IsFinal); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (p *PartialTranscript) Pack(buf *bytes.Buffer) error {
+	if len(p.Session) > 255 {
+		return errors.New("max_length overflow in field Session")
+	}
+	if err := binary.Write(buf, binary.LittleEndian, uint8(len(p.Session))); err != nil {
+		return err
+	}
+	if _, err := buf.WriteString(p.Session); err != nil {
+		return err
+	}
+	if len(p.Transcript) > 65535 {
+		return errors.New("max_length overflow in field Transcript")
+	}
+	if err := binary.Write(buf, binary.LittleEndian, uint16(len(p.Transcript))); err != nil {
+		return err
+	}
+	if _, err := buf.WriteString(p.Transcript); err != nil {
+		return err
+	}
+	if err := binary.Write(buf, binary.LittleEndian, p.IsFinal); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (p *PartialTranscript) String() string {
+	return fmt.Sprint("Session: {", p.Session, "} ",
+		"Transcript: {", p.Transcript, "} ",
+		"IsFinal: {", p.IsFinal, "}")
+}
+
+// STRUCTURE AccountRevoked
+type AccountRevoked struct {
+	UserId string
+}
+
+func (a *AccountRevoked) Size() uint32 {
+	var result uint32
+	result += 1                     // UserId length (uint_8)
+	result += uint32(len(a.UserId)) // uint_8 array
+	return result
+}
+
+func (a *AccountRevoked) Unpack(buf *bytes.Buffer) error {
+	var UserIdLen uint8
+	if err := binary.Read(buf, binary.LittleEndian, &UserIdLen); err != nil {
+		return err
+	}
+	a.UserId = string(buf.Next(int(UserIdLen)))
+	if len(a.UserId) != int(UserIdLen) {
+		return errors.New("string byte mismatch")
+	}
+	return nil
+}
+
+func (a *AccountRevoked) Pack(buf *bytes.Buffer) error {
+	if len(a.UserId) > 255 {
+		return errors.New("max_length overflow in field UserId")
+	}
+	if err := binary.Write(buf, binary.LittleEndian, uint8(len(a.UserId))); err != nil {
+		return err
+	}
+	if _, err := buf.WriteString(a.UserId); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (a *AccountRevoked) String() string {
+	return fmt.Sprint("UserId: {", a.UserId, "}")
+}
+
 // UNION Message
 type MessageTag uint8
 
 const (
-	MessageTag_Hotword          MessageTag = iota // 0
-	MessageTag_Audio                              // 1
-	MessageTag_AudioDone                          // 2
-	MessageTag_ConnectionCheck                    // 3
-	MessageTag_StopSignal                         // 4
-	MessageTag_TestStarted                        // 5
-	MessageTag_StreamTimeout                      // 6
-	MessageTag_ConnectionResult                   // 7
-	MessageTag_DebugFile                          // 8
-	MessageTag_Result                             // 9
-	MessageTag_Error                              // 10
-	MessageTag_StreamOpen                         // 11
-	MessageTag_INVALID          MessageTag = 255
+	MessageTag_Hotword           MessageTag = iota // 0
+	MessageTag_Audio                               // 1
+	MessageTag_AudioDone                           // 2
+	MessageTag_ConnectionCheck                     // 3
+	MessageTag_StopSignal                          // 4
+	MessageTag_TestStarted                         // 5
+	MessageTag_StreamTimeout                       // 6
+	MessageTag_ConnectionResult                    // 7
+	MessageTag_DebugFile                           // 8
+	MessageTag_Result                              // 9
+	MessageTag_Error                               // 10
+	MessageTag_StreamOpen                          // 11
+	MessageTag_PartialTranscript                   // 12
+	MessageTag_AccountRevoked                      // 13
+	MessageTag_INVALID           MessageTag = 255
 )
 
 type Message struct {
@@ -578,6 +692,18 @@ func (m *Message) unpackStruct(tag MessageTag, buf *bytes.Buffer) (clad.Struct,
 			return nil, err
 		}
 		return &ret, nil
+	case MessageTag_PartialTranscript:
+		var ret PartialTranscript
+		if err := ret.Unpack(buf); err != nil {
+			return nil, err
+		}
+		return &ret, nil
+	case MessageTag_AccountRevoked:
+		var ret AccountRevoked
+		if err := ret.Unpack(buf); err != nil {
+			return nil, err
+		}
+		return &ret, nil
 	default:
 		return nil, errors.New("invalid tag to unpackStruct")
 	}
@@ -628,6 +754,10 @@ func (t MessageTag) String() string {
 		return "Error"
 	case MessageTag_StreamOpen:
 		return "StreamOpen"
+	case MessageTag_PartialTranscript:
+		return "PartialTranscript"
+	case MessageTag_AccountRevoked:
+		return "AccountRevoked"
 	default:
 		return "INVALID"
 	}
@@ -870,3 +1000,41 @@ func NewMessageWithStreamOpen(value *StreamOpen) *Message {
 	ret.SetStreamOpen(value)
 	return &ret
 }
+
+func (m *Message) GetPartialTranscript() *PartialTranscript {
+	if m.tag == nil || *m.tag != MessageTag_PartialTranscript {
+		return nil
+	}
+	return m.value.(*PartialTranscript)
+}
+
+func (m *Message) SetPartialTranscript(value *PartialTranscript) {
+	newTag := MessageTag_PartialTranscript
+	m.tag = &newTag
+	m.value = value
+}
+
+func NewMessageWithPartialTranscript(value *PartialTranscript) *Message {
+	var ret Message
+	ret.SetPartialTranscript(value)
+	return &ret
+}
+
+func (m *Message) GetAccountRevoked() *AccountRevoked {
+	if m.tag == nil || *m.tag != MessageTag_AccountRevoked {
+		return nil
+	}
+	return m.value.(*AccountRevoked)
+}
+
+func (m *Message) SetAccountRevoked(value *AccountRevoked) {
+	newTag := MessageTag_AccountRevoked
+	m.tag = &newTag
+	m.value = value
+}
+
+func NewMessageWithAccountRevoked(value *AccountRevoked) *Message {
+	var ret Message
+	ret.SetAccountRevoked(value)
+	return &ret
+}
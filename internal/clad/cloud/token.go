@@ -22,6 +22,8 @@ const (
 	TokenError_InvalidToken
 	TokenError_Connection
 	TokenError_WrongAccount
+	TokenError_InvalidSignature
+	TokenError_Revoked
 )
 
 // STRUCTURE AuthRequest
@@ -347,11 +349,14 @@ func (s *SecondaryAuthRequest) String() string {
 // STRUCTURE JwtRequest
 type JwtRequest struct {
 	ForceRefresh bool
+	UserId       string
 }
 
 func (j *JwtRequest) Size() uint32 {
 	var result uint32
-	result += 1 // ForceRefresh bool
+	result += 1                     // ForceRefresh bool
+	result += 1                     // UserId length (uint_8)
+	result += uint32(len(j.UserId)) // uint_8 array
 	return result
 }
 
@@ -359,6 +364,14 @@ func (j *JwtRequest) Unpack(buf *bytes.Buffer) error {
 	if err := binary.Read(buf, binary.LittleEndian, &j.ForceRefresh); err != nil {
 		return err
 	}
+	var UserIdLen uint8
+	if err := binary.Read(buf, binary.LittleEndian, &UserIdLen); err != nil {
+		return err
+	}
+	j.UserId = string(buf.Next(int(UserIdLen)))
+	if len(j.UserId) != int(UserIdLen) {
+		return errors.New("string byte mismatch")
+	}
 	return nil
 }
 
@@ -366,11 +379,21 @@ func (j *JwtRequest) Pack(buf *bytes.Buffer) error {
 	if err := binary.Write(buf, binary.LittleEndian, j.ForceRefresh); err != nil {
 		return err
 	}
+	if len(j.UserId) > 255 {
+		return errors.New("max_length overflow in field UserId")
+	}
+	if err := binary.Write(buf, binary.LittleEndian, uint8(len(j.UserId))); err != nil {
+		return err
+	}
+	if _, err := buf.WriteString(j.UserId); err != nil {
+		return err
+	}
 	return nil
 }
 
 func (j *JwtRequest) String() string {
-	return fmt.Sprint("ForceRefresh: {", j.ForceRefresh, "}")
+	return fmt.Sprint("ForceRefresh: {", j.ForceRefresh, "} ",
+		"UserId: {", j.UserId, "}")
 }
 
 // STRUCTURE JwtResponse
@@ -423,6 +446,152 @@ func (j *JwtResponse) String() string {
 		"Error: {", j.Error, "}")
 }
 
+// STRUCTURE StatusResponse
+type StatusResponse struct {
+	UserId        string
+	RequestorId   string
+	IssuedAt      int64
+	RefreshAt     int64
+	ExpiresAt     int64
+	StsExpiresAt  int64
+	BackingOff    bool
+	Denied        bool
+	LastError     string
+	LastErrorTime int64
+}
+
+func (s *StatusResponse) Size() uint32 {
+	var result uint32
+	result += 1                          // UserId length (uint_8)
+	result += uint32(len(s.UserId))      // uint_8 array
+	result += 1                          // RequestorId length (uint_8)
+	result += uint32(len(s.RequestorId)) // uint_8 array
+	result += 8                          // IssuedAt int_64
+	result += 8                          // RefreshAt int_64
+	result += 8                          // ExpiresAt int_64
+	result += 8                          // StsExpiresAt int_64
+	result += 1                          // BackingOff bool
+	result += 1                          // Denied bool
+	result += 1                          // LastError length (uint_8)
+	result += uint32(len(s.LastError))   // uint_8 array
+	result += 8                          // LastErrorTime int_64
+	return result
+}
+
+func (s *StatusResponse) Unpack(buf *bytes.Buffer) error {
+	var UserIdLen uint8
+	if err := binary.Read(buf, binary.LittleEndian, &UserIdLen); err != nil {
+		return err
+	}
+	s.UserId = string(buf.Next(int(UserIdLen)))
+	if len(s.UserId) != int(UserIdLen) {
+		return errors.New("string byte mismatch")
+	}
+	var RequestorIdLen uint8
+	if err := binary.Read(buf, binary.LittleEndian, &RequestorIdLen); err != nil {
+		return err
+	}
+	s.RequestorId = string(buf.Next(int(RequestorIdLen)))
+	if len(s.RequestorId) != int(RequestorIdLen) {
+		return errors.New("string byte mismatch")
+	}
+	if err := binary.Read(buf, binary.LittleEndian, &s.IssuedAt); err != nil {
+		return err
+	}
+	if err := binary.Read(buf, binary.LittleEndian, &s.RefreshAt); err != nil {
+		return err
+	}
+	if err := binary.Read(buf, binary.LittleEndian, &s.ExpiresAt); err != nil {
+		return err
+	}
+	if err := binary.Read(buf, binary.LittleEndian, &s.StsExpiresAt); err != nil {
+		return err
+	}
+	if err := binary.Read(buf, binary.LittleEndian, &s.BackingOff); err != nil {
+		return err
+	}
+	if err := binary.Read(buf, binary.LittleEndian, &s.Denied); err != nil {
+		return err
+	}
+	var LastErrorLen uint8
+	if err := binary.Read(buf, binary.LittleEndian, &LastErrorLen); err != nil {
+		return err
+	}
+	s.LastError = string(buf.Next(int(LastErrorLen)))
+	if len(s.LastError) != int(LastErrorLen) {
+		return errors.New("string byte mismatch")
+	}
+	if err := binary.Read(buf, binary.LittleEndian, &s.LastErrorTime); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *StatusResponse) Pack(buf *bytes.Buffer) error {
+	if len(s.UserId) > 255 {
+		return errors.New("max_length overflow in field UserId")
+	}
+	if err := binary.Write(buf, binary.LittleEndian, uint8(len(s.UserId))); err != nil {
+		return err
+	}
+	if _, err := buf.WriteString(s.UserId); err != nil {
+		return err
+	}
+	if len(s.RequestorId) > 255 {
+		return errors.New("max_length overflow in field RequestorId")
+	}
+	if err := binary.Write(buf, binary.LittleEndian, uint8(len(s.RequestorId))); err != nil {
+		return err
+	}
+	if _, err := buf.WriteString(s.RequestorId); err != nil {
+		return err
+	}
+	if err := binary.Write(buf, binary.LittleEndian, s.IssuedAt); err != nil {
+		return err
+	}
+	if err := binary.Write(buf, binary.LittleEndian, s.RefreshAt); err != nil {
+		return err
+	}
+	if err := binary.Write(buf, binary.LittleEndian, s.ExpiresAt); err != nil {
+		return err
+	}
+	if err := binary.Write(buf, binary.LittleEndian, s.StsExpiresAt); err != nil {
+		return err
+	}
+	if err := binary.Write(buf, binary.LittleEndian, s.BackingOff); err != nil {
+		return err
+	}
+	if err := binary.Write(buf, binary.LittleEndian, s.Denied); err != nil {
+		return err
+	}
+	if len(s.LastError) > 255 {
+		return errors.New("max_length overflow in field LastError")
+	}
+	if err := binary.Write(buf, binary.LittleEndian, uint8(len(s.LastError))); err != nil {
+		return err
+	}
+	if _, err := buf.WriteString(s.LastError); err != nil {
+		return err
+	}
+	if err := binary.Write(buf, binary.LittleEndian, s.LastErrorTime); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *StatusResponse) String() string {
+	return fmt.Sprint("UserId: {", s.UserId, "} ",
+		"RequestorId: {", s.RequestorId, "} ",
+		"IssuedAt: {", s.IssuedAt, "} ",
+		"RefreshAt: {", s.RefreshAt, "} ",
+		"ExpiresAt: {", s.ExpiresAt, "} ",
+		"StsExpiresAt: {", s.StsExpiresAt, "} ",
+		"BackingOff: {", s.BackingOff, "} ",
+		"Denied: {", s.Denied, "} ",
+		"LastError: {", s.LastError, "} ",
+		"LastErrorTime: {", s.LastErrorTime, "}")
+}
+
 // UNION TokenRequest
 type TokenRequestTag uint8
 
@@ -431,6 +600,7 @@ const (
 	TokenRequestTag_Secondary                          // 1
 	TokenRequestTag_Reassociate                        // 2
 	TokenRequestTag_Jwt                                // 3
+	TokenRequestTag_Status                             // 4
 	TokenRequestTag_INVALID     TokenRequestTag = 255
 )
 
@@ -493,6 +663,12 @@ func (m *TokenRequest) unpackStruct(tag TokenRequestTag, buf *bytes.Buffer) (cla
 			return nil, err
 		}
 		return &ret, nil
+	case TokenRequestTag_Status:
+		var ret Void
+		if err := ret.Unpack(buf); err != nil {
+			return nil, err
+		}
+		return &ret, nil
 	default:
 		return nil, errors.New("invalid tag to unpackStruct")
 	}
@@ -527,6 +703,8 @@ func (t TokenRequestTag) String() string {
 		return "Reassociate"
 	case TokenRequestTag_Jwt:
 		return "Jwt"
+	case TokenRequestTag_Status:
+		return "Status"
 	default:
 		return "INVALID"
 	}
@@ -618,12 +796,32 @@ func NewTokenRequestWithJwt(value *JwtRequest) *TokenRequest {
 	return &ret
 }
 
+func (m *TokenRequest) GetStatus() *Void {
+	if m.tag == nil || *m.tag != TokenRequestTag_Status {
+		return nil
+	}
+	return m.value.(*Void)
+}
+
+func (m *TokenRequest) SetStatus(value *Void) {
+	newTag := TokenRequestTag_Status
+	m.tag = &newTag
+	m.value = value
+}
+
+func NewTokenRequestWithStatus(value *Void) *TokenRequest {
+	var ret TokenRequest
+	ret.SetStatus(value)
+	return &ret
+}
+
 // UNION TokenResponse
 type TokenResponseTag uint8
 
 const (
 	TokenResponseTag_Auth    TokenResponseTag = iota // 0
 	TokenResponseTag_Jwt                             // 1
+	TokenResponseTag_Status                          // 2
 	TokenResponseTag_INVALID TokenResponseTag = 255
 )
 
@@ -674,6 +872,12 @@ func (m *TokenResponse) unpackStruct(tag TokenResponseTag, buf *bytes.Buffer) (c
 			return nil, err
 		}
 		return &ret, nil
+	case TokenResponseTag_Status:
+		var ret StatusResponse
+		if err := ret.Unpack(buf); err != nil {
+			return nil, err
+		}
+		return &ret, nil
 	default:
 		return nil, errors.New("invalid tag to unpackStruct")
 	}
@@ -704,6 +908,8 @@ func (t TokenResponseTag) String() string {
 		return "Auth"
 	case TokenResponseTag_Jwt:
 		return "Jwt"
+	case TokenResponseTag_Status:
+		return "Status"
 	default:
 		return "INVALID"
 	}
@@ -756,3 +962,22 @@ func NewTokenResponseWithJwt(value *JwtResponse) *TokenResponse {
 	ret.SetJwt(value)
 	return &ret
 }
+
+func (m *TokenResponse) GetStatus() *StatusResponse {
+	if m.tag == nil || *m.tag != TokenResponseTag_Status {
+		return nil
+	}
+	return m.value.(*StatusResponse)
+}
+
+func (m *TokenResponse) SetStatus(value *StatusResponse) {
+	newTag := TokenResponseTag_Status
+	m.tag = &newTag
+	m.value = value
+}
+
+func NewTokenResponseWithStatus(value *StatusResponse) *TokenResponse {
+	var ret TokenResponse
+	ret.SetStatus(value)
+	return &ret
+}
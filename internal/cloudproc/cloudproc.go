@@ -7,6 +7,7 @@ import (
 	"github.com/digital-dream-labs/vector-cloud/internal/log"
 	"github.com/digital-dream-labs/vector-cloud/internal/logcollector"
 	"github.com/digital-dream-labs/vector-cloud/internal/offboard_vision"
+	"github.com/digital-dream-labs/vector-cloud/internal/robot"
 	"github.com/digital-dream-labs/vector-cloud/internal/token"
 	"github.com/digital-dream-labs/vector-cloud/internal/token/identity"
 	"github.com/digital-dream-labs/vector-cloud/internal/voice"
@@ -37,7 +38,7 @@ func Run(ctx context.Context, procOptions ...Option) {
 		// file backed identity provider with platform specific storage paths for JWT
 		// and certs (see getcert_*.go files)
 		var err error
-		if identityProvider, err = identity.NewFileProvider("", ""); err != nil {
+		if identityProvider, err = identity.NewFileProvider("", "", identity.ConfigOptions()...); err != nil {
 			log.Println("Fatal error initializing default identity provider:", err)
 			return
 		}
@@ -53,7 +54,18 @@ func Run(ctx context.Context, procOptions ...Option) {
 		tokenServer.Run(ctx, opts.tokenOpts...)
 	})
 	tokener := token.GetAccessor(identityProvider, tokenServer)
+
+	// warn ahead of certificate expiry, and pick up renewed certificates
+	certMonitor := identity.NewCertMonitor()
+	if reloader, ok := identityProvider.(identity.CertReloader); ok {
+		certMonitor.Watch("device", reloader.KeySource(), reloader.ReloadCertificate)
+	}
+	certMonitor.Watch("gateway", robot.GatewayKeySource(), nil)
+	launchProcess(&wg, func() {
+		certMonitor.Run(ctx)
+	})
 	if opts.voice != nil {
+		addHandlers(voice.GetDevHandlers, tokenServer)
 		launchProcess(&wg, func() {
 			// provide default token accessor
 			voiceOpts := append([]voice.Option{voice.WithTokener(tokener),
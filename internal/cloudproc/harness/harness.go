@@ -39,3 +39,25 @@ func CreateMemProcess(ctx context.Context, options ...cloudproc.Option) (Harness
 		MsgIO:  io,
 		intent: intentResult}, nil
 }
+
+// CreateMemClients starts a single cloud process with the given number of in-memory
+// clients attached to it. Each client only receives the results of its own requests,
+// so they can make requests concurrently.
+func CreateMemClients(ctx context.Context, count int, options ...cloudproc.Option) ([]Harness, error) {
+	process := &voice.Process{}
+	clients := make([]Harness, count)
+	for i := range clients {
+		intentResult := make(chan *cloud.Message)
+		io, receiver := voice.NewMemPipe()
+		process.AddRoutedReceiver(receiver, &voice.ChanMsgSender{Ch: intentResult})
+		clients[i] = &memHarness{
+			MsgIO:  io,
+			intent: intentResult}
+	}
+
+	options = append(options, cloudproc.WithVoice(process))
+
+	go cloudproc.Run(ctx, options...)
+
+	return clients, nil
+}
@@ -0,0 +1,189 @@
+package harness
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/digital-dream-labs/vector-cloud/internal/clad/cloud"
+	"github.com/digital-dream-labs/vector-cloud/internal/cloudproc"
+	"github.com/digital-dream-labs/vector-cloud/internal/util"
+	"github.com/digital-dream-labs/vector-cloud/internal/voice"
+	"github.com/digital-dream-labs/vector-cloud/internal/voice/recording"
+	"github.com/digital-dream-labs/vector-cloud/internal/voice/stream"
+)
+
+// Connector creates the connection a recorded session should be replayed against
+type Connector func(session *recording.Session) stream.ConnectFunc
+
+// ReplayResult is the outcome of replaying a single recorded session
+type ReplayResult struct {
+	Session *recording.Session
+	Result  *cloud.IntentResult
+	Error   *cloud.IntentError
+	// Diff describes how the outcome differs from the recorded one; it's empty if they match
+	Diff string
+}
+
+// Replayer feeds recorded voice sessions through an in-memory cloud process
+type Replayer struct {
+	harness   Harness
+	connector Connector
+	realtime  bool
+	responses chan *cloud.Message
+
+	mu        sync.Mutex
+	current   *recording.Session
+	uploading *uploadSignal
+}
+
+// uploadSignal is closed once audio of the session being replayed reaches its
+// connection; until then the stream may not be connected, and can't be told that
+// no more audio is coming
+type uploadSignal struct {
+	once sync.Once
+	ch   chan struct{}
+}
+
+func (u *uploadSignal) signal() {
+	u.once.Do(func() { close(u.ch) })
+}
+
+type replayConn struct {
+	stream.Conn
+	uploading *uploadSignal
+}
+
+func (c *replayConn) SendAudio(data []byte) error {
+	c.uploading.signal()
+	return c.Conn.SendAudio(data)
+}
+
+// NewReplayer starts an in-memory cloud process for replaying sessions. Each session's
+// audio is streamed to a connection created by the given connector; if it's nil,
+// recording.StubConnect is used, which replays the recorded outcome. If realtime is
+// set, audio is sent with the timing it was recorded with.
+func NewReplayer(ctx context.Context, connector Connector, realtime bool,
+	options ...cloudproc.Option) (*Replayer, error) {
+	if connector == nil {
+		connector = recording.StubConnect
+	}
+	r := &Replayer{
+		connector: connector,
+		realtime:  realtime,
+		responses: make(chan *cloud.Message),
+	}
+	options = append(options, cloudproc.WithVoiceOptions(voice.WithConnectFunc(r.connect)))
+	h, err := CreateMemProcess(ctx, options...)
+	if err != nil {
+		return nil, err
+	}
+	r.harness = h
+	go func() {
+		for {
+			msg, err := h.ReadMessage()
+			if err != nil {
+				return
+			}
+			select {
+			case r.responses <- msg:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return r, nil
+}
+
+func (r *Replayer) connect(ctx context.Context) (stream.Conn, *stream.CloudError) {
+	r.mu.Lock()
+	session, uploading := r.current, r.uploading
+	r.mu.Unlock()
+	if session == nil {
+		return nil, &stream.CloudError{Kind: cloud.ErrorType_InvalidConfig,
+			Err: errors.New("stream opened with no session being replayed")}
+	}
+	conn, cerr := r.connector(session)(ctx)
+	if cerr != nil {
+		return nil, cerr
+	}
+	return &replayConn{Conn: conn, uploading: uploading}, nil
+}
+
+// Replay sends the given session's hotword and audio to the cloud process and waits
+// for the resulting intent or error
+func (r *Replayer) Replay(ctx context.Context, session *recording.Session) (*ReplayResult, error) {
+	uploading := &uploadSignal{ch: make(chan struct{})}
+	r.mu.Lock()
+	r.current = session
+	r.uploading = uploading
+	r.mu.Unlock()
+
+	hw := session.Hotword
+	if err := r.harness.Send(cloud.NewMessageWithHotword(&hw)); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	for i := range session.Audio {
+		chunk := &session.Audio[i]
+		if r.realtime {
+			wait := time.Duration(chunk.OffsetMs)*time.Millisecond - time.Since(start)
+			if util.SleepSelect(wait, ctx.Done()) {
+				return nil, ctx.Err()
+			}
+		}
+		msg := cloud.NewMessageWithAudio(&cloud.AudioData{Data: chunk.Samples()})
+		if err := r.harness.Send(msg); err != nil {
+			return nil, err
+		}
+	}
+
+	// replaying is faster than connecting, so wait for the stream before saying the
+	// audio is done; a session that fails to connect still gets a response
+	uploadStarted := uploading.ch
+	for {
+		select {
+		case <-uploadStarted:
+			uploadStarted = nil
+			if err := r.harness.Send(cloud.NewMessageWithAudioDone(&cloud.Void{})); err != nil {
+				return nil, err
+			}
+		case msg := <-r.responses:
+			switch msg.Tag() {
+			case cloud.MessageTag_Result:
+				result := msg.GetResult()
+				return &ReplayResult{Session: session, Result: result,
+					Diff: session.Diff(result, nil)}, nil
+			case cloud.MessageTag_Error:
+				intentErr := msg.GetError()
+				return &ReplayResult{Session: session, Error: intentErr,
+					Diff: session.Diff(nil, intentErr)}, nil
+			}
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// ReplayDir replays every session recorded in the given directory, in the order they
+// were recorded
+func (r *Replayer) ReplayDir(ctx context.Context, dir string) ([]*ReplayResult, error) {
+	files, err := recording.Files(dir)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]*ReplayResult, 0, len(files))
+	for _, file := range files {
+		session, err := recording.Load(file)
+		if err != nil {
+			return results, err
+		}
+		result, err := r.Replay(ctx, session)
+		if err != nil {
+			return results, err
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
@@ -0,0 +1,109 @@
+package harness_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/digital-dream-labs/vector-cloud/internal/clad/cloud"
+	"github.com/digital-dream-labs/vector-cloud/internal/cloudproc"
+	"github.com/digital-dream-labs/vector-cloud/internal/cloudproc/harness"
+	"github.com/digital-dream-labs/vector-cloud/internal/robot"
+	"github.com/digital-dream-labs/vector-cloud/internal/token/identity"
+	"github.com/digital-dream-labs/vector-cloud/internal/voice/recording"
+	"github.com/digital-dream-labs/vector-cloud/internal/voice/stream"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	replayerOnce sync.Once
+	replayer     *harness.Replayer
+	// replayedAs is what the server now makes of every session; nil replays what was recorded
+	replayedAs *recording.Session
+)
+
+// getReplayer returns the replayer shared by all tests, since only one cloud process
+// can run in a test binary
+func getReplayer(t *testing.T) *harness.Replayer {
+	replayerOnce.Do(func() {
+		dir, err := ioutil.TempDir("", "replay")
+		require.NoError(t, err)
+
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+		tmpl := x509.Certificate{
+			SerialNumber: big.NewInt(1),
+			Subject:      pkix.Name{CommonName: "vic:00000000"},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(365 * 24 * time.Hour),
+		}
+		der, err := x509.CreateCertificate(rand.Reader, &tmpl, &tmpl, &key.PublicKey, key)
+		require.NoError(t, err)
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, robot.CertFilename),
+			pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+
+		provider, err := identity.NewFileProvider(filepath.Join(dir, "token"), dir)
+		require.NoError(t, err)
+		replayer, err = harness.NewReplayer(context.Background(), func(s *recording.Session) stream.ConnectFunc {
+			if replayedAs != nil {
+				return recording.StubConnect(replayedAs)
+			}
+			return recording.StubConnect(s)
+		}, false, cloudproc.WithIdentityProvider(provider))
+		require.NoError(t, err)
+	})
+	require.NotNil(t, replayer)
+	return replayer
+}
+
+func recordedSession() *recording.Session {
+	s := recording.NewSession(&cloud.Hotword{Mode: cloud.StreamType_Normal, Locale: "en-US"})
+	for i := 0; i < 10; i++ {
+		s.AddAudio(make([]int16, 1600))
+	}
+	s.Result = &cloud.IntentResult{Intent: "intent_clock_settimer_extend", Parameters: `{"timer_duration":"5"}`}
+	return s
+}
+
+func TestReplayDiff(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	r := getReplayer(t)
+	defer func() { replayedAs = nil }()
+
+	// what was recorded replays the same
+	result, err := r.Replay(ctx, recordedSession())
+	require.NoError(t, err)
+	assert.Equal(t, "", result.Diff)
+
+	// unless the server now understands the request differently
+	replayedAs = &recording.Session{Result: &cloud.IntentResult{Intent: "intent_clock_settimer_extend",
+		Parameters: `{"timer_duration":"6"}`}}
+	result, err = r.Replay(ctx, recordedSession())
+	require.NoError(t, err)
+	require.NotNil(t, result.Result)
+	assert.Equal(t, "intent_clock_settimer_extend", result.Result.Intent)
+	assert.Equal(t, "parameters: recorded map[timer_duration:5], replayed map[timer_duration:6]", result.Diff)
+
+	// or fails
+	replayedAs = &recording.Session{Error: &cloud.IntentError{Error: cloud.ErrorType_Server, Extra: "down"}}
+	result, err = r.Replay(ctx, recordedSession())
+	require.NoError(t, err)
+	require.NotNil(t, result.Error)
+	assert.Equal(t, cloud.ErrorType_Server, result.Error.Error)
+	assert.Equal(t, fmt.Sprintf("intent: recorded intent_clock_settimer_extend, replayed error %v (down)",
+		cloud.ErrorType_Server), result.Diff)
+}
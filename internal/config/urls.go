@@ -14,6 +14,32 @@ type URLs struct {
 	LogFiles       string  `json:"logfiles"`
 	AppKey         string  `json:"appkey"`
 	OffboardVision *string `json:"offboard_vision,omitempty"`
+	// ChipperEndpoints optionally lists chipper servers in order of preference;
+	// voice streams fail over down the list when a server is unreachable
+	ChipperEndpoints []string `json:"chipper_endpoints,omitempty"`
+	// JWKS optionally holds the JSON Web Key Set that tokens from the token server
+	// must be signed with, or JWKSFile the path of a file holding it
+	JWKS     json.RawMessage `json:"jwks,omitempty"`
+	JWKSFile string          `json:"jwks_file,omitempty"`
+	// LocalToken optionally names the config file of a local token server to run
+	// in-process, in place of the token service at Token
+	LocalToken string `json:"local_token,omitempty"`
+}
+
+// ChipperURLs returns the ordered list of chipper servers that should be tried
+// for voice requests: ChipperEndpoints, followed by Chipper if it isn't already
+// in that list
+func (u *URLs) ChipperURLs() []string {
+	var ret []string
+	seen := make(map[string]bool)
+	for _, url := range append(append([]string{}, u.ChipperEndpoints...), u.Chipper) {
+		if url == "" || seen[url] {
+			continue
+		}
+		seen[url] = true
+		ret = append(ret, url)
+	}
+	return ret
 }
 
 // DefaultURLs provides a default, hard-coded configuration that can be used
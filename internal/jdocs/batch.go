@@ -0,0 +1,189 @@
+package jdocs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/digital-dream-labs/vector-cloud/internal/clad/cloud"
+
+	"github.com/digital-dream-labs/vector-cloud/internal/log"
+
+	pb "github.com/digital-dream-labs/api/go/jdocspb"
+)
+
+// docRef identifies a document on the server
+type docRef struct {
+	account string
+	thing   string
+	docName string
+}
+
+// appliedOp is a change of a batch that the server accepted, so that it can be undone
+type appliedOp struct {
+	ref docRef
+	// status is where the change's result is in the response
+	status *cloud.WriteResponse
+	// version is the document's version after a write; deleted is set for a delete
+	version uint64
+	deleted bool
+}
+
+// batchRequest makes the writes and then the deletes of a batch, one at a time.
+//
+// The server has no transactions, so an atomic batch is emulated: the versions of the
+// documents written are checked before anything is changed, and if a change fails
+// after that, those already made are undone by putting back what was there before,
+// and reported as aborted. Documents changed elsewhere while a batch is being undone
+// can still be left inconsistent; that's logged.
+func (c *conn) batchRequest(ctx context.Context, req *cloud.BatchRequest) (*cloud.DocResponse, error) {
+	writes, deletes := (*cladBatchReq)(req).toProto()
+	ret := &cloud.BatchResponse{
+		Writes:  make([]cloud.WriteResponse, len(writes)),
+		Deletes: make([]cloud.WriteResponse, len(deletes)),
+	}
+	for i := range ret.Writes {
+		ret.Writes[i].Status = cloud.WriteStatus_Aborted
+	}
+	for i := range ret.Deletes {
+		ret.Deletes[i].Status = cloud.WriteStatus_Aborted
+	}
+
+	var prior map[docRef]*pb.Jdoc
+	if req.Atomic {
+		var err error
+		if prior, err = c.snapshot(ctx, (*cladBatchReq)(req)); err != nil {
+			return connectErrorResponse, err
+		}
+		conflict := false
+		for i, w := range writes {
+			var version uint64
+			if doc := prior[docRef{w.UserId, w.Thing, w.DocName}]; doc != nil {
+				version = doc.DocVersion
+			}
+			if w.Doc.DocVersion != version {
+				ret.Writes[i] = cloud.WriteResponse{Status: cloud.WriteStatus_RejectedDocVersion, LatestVersion: version}
+				conflict = true
+			}
+		}
+		if conflict {
+			return cloud.NewDocResponseWithBatch(ret), nil
+		}
+	}
+
+	var applied []appliedOp
+	abort := func(err error) (*cloud.DocResponse, error) {
+		if req.Atomic {
+			c.rollback(applied, prior)
+		}
+		return cloud.NewDocResponseWithBatch(ret), err
+	}
+	for i, w := range writes {
+		resp, err := c.client.WriteDoc(withAccount(ctx, w.UserId), w)
+		if err != nil {
+			ret.Writes[i].Status = cloud.WriteStatus_Error
+			return abort(err)
+		}
+		ret.Writes[i] = *(*protoWriteResp)(resp).toClad()
+		if ret.Writes[i].Status != cloud.WriteStatus_Accepted {
+			if req.Atomic {
+				return abort(nil)
+			}
+			continue
+		}
+		applied = append(applied, appliedOp{
+			ref:     docRef{w.UserId, w.Thing, w.DocName},
+			status:  &ret.Writes[i],
+			version: resp.LatestDocVersion,
+		})
+	}
+	for i, d := range deletes {
+		if _, err := c.client.DeleteDoc(withAccount(ctx, d.UserId), d); err != nil {
+			ret.Deletes[i].Status = cloud.WriteStatus_Error
+			return abort(err)
+		}
+		ret.Deletes[i].Status = cloud.WriteStatus_Accepted
+		applied = append(applied, appliedOp{
+			ref:     docRef{d.UserId, d.Thing, d.DocName},
+			status:  &ret.Deletes[i],
+			deleted: true,
+		})
+	}
+	ret.Committed = len(applied) == len(writes)+len(deletes)
+	return cloud.NewDocResponseWithBatch(ret), nil
+}
+
+// snapshot reads the current copies of the documents a batch changes; those that
+// don't exist are nil
+func (c *conn) snapshot(ctx context.Context, req *cladBatchReq) (map[docRef]*pb.Jdoc, error) {
+	ret := make(map[docRef]*pb.Jdoc)
+	for _, read := range req.snapshotReqs() {
+		resp, err := c.client.ReadDocs(withAccount(ctx, read.UserId), read)
+		if err != nil {
+			return nil, err
+		}
+		if len(resp.Items) != len(read.Items) {
+			return nil, errors.New("jdocs server returned the wrong number of documents")
+		}
+		for i, item := range resp.Items {
+			ref := docRef{read.UserId, read.Thing, read.Items[i].DocName}
+			if item.Status == pb.ReadDocsResp_NOT_FOUND {
+				ret[ref] = nil
+			} else {
+				ret[ref] = item.Doc
+			}
+		}
+	}
+	return ret, nil
+}
+
+// rollback undoes the changes of an atomic batch, latest first, marking those it
+// undid as aborted. It doesn't use the batch's context, which may be what ran out.
+func (c *conn) rollback(applied []appliedOp, prior map[docRef]*pb.Jdoc) {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	for i := len(applied) - 1; i >= 0; i-- {
+		op := applied[i]
+		if err := c.undo(withAccount(ctx, op.ref.account), op, prior[op.ref]); err != nil {
+			log.Printf("Couldn't roll back batch change of %s: %v\n", op.ref.docName, err)
+			log.Das("jdocs.rollback_failed", (&log.DasFields{}).SetStrings(op.ref.docName, err.Error()))
+			continue
+		}
+		op.status.Status = cloud.WriteStatus_Aborted
+		op.status.LatestVersion = 0
+	}
+}
+
+// undo puts back the given copy of a document from before it was changed
+func (c *conn) undo(ctx context.Context, op appliedOp, before *pb.Jdoc) error {
+	if before == nil {
+		if op.deleted {
+			return nil
+		}
+		_, err := c.client.DeleteDoc(ctx, &pb.DeleteDocReq{UserId: op.ref.account, Thing: op.ref.thing, DocName: op.ref.docName})
+		return err
+	}
+	// a deleted document is created again, a written one written over
+	var version uint64
+	if !op.deleted {
+		version = op.version
+	}
+	resp, err := c.client.WriteDoc(ctx, &pb.WriteDocReq{
+		UserId:  op.ref.account,
+		Thing:   op.ref.thing,
+		DocName: op.ref.docName,
+		Doc: &pb.Jdoc{
+			DocVersion:     version,
+			FmtVersion:     before.FmtVersion,
+			ClientMetadata: before.ClientMetadata,
+			JsonDoc:        before.JsonDoc,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if resp.Status != pb.WriteDocResp_ACCEPTED {
+		return fmt.Errorf("rejected with %v", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package jdocs
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/digital-dream-labs/vector-cloud/internal/clad/cloud"
+
+	pb "github.com/digital-dream-labs/api/go/jdocspb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func batchWrite(docName string, version uint64, json string) cloud.WriteRequest {
+	return cloud.WriteRequest{
+		Thing:   "vic:00000000",
+		DocName: docName,
+		Doc:     cloud.Doc{DocVersion: version, JsonDoc: json},
+	}
+}
+
+func batchDelete(docName string) cloud.DeleteRequest {
+	return cloud.DeleteRequest{Thing: "vic:00000000", DocName: docName}
+}
+
+func sendBatch(t *testing.T, server *testServer, req *cloud.BatchRequest) (*cloud.BatchResponse, error) {
+	c := &conn{client: server}
+	resp, err := c.handleRequest(context.Background(), cloud.NewDocRequestWithBatch(req))
+	require.NotNil(t, resp.GetBatch())
+	return resp.GetBatch(), err
+}
+
+func statuses(results []cloud.WriteResponse) []cloud.WriteStatus {
+	ret := make([]cloud.WriteStatus, len(results))
+	for i, r := range results {
+		ret[i] = r.Status
+	}
+	return ret
+}
+
+func TestBatch(t *testing.T) {
+	server := newTestServer()
+	server.docs["b"] = &pb.Jdoc{DocVersion: 1, JsonDoc: `{"b":1}`}
+	server.docs["c"] = &pb.Jdoc{DocVersion: 1, JsonDoc: `{"c":1}`}
+
+	// without atomic, each change stands on its own
+	resp, err := sendBatch(t, server, &cloud.BatchRequest{
+		Writes:  []cloud.WriteRequest{batchWrite("a", 0, `{"a":1}`), batchWrite("b", 0, `{"b":2}`)},
+		Deletes: []cloud.DeleteRequest{batchDelete("c")},
+	})
+	require.NoError(t, err)
+	assert.False(t, resp.Committed)
+	assert.Equal(t, []cloud.WriteStatus{cloud.WriteStatus_Accepted, cloud.WriteStatus_RejectedDocVersion}, statuses(resp.Writes))
+	assert.Equal(t, []cloud.WriteStatus{cloud.WriteStatus_Accepted}, statuses(resp.Deletes))
+	assert.Equal(t, uint64(1), resp.Writes[0].LatestVersion)
+	assert.Equal(t, uint64(1), resp.Writes[1].LatestVersion)
+	assert.Contains(t, server.docs, "a")
+	assert.NotContains(t, server.docs, "c")
+
+	// atomic batches check versions before changing anything
+	resp, err = sendBatch(t, server, &cloud.BatchRequest{
+		Atomic: true,
+		Writes: []cloud.WriteRequest{batchWrite("a", 1, `{"a":2}`), batchWrite("b", 0, `{"b":2}`)},
+	})
+	require.NoError(t, err)
+	assert.False(t, resp.Committed)
+	assert.Equal(t, []cloud.WriteStatus{cloud.WriteStatus_Aborted, cloud.WriteStatus_RejectedDocVersion}, statuses(resp.Writes))
+	assert.Equal(t, uint64(1), resp.Writes[1].LatestVersion)
+	assert.Equal(t, uint64(1), server.docs["a"].DocVersion)
+
+	// and are committed whole
+	resp, err = sendBatch(t, server, &cloud.BatchRequest{
+		Atomic:  true,
+		Writes:  []cloud.WriteRequest{batchWrite("a", 1, `{"a":2}`), batchWrite("c", 0, `{"c":2}`)},
+		Deletes: []cloud.DeleteRequest{batchDelete("b")},
+	})
+	require.NoError(t, err)
+	assert.True(t, resp.Committed)
+	assert.Equal(t, `{"a":2}`, server.docs["a"].JsonDoc)
+	assert.Equal(t, `{"c":2}`, server.docs["c"].JsonDoc)
+	assert.NotContains(t, server.docs, "b")
+
+	// or not at all, undoing what was done before a change failed
+	server.docs["b"] = &pb.Jdoc{DocVersion: 1, JsonDoc: `{"b":1}`}
+	server.failDoc = "d"
+	resp, err = sendBatch(t, server, &cloud.BatchRequest{
+		Atomic:  true,
+		Writes:  []cloud.WriteRequest{batchWrite("a", 2, `{"a":3}`), batchWrite("e", 0, `{"e":1}`), batchWrite("d", 0, `{}`)},
+		Deletes: []cloud.DeleteRequest{batchDelete("b")},
+	})
+	assert.Error(t, err)
+	assert.False(t, resp.Committed)
+	assert.Equal(t, []cloud.WriteStatus{cloud.WriteStatus_Aborted, cloud.WriteStatus_Aborted, cloud.WriteStatus_Error},
+		statuses(resp.Writes))
+	assert.Equal(t, []cloud.WriteStatus{cloud.WriteStatus_Aborted}, statuses(resp.Deletes))
+	assert.Equal(t, `{"a":2}`, server.docs["a"].JsonDoc)
+	assert.NotContains(t, server.docs, "e")
+	assert.Equal(t, `{"b":1}`, server.docs["b"].JsonDoc)
+
+	// deletes are undone by creating the document again
+	server.failDoc = "b"
+	resp, err = sendBatch(t, server, &cloud.BatchRequest{
+		Atomic:  true,
+		Writes:  []cloud.WriteRequest{batchWrite("b", 1, `{"b":2}`)},
+		Deletes: []cloud.DeleteRequest{batchDelete("c")},
+	})
+	assert.Error(t, err)
+	assert.Equal(t, []cloud.WriteStatus{cloud.WriteStatus_Error}, statuses(resp.Writes))
+	assert.Equal(t, []cloud.WriteStatus{cloud.WriteStatus_Aborted}, statuses(resp.Deletes))
+	assert.Equal(t, `{"c":2}`, server.docs["c"].JsonDoc)
+}
+
+func TestBatchCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jdocs_batch")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	server := newTestServer()
+	c, err := newDocCache(dir, server.dial, func() string { return "user" })
+	require.NoError(t, err)
+
+	// queued writes are synced before a batch
+	server.setOffline(true)
+	writeDoc(t, c, "a", 0, `{"a":1}`)
+	resp, err := c.handleRequest(context.Background(), cloud.NewDocRequestWithBatch(&cloud.BatchRequest{
+		Writes: []cloud.WriteRequest{batchWrite("b", 0, `{"b":1}`)},
+	}))
+	assert.Error(t, err)
+	assert.Nil(t, resp.GetBatch())
+	server.setOffline(false)
+
+	resp, err = c.handleRequest(context.Background(), cloud.NewDocRequestWithBatch(&cloud.BatchRequest{
+		Atomic: true,
+		Writes: []cloud.WriteRequest{batchWrite("a", 1, `{"a":2}`), batchWrite("b", 0, `{"b":1}`)},
+	}))
+	require.NoError(t, err)
+	require.True(t, resp.GetBatch().Committed)
+
+	// and what it wrote is cached
+	server.setOffline(true)
+	doc, err := readDoc(t, c, "a", 0)
+	require.NoError(t, err)
+	assert.Equal(t, `{"a":2}`, doc.Doc.JsonDoc)
+	assert.Equal(t, uint64(2), doc.Doc.DocVersion)
+}
+
+func TestBatchPacking(t *testing.T) {
+	req := cloud.NewDocRequestWithBatch(&cloud.BatchRequest{
+		Atomic:  true,
+		Writes:  []cloud.WriteRequest{batchWrite("a", 1, "{}")},
+		Deletes: []cloud.DeleteRequest{batchDelete("b")},
+	})
+	var buf bytes.Buffer
+	require.NoError(t, req.Pack(&buf))
+	assert.Equal(t, int(req.Size()), buf.Len())
+	var gotReq cloud.DocRequest
+	require.NoError(t, gotReq.Unpack(&buf))
+	assert.Equal(t, req.GetBatch(), gotReq.GetBatch())
+
+	resp := cloud.NewDocResponseWithBatch(&cloud.BatchResponse{
+		Committed: true,
+		Writes:    []cloud.WriteResponse{{Status: cloud.WriteStatus_Accepted, LatestVersion: 2}},
+		Deletes:   []cloud.WriteResponse{{Status: cloud.WriteStatus_Accepted}},
+	})
+	buf.Reset()
+	require.NoError(t, resp.Pack(&buf))
+	assert.Equal(t, int(resp.Size()), buf.Len())
+	var got cloud.DocResponse
+	require.NoError(t, got.Unpack(&buf))
+	assert.Equal(t, resp.GetBatch(), got.GetBatch())
+}
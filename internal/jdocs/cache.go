@@ -0,0 +1,484 @@
+package jdocs
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/digital-dream-labs/vector-cloud/internal/clad/cloud"
+
+	"github.com/digital-dream-labs/vector-cloud/internal/log"
+	"github.com/digital-dream-labs/vector-cloud/internal/util"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// The docCache keeps a persistent local copy of the documents read and written through
+// it, so that they can still be read and written while the server can't be reached.
+//
+// Reads ask the server only for documents that changed since the cached version, and
+// are answered from the cache if it's unreachable. Writes made while offline are
+// acknowledged and queued, then synced in order once the server is back; the server
+// is assumed to increment a document's version by one on each write, as it does, so
+// the version handed out for a queued write is the one it will end up with. A queued
+// write the server rejects (because the document was changed elsewhere in the
+// meantime) is dropped in favor of the server's copy, as is one that fails for any
+// reason other than the server being unreachable, since retrying won't help it.
+
+const (
+	cacheFile    = "docs.json"
+	syncInterval = time.Minute
+	syncTimeout  = 30 * time.Second
+)
+
+type dialFunc func(ctx context.Context, account string) (*conn, error)
+
+type cachedDoc struct {
+	Account string    `json:"account"`
+	Thing   string    `json:"thing"`
+	DocName string    `json:"doc_name"`
+	Doc     cloud.Doc `json:"doc"`
+	// Pending is set for a write not yet synced to the server, which was made
+	// against BaseVersion of the document and queued at QueuedAt
+	Pending     bool      `json:"pending,omitempty"`
+	BaseVersion uint64    `json:"base_version,omitempty"`
+	QueuedAt    time.Time `json:"queued_at,omitempty"`
+}
+
+type docCache struct {
+	dir    string
+	dial   dialFunc
+	userID func() string
+	// syncMu serializes writes to the server, so that queued writes are synced in
+	// order and before new ones
+	syncMu sync.Mutex
+	// mu guards docs and the cache file
+	mu   sync.Mutex
+	docs map[string]*cachedDoc
+}
+
+// newDocCache loads the cache stored in dir, creating it if it doesn't exist.
+// Requests for the active user (with an empty account) are cached under the ID
+// returned by userID.
+func newDocCache(dir string, dial dialFunc, userID func() string) (*docCache, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	c := &docCache{dir: dir, dial: dial, userID: userID, docs: make(map[string]*cachedDoc)}
+	buf, err := ioutil.ReadFile(path.Join(dir, cacheFile))
+	if os.IsNotExist(err) {
+		return c, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var docs []*cachedDoc
+	if err := json.Unmarshal(buf, &docs); err != nil {
+		// not worth failing over; anything that was cached will be read again
+		log.Println("Discarding unreadable jdocs cache:", err)
+		return c, nil
+	}
+	for _, doc := range docs {
+		c.docs[docKey(doc.Account, doc.Thing, doc.DocName)] = doc
+	}
+	return c, nil
+}
+
+func docKey(account, thing, docName string) string {
+	return account + "\x00" + thing + "\x00" + docName
+}
+
+// unreachableError is returned when a connection to the server couldn't be made
+type unreachableError struct {
+	error
+}
+
+// offline returns whether an error from the server means it couldn't be reached
+func offline(err error) bool {
+	if _, ok := err.(unreachableError); ok {
+		return true
+	}
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return true
+	}
+	return false
+}
+
+func (c *docCache) account(account string) string {
+	if account == "" && c.userID != nil {
+		return c.userID()
+	}
+	return account
+}
+
+func (c *docCache) connect(ctx context.Context, account string) (*conn, error) {
+	conn, err := c.dial(ctx, account)
+	if err != nil {
+		return nil, unreachableError{err}
+	}
+	return conn, nil
+}
+
+func (c *docCache) handleRequest(ctx context.Context, req *cloud.DocRequest) (*cloud.DocResponse, error) {
+	switch req.Tag() {
+	case cloud.DocRequestTag_Read:
+		return c.read(ctx, req.GetRead())
+	case cloud.DocRequestTag_Write:
+		return c.write(ctx, req.GetWrite())
+	case cloud.DocRequestTag_DeleteReq:
+		return c.delete(ctx, req.GetDeleteReq())
+	case cloud.DocRequestTag_Batch:
+		return c.batch(ctx, req)
+	}
+	conn, err := c.dial(ctx, requestAccount(req))
+	if err != nil {
+		return connectErrorResponse, err
+	}
+	return conn.handleRequest(ctx, req)
+}
+
+func (c *docCache) read(ctx context.Context, req *cloud.ReadRequest) (*cloud.DocResponse, error) {
+	account := c.account(req.Account)
+
+	// documents with queued writes are answered from the cache; others are asked
+	// for from the server, only if they changed since the cached version
+	c.mu.Lock()
+	cached := make([]*cachedDoc, len(req.Items))
+	serverReq := cloud.ReadRequest{Account: req.Account, Thing: req.Thing}
+	var serverIdx []int
+	for i, item := range req.Items {
+		if doc := c.docs[docKey(account, req.Thing, item.DocName)]; doc != nil {
+			copied := *doc
+			cached[i] = &copied
+			if doc.Pending {
+				continue
+			}
+			item.MyDocVersion = doc.Doc.DocVersion
+		}
+		serverReq.Items = append(serverReq.Items, item)
+		serverIdx = append(serverIdx, i)
+	}
+	c.mu.Unlock()
+
+	ret := &cloud.ReadResponse{Items: make([]cloud.ResponseDoc, len(req.Items))}
+	for i, item := range req.Items {
+		if cached[i] != nil {
+			ret.Items[i] = cachedResponse(item, cached[i])
+		}
+	}
+	if len(serverIdx) == 0 {
+		return cloud.NewDocResponseWithRead(ret), nil
+	}
+
+	resp, err := c.readServer(ctx, &serverReq)
+	if err != nil {
+		if !offline(err) {
+			return connectErrorResponse, err
+		}
+		for _, i := range serverIdx {
+			if cached[i] == nil {
+				return connectErrorResponse, err
+			}
+		}
+		log.Println("Couldn't reach jdocs server, reading from cache:", err)
+		return cloud.NewDocResponseWithRead(ret), nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for n, i := range serverIdx {
+		item, got := req.Items[i], resp.Items[n]
+		key := docKey(account, req.Thing, item.DocName)
+		switch got.Status {
+		case cloud.ReadStatus_Unchanged:
+			if cached[i] == nil {
+				ret.Items[i] = got
+			}
+		case cloud.ReadStatus_Changed:
+			if doc := c.docs[key]; doc == nil || !doc.Pending {
+				c.docs[key] = &cachedDoc{Account: account, Thing: req.Thing, DocName: item.DocName, Doc: got.Doc}
+			}
+			ret.Items[i] = got
+			if got.Doc.DocVersion == item.MyDocVersion {
+				ret.Items[i].Status = cloud.ReadStatus_Unchanged
+			}
+		default:
+			if doc := c.docs[key]; doc != nil && !doc.Pending {
+				delete(c.docs, key)
+			}
+			ret.Items[i] = got
+		}
+	}
+	c.saveLocked()
+	return cloud.NewDocResponseWithRead(ret), nil
+}
+
+// readServer reads from the server, returning an error if it couldn't be reached
+func (c *docCache) readServer(ctx context.Context, req *cloud.ReadRequest) (*cloud.ReadResponse, error) {
+	conn, err := c.connect(ctx, req.Account)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := conn.readRequest(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.GetRead(), nil
+}
+
+// cachedResponse answers a read item from the cache
+func cachedResponse(item cloud.ReadItem, doc *cachedDoc) cloud.ResponseDoc {
+	ret := cloud.ResponseDoc{Status: cloud.ReadStatus_Changed, Doc: doc.Doc}
+	if item.MyDocVersion == doc.Doc.DocVersion {
+		ret.Status = cloud.ReadStatus_Unchanged
+	}
+	return ret
+}
+
+func (c *docCache) write(ctx context.Context, req *cloud.WriteRequest) (*cloud.DocResponse, error) {
+	c.syncMu.Lock()
+	defer c.syncMu.Unlock()
+	account := c.account(req.Account)
+
+	// writes already queued go first; if they can't, neither can this one
+	if err := c.syncLocked(ctx); offline(err) {
+		return c.queue(account, req), nil
+	} else if err != nil {
+		return connectErrorResponse, err
+	}
+
+	conn, err := c.connect(ctx, req.Account)
+	if err != nil {
+		return c.queue(account, req), nil
+	}
+	resp, err := conn.writeRequest(ctx, req)
+	if offline(err) {
+		return c.queue(account, req), nil
+	} else if err != nil {
+		return resp, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := docKey(account, req.Thing, req.DocName)
+	switch write := resp.GetWrite(); write.Status {
+	case cloud.WriteStatus_Accepted:
+		doc := req.Doc
+		doc.DocVersion = write.LatestVersion
+		c.docs[key] = &cachedDoc{Account: account, Thing: req.Thing, DocName: req.DocName, Doc: doc}
+	default:
+		// whatever we have is out of date
+		delete(c.docs, key)
+	}
+	c.saveLocked()
+	return resp, nil
+}
+
+// queue stores a write to be synced later, rejecting it as the server would if it
+// wasn't made against the latest version we know of
+func (c *docCache) queue(account string, req *cloud.WriteRequest) *cloud.DocResponse {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := docKey(account, req.Thing, req.DocName)
+	doc := c.docs[key]
+	if doc != nil && doc.Doc.DocVersion != req.Doc.DocVersion {
+		return cloud.NewDocResponseWithWrite(&cloud.WriteResponse{
+			Status:        cloud.WriteStatus_RejectedDocVersion,
+			LatestVersion: doc.Doc.DocVersion,
+		})
+	}
+	if doc == nil || !doc.Pending {
+		doc = &cachedDoc{
+			Account:     account,
+			Thing:       req.Thing,
+			DocName:     req.DocName,
+			Pending:     true,
+			BaseVersion: req.Doc.DocVersion,
+			QueuedAt:    time.Now(),
+		}
+		c.docs[key] = doc
+	}
+	// further writes before it's synced replace it, keeping the version it will get
+	doc.Doc = req.Doc
+	doc.Doc.DocVersion = doc.BaseVersion + 1
+	c.saveLocked()
+	log.Println("Couldn't reach jdocs server, queued write of", req.DocName)
+	return cloud.NewDocResponseWithWrite(&cloud.WriteResponse{
+		Status:        cloud.WriteStatus_Accepted,
+		LatestVersion: doc.Doc.DocVersion,
+	})
+}
+
+func (c *docCache) delete(ctx context.Context, req *cloud.DeleteRequest) (*cloud.DocResponse, error) {
+	c.syncMu.Lock()
+	defer c.syncMu.Unlock()
+	conn, err := c.dial(ctx, req.Account)
+	if err != nil {
+		return connectErrorResponse, err
+	}
+	resp, err := conn.deleteRequest(ctx, req)
+	if err != nil {
+		return resp, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.docs, docKey(c.account(req.Account), req.Thing, req.DocName))
+	c.saveLocked()
+	return resp, nil
+}
+
+// batch sends a batch to the server after any queued writes; unlike single writes,
+// batches aren't queued while it can't be reached
+func (c *docCache) batch(ctx context.Context, req *cloud.DocRequest) (*cloud.DocResponse, error) {
+	c.syncMu.Lock()
+	defer c.syncMu.Unlock()
+	if err := c.syncLocked(ctx); err != nil {
+		return connectErrorResponse, err
+	}
+	conn, err := c.dial(ctx, requestAccount(req))
+	if err != nil {
+		return connectErrorResponse, err
+	}
+	resp, err := conn.handleRequest(ctx, req)
+	result := resp.GetBatch()
+	if result == nil {
+		return resp, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	batch := req.GetBatch()
+	for i, w := range batch.Writes {
+		account := c.account(w.Account)
+		key := docKey(account, w.Thing, w.DocName)
+		if result.Writes[i].Status != cloud.WriteStatus_Accepted {
+			// whatever we have may be out of date
+			delete(c.docs, key)
+			continue
+		}
+		doc := w.Doc
+		doc.DocVersion = result.Writes[i].LatestVersion
+		c.docs[key] = &cachedDoc{Account: account, Thing: w.Thing, DocName: w.DocName, Doc: doc}
+	}
+	for _, d := range batch.Deletes {
+		delete(c.docs, docKey(c.account(d.Account), d.Thing, d.DocName))
+	}
+	c.saveLocked()
+	return resp, err
+}
+
+// pendingLocked returns the queued writes in the order they were made; mu must be
+// held
+func (c *docCache) pendingLocked() []cachedDoc {
+	var ret []cachedDoc
+	for _, doc := range c.docs {
+		if doc.Pending {
+			ret = append(ret, *doc)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].QueuedAt.Before(ret[j].QueuedAt)
+	})
+	return ret
+}
+
+// syncLocked writes queued documents to the server, stopping at the first that
+// fails; one that failed other than by the server being unreachable is dropped.
+// syncMu must be held.
+func (c *docCache) syncLocked(ctx context.Context) error {
+	c.mu.Lock()
+	pending := c.pendingLocked()
+	c.mu.Unlock()
+
+	for _, doc := range pending {
+		write := cloud.WriteRequest{Account: doc.Account, Thing: doc.Thing, DocName: doc.DocName, Doc: doc.Doc}
+		write.Doc.DocVersion = doc.BaseVersion
+		conn, err := c.connect(ctx, doc.Account)
+		if err != nil {
+			return err
+		}
+		resp, err := conn.writeRequest(ctx, &write)
+		key := docKey(doc.Account, doc.Thing, doc.DocName)
+		if offline(err) {
+			return err
+		} else if err != nil {
+			log.Printf("Dropping queued write of %s, failed with %v\n", doc.DocName, err)
+			log.Das("jdocs.sync_failed", (&log.DasFields{}).SetStrings(doc.DocName, err.Error()))
+			c.mu.Lock()
+			delete(c.docs, key)
+			c.saveLocked()
+			c.mu.Unlock()
+			return err
+		}
+
+		c.mu.Lock()
+		switch result := resp.GetWrite(); result.Status {
+		case cloud.WriteStatus_Accepted:
+			if result.LatestVersion != doc.Doc.DocVersion {
+				log.Printf("Synced queued write of %s as version %d, not %d\n", doc.DocName, result.LatestVersion, doc.Doc.DocVersion)
+			}
+			synced := c.docs[key]
+			synced.Pending = false
+			synced.Doc.DocVersion = result.LatestVersion
+			log.Das("jdocs.sync", (&log.DasFields{}).SetStrings(doc.DocName))
+		default:
+			// changed elsewhere while we were offline, the server's copy wins
+			log.Printf("Dropping queued write of %s, rejected with %v\n", doc.DocName, result.Status)
+			log.Das("jdocs.sync_conflict", (&log.DasFields{}).SetStrings(doc.DocName))
+			delete(c.docs, key)
+		}
+		c.saveLocked()
+		c.mu.Unlock()
+	}
+	return nil
+}
+
+// syncRoutine retries queued writes until ctx is done
+func (c *docCache) syncRoutine(ctx context.Context) {
+	for {
+		if util.SleepSelect(syncInterval, ctx.Done()) {
+			return
+		}
+		c.mu.Lock()
+		n := len(c.pendingLocked())
+		c.mu.Unlock()
+		if n == 0 {
+			continue
+		}
+		syncCtx, cancel := context.WithTimeout(ctx, syncTimeout)
+		c.syncMu.Lock()
+		if err := c.syncLocked(syncCtx); err != nil {
+			log.Println("Couldn't sync queued jdocs writes:", err)
+		}
+		c.syncMu.Unlock()
+		cancel()
+	}
+}
+
+// saveLocked writes the cache to disk; mu must be held
+func (c *docCache) saveLocked() {
+	docs := make([]*cachedDoc, 0, len(c.docs))
+	for _, doc := range c.docs {
+		docs = append(docs, doc)
+	}
+	buf, err := json.Marshal(docs)
+	if err != nil {
+		log.Println("Error encoding jdocs cache:", err)
+		return
+	}
+	fileName := path.Join(c.dir, cacheFile)
+	tmpFileName := fileName + ".tmp"
+	if err := ioutil.WriteFile(tmpFileName, buf, 0600); err != nil {
+		log.Println("Error saving jdocs cache:", err)
+		return
+	}
+	if err := os.Rename(tmpFileName, fileName); err != nil {
+		log.Println("Error saving jdocs cache:", err)
+	}
+}
@@ -0,0 +1,231 @@
+package jdocs
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/digital-dream-labs/vector-cloud/internal/clad/cloud"
+
+	pb "github.com/digital-dream-labs/api/go/jdocspb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// testServer is an in-memory jdocs server that can be taken offline
+type testServer struct {
+	pb.JdocsClient
+	mu      sync.Mutex
+	offline bool
+	// failDoc names a document whose writes fail as if the server went away
+	failDoc string
+	// denyDoc names a document whose writes are refused
+	denyDoc string
+	docs    map[string]*pb.Jdoc
+	reads   []*pb.ReadDocsReq
+}
+
+func newTestServer() *testServer {
+	return &testServer{docs: make(map[string]*pb.Jdoc)}
+}
+
+func (s *testServer) dial(ctx context.Context, account string) (*conn, error) {
+	return &conn{client: s}, nil
+}
+
+func (s *testServer) setOffline(offline bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.offline = offline
+}
+
+func (s *testServer) WriteDoc(ctx context.Context, in *pb.WriteDocReq, opts ...grpc.CallOption) (*pb.WriteDocResp, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.offline {
+		return nil, status.Error(codes.Unavailable, "offline")
+	}
+	if in.DocName == s.failDoc {
+		return nil, status.Error(codes.Unavailable, "failed")
+	}
+	if in.DocName == s.denyDoc {
+		return nil, status.Error(codes.PermissionDenied, "denied")
+	}
+	var version uint64
+	if doc := s.docs[in.DocName]; doc != nil {
+		version = doc.DocVersion
+	}
+	if in.Doc.DocVersion != version {
+		return &pb.WriteDocResp{Status: pb.WriteDocResp_REJECTED_BAD_DOC_VERSION, LatestDocVersion: version}, nil
+	}
+	doc := &pb.Jdoc{
+		DocVersion:     version + 1,
+		FmtVersion:     in.Doc.FmtVersion,
+		ClientMetadata: in.Doc.ClientMetadata,
+		JsonDoc:        in.Doc.JsonDoc,
+	}
+	s.docs[in.DocName] = doc
+	return &pb.WriteDocResp{Status: pb.WriteDocResp_ACCEPTED, LatestDocVersion: doc.DocVersion}, nil
+}
+
+func (s *testServer) ReadDocs(ctx context.Context, in *pb.ReadDocsReq, opts ...grpc.CallOption) (*pb.ReadDocsResp, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.offline {
+		return nil, status.Error(codes.Unavailable, "offline")
+	}
+	s.reads = append(s.reads, in)
+	ret := &pb.ReadDocsResp{}
+	for _, item := range in.Items {
+		doc := s.docs[item.DocName]
+		switch {
+		case doc == nil:
+			ret.Items = append(ret.Items, &pb.ReadDocsResp_Item{Status: pb.ReadDocsResp_NOT_FOUND, Doc: &pb.Jdoc{}})
+		case doc.DocVersion == item.MyDocVersion:
+			ret.Items = append(ret.Items, &pb.ReadDocsResp_Item{Status: pb.ReadDocsResp_UNCHANGED,
+				Doc: &pb.Jdoc{DocVersion: doc.DocVersion}})
+		default:
+			ret.Items = append(ret.Items, &pb.ReadDocsResp_Item{Status: pb.ReadDocsResp_CHANGED, Doc: doc})
+		}
+	}
+	return ret, nil
+}
+
+func (s *testServer) DeleteDoc(ctx context.Context, in *pb.DeleteDocReq, opts ...grpc.CallOption) (*pb.DeleteDocResp, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.offline {
+		return nil, status.Error(codes.Unavailable, "offline")
+	}
+	delete(s.docs, in.DocName)
+	return &pb.DeleteDocResp{}, nil
+}
+
+func readDoc(t *testing.T, c *docCache, docName string, version uint64) (*cloud.ResponseDoc, error) {
+	resp, err := c.handleRequest(context.Background(), cloud.NewDocRequestWithRead(&cloud.ReadRequest{
+		Thing: "vic:00000000",
+		Items: []cloud.ReadItem{{DocName: docName, MyDocVersion: version}},
+	}))
+	if err != nil {
+		return nil, err
+	}
+	require.Len(t, resp.GetRead().Items, 1)
+	return &resp.GetRead().Items[0], nil
+}
+
+func writeDoc(t *testing.T, c *docCache, docName string, version uint64, json string) *cloud.WriteResponse {
+	resp, err := c.handleRequest(context.Background(), cloud.NewDocRequestWithWrite(&cloud.WriteRequest{
+		Thing:   "vic:00000000",
+		DocName: docName,
+		Doc:     cloud.Doc{DocVersion: version, JsonDoc: json},
+	}))
+	require.NoError(t, err)
+	return resp.GetWrite()
+}
+
+func TestCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jdocs_cache")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	server := newTestServer()
+	userID := func() string { return "user" }
+	c, err := newDocCache(dir, server.dial, userID)
+	require.NoError(t, err)
+
+	// online, writes and reads go to the server
+	resp := writeDoc(t, c, "settings", 0, `{"volume":1}`)
+	assert.Equal(t, cloud.WriteStatus_Accepted, resp.Status)
+	assert.Equal(t, uint64(1), resp.LatestVersion)
+	doc, err := readDoc(t, c, "settings", 0)
+	require.NoError(t, err)
+	assert.Equal(t, cloud.ReadStatus_Changed, doc.Status)
+	assert.Equal(t, `{"volume":1}`, doc.Doc.JsonDoc)
+	// and only ask for documents that changed since they were cached
+	assert.Equal(t, uint64(1), server.reads[0].Items[0].MyDocVersion)
+
+	// offline, reads are answered from the cache, and writes are queued
+	server.setOffline(true)
+	doc, err = readDoc(t, c, "settings", 0)
+	require.NoError(t, err)
+	assert.Equal(t, `{"volume":1}`, doc.Doc.JsonDoc)
+	_, err = readDoc(t, c, "other", 0)
+	assert.Error(t, err)
+	resp = writeDoc(t, c, "settings", 1, `{"volume":2}`)
+	assert.Equal(t, cloud.WriteStatus_Accepted, resp.Status)
+	assert.Equal(t, uint64(2), resp.LatestVersion)
+	resp = writeDoc(t, c, "settings", 2, `{"volume":3}`)
+	assert.Equal(t, uint64(2), resp.LatestVersion)
+	resp = writeDoc(t, c, "settings", 1, `{"volume":4}`)
+	assert.Equal(t, cloud.WriteStatus_RejectedDocVersion, resp.Status)
+	doc, err = readDoc(t, c, "settings", 1)
+	require.NoError(t, err)
+	assert.Equal(t, `{"volume":3}`, doc.Doc.JsonDoc)
+
+	// queued writes survive a restart, and are synced once the server is back
+	c, err = newDocCache(dir, server.dial, userID)
+	require.NoError(t, err)
+	server.setOffline(false)
+	c.syncMu.Lock()
+	require.NoError(t, c.syncLocked(context.Background()))
+	c.syncMu.Unlock()
+	assert.Equal(t, `{"volume":3}`, server.docs["settings"].JsonDoc)
+	assert.Equal(t, uint64(2), server.docs["settings"].DocVersion)
+	resp = writeDoc(t, c, "settings", 2, `{"volume":5}`)
+	assert.Equal(t, cloud.WriteStatus_Accepted, resp.Status)
+
+	// a queued write of a document changed elsewhere meanwhile loses to it
+	server.setOffline(true)
+	writeDoc(t, c, "settings", 3, `{"volume":6}`)
+	server.docs["settings"] = &pb.Jdoc{DocVersion: 4, JsonDoc: `{"volume":7}`}
+	server.setOffline(false)
+	writeDoc(t, c, "other", 0, `{}`)
+	doc, err = readDoc(t, c, "settings", 3)
+	require.NoError(t, err)
+	assert.Equal(t, `{"volume":7}`, doc.Doc.JsonDoc)
+
+	// deleted documents are dropped from the cache
+	_, err = c.handleRequest(context.Background(), cloud.NewDocRequestWithDeleteReq(&cloud.DeleteRequest{
+		Thing: "vic:00000000", DocName: "settings"}))
+	require.NoError(t, err)
+	server.setOffline(true)
+	_, err = readDoc(t, c, "settings", 0)
+	assert.Error(t, err)
+}
+
+func TestCacheWriteErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jdocs_cache")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	server := newTestServer()
+	server.denyDoc = "denied"
+	c, err := newDocCache(dir, server.dial, func() string { return "user" })
+	require.NoError(t, err)
+	write := func(docName string) error {
+		_, err := c.handleRequest(context.Background(), cloud.NewDocRequestWithWrite(&cloud.WriteRequest{
+			Thing: "vic:00000000", DocName: docName, Doc: cloud.Doc{JsonDoc: "{}"}}))
+		return err
+	}
+
+	// a write refused by the server fails instead of being queued
+	assert.Equal(t, codes.PermissionDenied, status.Code(write("denied")))
+	c.mu.Lock()
+	assert.Empty(t, c.pendingLocked())
+	c.mu.Unlock()
+
+	// and one that was queued while offline is dropped when it's synced, with the
+	// error returned for the write that synced it
+	server.setOffline(true)
+	require.NoError(t, write("denied"))
+	server.setOffline(false)
+	assert.Equal(t, codes.PermissionDenied, status.Code(write("other")))
+	c.mu.Lock()
+	assert.Empty(t, c.pendingLocked())
+	c.mu.Unlock()
+	require.NoError(t, write("other"))
+	assert.Contains(t, server.docs, "other")
+}
@@ -6,64 +6,39 @@ import (
 
 	"github.com/digital-dream-labs/vector-cloud/internal/clad/cloud"
 
-	"github.com/digital-dream-labs/vector-cloud/internal/config"
 	"github.com/digital-dream-labs/vector-cloud/internal/log"
-	"github.com/digital-dream-labs/vector-cloud/internal/token"
-	"github.com/digital-dream-labs/vector-cloud/internal/util"
 
 	pb "github.com/digital-dream-labs/api/go/jdocspb"
-	"github.com/gwatts/rootcerts"
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials"
 )
 
+// conn makes requests on the shared jdocs connection on behalf of one user
 type conn struct {
-	conn   *grpc.ClientConn
 	client pb.JdocsClient
-	tok    token.Accessor
+	// account is the user requests are made on behalf of
+	account string
 }
 
-func newConn(ctx context.Context, opts *options) (*conn, error) {
-
-	pool := rootcerts.ServerCertPool()
-
-	_ = pool.AppendCertsFromPEM([]byte(escapepodRootPEM))
-
-	dialOpts := []grpc.DialOption{
-		grpc.WithTransportCredentials(
-			credentials.NewClientTLSFromCert(pool, ""),
-		),
-	}
-
-	dialOpts = append(dialOpts, util.CommonGRPC()...)
-	// end idea
-
-	if opts.tokener != nil {
-		creds, err := opts.tokener.Credentials()
-		if err != nil {
-			return nil, err
+// requestAccount returns the account a request is made on behalf of
+func requestAccount(req *cloud.DocRequest) string {
+	switch req.Tag() {
+	case cloud.DocRequestTag_Read:
+		return req.GetRead().Account
+	case cloud.DocRequestTag_Write:
+		return req.GetWrite().Account
+	case cloud.DocRequestTag_DeleteReq:
+		return req.GetDeleteReq().Account
+	case cloud.DocRequestTag_Batch:
+		if batch := req.GetBatch(); len(batch.Writes) > 0 {
+			return batch.Writes[0].Account
+		} else if len(batch.Deletes) > 0 {
+			return batch.Deletes[0].Account
 		}
-		dialOpts = append(dialOpts, grpc.WithPerRPCCredentials(creds))
-	}
-	rpcConn, err := grpc.DialContext(ctx, config.Env.JDocs, dialOpts...)
-	if err != nil {
-		return nil, err
 	}
-
-	rpcClient := pb.NewJdocsClient(rpcConn)
-
-	ret := &conn{
-		conn:   rpcConn,
-		client: rpcClient,
-		tok:    opts.tokener}
-	return ret, nil
-}
-
-func (c *conn) close() error {
-	return c.conn.Close()
+	return ""
 }
 
 func (c *conn) handleRequest(ctx context.Context, req *cloud.DocRequest) (*cloud.DocResponse, error) {
+	ctx = withAccount(ctx, c.account)
 	switch req.Tag() {
 	case cloud.DocRequestTag_Read:
 		return c.readRequest(ctx, req.GetRead())
@@ -71,6 +46,8 @@ func (c *conn) handleRequest(ctx context.Context, req *cloud.DocRequest) (*cloud
 		return c.writeRequest(ctx, req.GetWrite())
 	case cloud.DocRequestTag_DeleteReq:
 		return c.deleteRequest(ctx, req.GetDeleteReq())
+	case cloud.DocRequestTag_Batch:
+		return c.batchRequest(ctx, req.GetBatch())
 	}
 	err := fmt.Errorf("Major error: received unknown tag %d", req.Tag())
 	if err != nil {
@@ -82,6 +59,7 @@ func (c *conn) handleRequest(ctx context.Context, req *cloud.DocRequest) (*cloud
 var connectErrorResponse = cloud.NewDocResponseWithErr(&cloud.ErrorResponse{Err: cloud.DocError_ErrorConnecting})
 
 func (c *conn) writeRequest(ctx context.Context, cladReq *cloud.WriteRequest) (*cloud.DocResponse, error) {
+	ctx = withAccount(ctx, c.account)
 	req := (*cladWriteReq)(cladReq).toProto()
 	resp, err := c.client.WriteDoc(ctx, req)
 	if err != nil {
@@ -91,6 +69,7 @@ func (c *conn) writeRequest(ctx context.Context, cladReq *cloud.WriteRequest) (*
 }
 
 func (c *conn) readRequest(ctx context.Context, cladReq *cloud.ReadRequest) (*cloud.DocResponse, error) {
+	ctx = withAccount(ctx, c.account)
 	req := (*cladReadReq)(cladReq).toProto()
 	resp, err := c.client.ReadDocs(ctx, req)
 	if err != nil {
@@ -100,6 +79,7 @@ func (c *conn) readRequest(ctx context.Context, cladReq *cloud.ReadRequest) (*cl
 }
 
 func (c *conn) deleteRequest(ctx context.Context, cladReq *cloud.DeleteRequest) (*cloud.DocResponse, error) {
+	ctx = withAccount(ctx, c.account)
 	req := (*cladDeleteReq)(cladReq).toProto()
 	_, err := c.client.DeleteDoc(ctx, req)
 	if err != nil {
@@ -116,6 +96,12 @@ func (c *client) handleConnectionless(req *cloud.DocRequest) (bool, *cloud.DocRe
 	case cloud.DocRequestTag_Thing:
 		r, e := c.handleThingRequest()
 		return true, r, e
+	case cloud.DocRequestTag_Subscribe:
+		c.watcher.subscribe(c, req.GetSubscribe())
+		return true, cloud.NewDocResponseWithSubscribe(&cloud.Void{}), nil
+	case cloud.DocRequestTag_Unsubscribe:
+		c.watcher.unsubscribe(c)
+		return true, cloud.NewDocResponseWithUnsubscribe(&cloud.Void{}), nil
 	}
 	return false, nil, nil
 }
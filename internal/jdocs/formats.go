@@ -0,0 +1,163 @@
+package jdocs
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/digital-dream-labs/vector-cloud/internal/clad/cloud"
+
+	"github.com/digital-dream-labs/vector-cloud/internal/log"
+)
+
+// DocFormat is one version of the format of a document's JSON
+type DocFormat struct {
+	FmtVersion uint64
+	// Schema is a JSON schema documents in this format must match; only type,
+	// properties, required, items and additionalProperties are understood
+	Schema string
+	// Upgrade, if given, converts a document in the previous format to this one
+	Upgrade func(doc map[string]interface{}) error
+}
+
+// defaultFormats are the formats of the documents vic-cloud and vic-gateway rely on
+var defaultFormats = map[string][]DocFormat{
+	"vic.AppTokens": {{FmtVersion: 1, Schema: appTokensSchema}},
+}
+
+// appTokensSchema matches the ClientTokenDocument that vic-gateway decodes
+const appTokensSchema = `{
+	"type": "object",
+	"properties": {
+		"client_tokens": {
+			"type": "array",
+			"items": {
+				"type": "object",
+				"required": ["hash"],
+				"properties": {
+					"hash": {"type": "string"},
+					"client_name": {"type": "string"},
+					"app_id": {"type": "string"},
+					"issued_at": {"type": "string"}
+				}
+			}
+		}
+	}
+}`
+
+type docFormat struct {
+	DocFormat
+	schema *jsonSchema
+}
+
+// formatRegistry knows the formats documents have had, so that writes can be
+// checked and documents read in older formats brought up to date
+type formatRegistry struct {
+	docs map[string][]docFormat
+}
+
+// newFormatRegistry creates a registry of the given formats of each document, which
+// must be listed oldest first
+func newFormatRegistry(formats map[string][]DocFormat) (*formatRegistry, error) {
+	r := &formatRegistry{docs: make(map[string][]docFormat)}
+	for docName, list := range formats {
+		for i, f := range list {
+			if i > 0 && f.FmtVersion <= list[i-1].FmtVersion {
+				return nil, fmt.Errorf("%s: formats out of order at version %d", docName, f.FmtVersion)
+			}
+			schema, err := parseSchema(f.Schema)
+			if err != nil {
+				return nil, fmt.Errorf("%s: schema of version %d: %v", docName, f.FmtVersion, err)
+			}
+			r.docs[docName] = append(r.docs[docName], docFormat{f, schema})
+		}
+	}
+	return r, nil
+}
+
+// invalidDocError is returned for a document that doesn't match its format
+type invalidDocError struct {
+	docName string
+	err     error
+}
+
+func (e invalidDocError) Error() string {
+	return fmt.Sprintf("invalid %s document: %v", e.docName, e.err)
+}
+
+var invalidDocResponse = cloud.NewDocResponseWithErr(&cloud.ErrorResponse{Err: cloud.DocError_InvalidDoc})
+
+// checkWrite brings a document about to be written up to the latest format and
+// makes sure it matches
+func (r *formatRegistry) checkWrite(req *cloud.WriteRequest) error {
+	if err := r.upgrade(req.DocName, &req.Doc); err != nil {
+		return invalidDocError{req.DocName, err}
+	}
+	return nil
+}
+
+// upgradeRead brings a document that was read up to the latest format; one that
+// doesn't match it is still returned, but logged so it's noticed where it's read
+func (r *formatRegistry) upgradeRead(docName string, doc *cloud.Doc) {
+	if err := r.upgrade(docName, doc); err != nil {
+		log.Printf("Read invalid %s document (format %d): %v\n", docName, doc.FmtVersion, err)
+		log.Das("jdocs.invalid_doc", (&log.DasFields{}).SetStrings(docName, fmt.Sprint(doc.FmtVersion), err.Error()))
+	}
+}
+
+// upgrade converts doc to the latest format of documents of its name and validates
+// it; docs without a format version are taken to be in the oldest one, and docs that
+// aren't registered or are newer than this code knows of are left alone
+func (r *formatRegistry) upgrade(docName string, doc *cloud.Doc) error {
+	formats := r.docs[docName]
+	if len(formats) == 0 {
+		return nil
+	}
+	latest := formats[len(formats)-1]
+	if doc.FmtVersion > latest.FmtVersion {
+		return nil
+	}
+	from := -1
+	for i, f := range formats {
+		if f.FmtVersion == doc.FmtVersion || (i == 0 && doc.FmtVersion == 0) {
+			from = i
+			break
+		}
+	}
+	if from < 0 {
+		return fmt.Errorf("unknown format version %d", doc.FmtVersion)
+	}
+
+	value, err := decodeJSON(doc.JsonDoc)
+	if err != nil {
+		return err
+	}
+	if err := formats[from].schema.validate(value); err != nil {
+		return err
+	}
+	if from == len(formats)-1 {
+		return nil
+	}
+
+	obj, ok := value.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("format %d document isn't an object and can't be upgraded", doc.FmtVersion)
+	}
+	for _, f := range formats[from+1:] {
+		if f.Upgrade == nil {
+			continue
+		}
+		if err := f.Upgrade(obj); err != nil {
+			return fmt.Errorf("upgrading to format %d: %v", f.FmtVersion, err)
+		}
+	}
+	if err := latest.schema.validate(obj); err != nil {
+		return fmt.Errorf("upgraded to format %d: %v", latest.FmtVersion, err)
+	}
+	buf, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	doc.JsonDoc = string(buf)
+	doc.FmtVersion = latest.FmtVersion
+	return nil
+}
@@ -0,0 +1,150 @@
+package jdocs
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/digital-dream-labs/vector-cloud/internal/clad/cloud"
+
+	pb "github.com/digital-dream-labs/api/go/jdocspb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSchema(t *testing.T) {
+	schema, err := parseSchema(appTokensSchema)
+	require.NoError(t, err)
+
+	valid := []string{
+		`{}`,
+		`{"client_tokens":[]}`,
+		`{"client_tokens":[{"hash":"abc","client_name":"phone","extra":1}]}`,
+	}
+	for _, doc := range valid {
+		value, err := decodeJSON(doc)
+		require.NoError(t, err)
+		assert.NoError(t, schema.validate(value), doc)
+	}
+
+	invalid := map[string]string{
+		`[]`:                                     "document: expected object, got array",
+		`{"client_tokens":{}}`:                   "client_tokens: expected array, got object",
+		`{"client_tokens":[{"client_name":""}]}`: `client_tokens[0]: missing "hash"`,
+		`{"client_tokens":[{"hash":1}]}`:         "client_tokens[0].hash: expected string, got number",
+	}
+	for doc, msg := range invalid {
+		value, err := decodeJSON(doc)
+		require.NoError(t, err)
+		assert.EqualError(t, schema.validate(value), msg, doc)
+	}
+
+	_, err = decodeJSON(`{"client_tokens":[]} {}`)
+	assert.Error(t, err)
+	_, err = parseSchema(`{"type":"strin"}`)
+	assert.Error(t, err)
+
+	strict, err := parseSchema(`{"properties":{"n":{"type":"integer"}},"additionalProperties":false}`)
+	require.NoError(t, err)
+	value, _ := decodeJSON(`{"n":1}`)
+	assert.NoError(t, strict.validate(value))
+	value, _ = decodeJSON(`{"n":1.5}`)
+	assert.EqualError(t, strict.validate(value), "n: expected integer, got number")
+	value, _ = decodeJSON(`{"m":1}`)
+	assert.EqualError(t, strict.validate(value), `document: unexpected "m"`)
+}
+
+// settingsFormats moved volume into an audio object in format 2
+var settingsFormats = []DocFormat{
+	{FmtVersion: 1, Schema: `{"type":"object","required":["volume"],"properties":{"volume":{"type":"integer"}}}`},
+	{
+		FmtVersion: 2,
+		Schema:     `{"type":"object","required":["audio"],"properties":{"audio":{"type":"object","required":["volume"]}}}`,
+		Upgrade: func(doc map[string]interface{}) error {
+			volume, ok := doc["volume"]
+			if !ok {
+				return errors.New("no volume")
+			}
+			delete(doc, "volume")
+			doc["audio"] = map[string]interface{}{"volume": volume}
+			return nil
+		},
+	},
+}
+
+func TestFormatRegistry(t *testing.T) {
+	r, err := newFormatRegistry(map[string][]DocFormat{"vic.Settings": settingsFormats})
+	require.NoError(t, err)
+
+	// older documents are upgraded, keeping their numbers as they were
+	doc := cloud.Doc{DocVersion: 4, FmtVersion: 1, JsonDoc: `{"volume":3}`}
+	r.upgradeRead("vic.Settings", &doc)
+	assert.Equal(t, cloud.Doc{DocVersion: 4, FmtVersion: 2, JsonDoc: `{"audio":{"volume":3}}`}, doc)
+	// and those without a format version are taken to be in the oldest format
+	doc = cloud.Doc{JsonDoc: `{"volume":3}`}
+	r.upgradeRead("vic.Settings", &doc)
+	assert.Equal(t, uint64(2), doc.FmtVersion)
+
+	// invalid documents are read as they are
+	doc = cloud.Doc{FmtVersion: 1, JsonDoc: `{"volume":"loud"}`}
+	r.upgradeRead("vic.Settings", &doc)
+	assert.Equal(t, cloud.Doc{FmtVersion: 1, JsonDoc: `{"volume":"loud"}`}, doc)
+
+	// but can't be written
+	write := cloud.WriteRequest{DocName: "vic.Settings", Doc: cloud.Doc{FmtVersion: 2, JsonDoc: `{"volume":3}`}}
+	err = r.checkWrite(&write)
+	assert.EqualError(t, err, `invalid vic.Settings document: document: missing "audio"`)
+	write.Doc = cloud.Doc{FmtVersion: 1, JsonDoc: `{"volume":3`}
+	assert.Error(t, r.checkWrite(&write))
+	write.Doc = cloud.Doc{FmtVersion: 1, JsonDoc: `{"volume":3}`}
+	require.NoError(t, r.checkWrite(&write))
+	assert.Equal(t, cloud.Doc{FmtVersion: 2, JsonDoc: `{"audio":{"volume":3}}`}, write.Doc)
+
+	// documents newer than known, or not registered, are left alone
+	write = cloud.WriteRequest{DocName: "vic.Settings", Doc: cloud.Doc{FmtVersion: 3, JsonDoc: `not json`}}
+	assert.NoError(t, r.checkWrite(&write))
+	write = cloud.WriteRequest{DocName: "vic.Other", Doc: cloud.Doc{JsonDoc: `not json`}}
+	assert.NoError(t, r.checkWrite(&write))
+
+	_, err = newFormatRegistry(map[string][]DocFormat{"vic.Settings": {settingsFormats[1], settingsFormats[0]}})
+	assert.Error(t, err)
+	_, err = newFormatRegistry(map[string][]DocFormat{"vic.Settings": {{FmtVersion: 1, Schema: `{`}}})
+	assert.Error(t, err)
+}
+
+func TestHandlerFormats(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jdocs_formats")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	server := newTestServer()
+	cache, err := newDocCache(dir, server.dial, func() string { return "user" })
+	require.NoError(t, err)
+	formats, err := newFormatRegistry(map[string][]DocFormat{"vic.Settings": settingsFormats})
+	require.NoError(t, err)
+	h := &handler{cache: cache, formats: formats}
+	ctx := context.Background()
+
+	// invalid writes are turned away before reaching the server
+	resp, err := h.handleRequest(ctx, cloud.NewDocRequestWithWrite(&cloud.WriteRequest{
+		Thing:   "vic:00000000",
+		DocName: "vic.Settings",
+		Doc:     cloud.Doc{FmtVersion: 2, JsonDoc: `{}`},
+	}))
+	assert.Error(t, err)
+	require.NotNil(t, resp.GetErr())
+	assert.Equal(t, cloud.DocError_InvalidDoc, resp.GetErr().Err)
+	assert.Empty(t, server.docs)
+
+	// older documents on the server are upgraded when read
+	server.docs["vic.Settings"] = &pb.Jdoc{DocVersion: 1, FmtVersion: 1, JsonDoc: `{"volume":2}`}
+	resp, err = h.handleRequest(ctx, cloud.NewDocRequestWithRead(&cloud.ReadRequest{
+		Thing: "vic:00000000",
+		Items: []cloud.ReadItem{{DocName: "vic.Settings"}},
+	}))
+	require.NoError(t, err)
+	require.Len(t, resp.GetRead().Items, 1)
+	assert.Equal(t, uint64(2), resp.GetRead().Items[0].Doc.FmtVersion)
+	assert.Equal(t, `{"audio":{"volume":2}}`, resp.GetRead().Items[0].Doc.JsonDoc)
+}
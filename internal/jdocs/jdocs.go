@@ -2,6 +2,8 @@ package jdocs
 
 import (
 	"context"
+
+	"github.com/digital-dream-labs/vector-cloud/internal/log"
 )
 
 // Run starts the jdocs service
@@ -11,7 +13,39 @@ func Run(ctx context.Context, optionValues ...Option) {
 		o(&opts)
 	}
 
+	pool := newConnPool(&opts)
+	defer pool.close()
+
+	userID := func() string {
+		if opts.tokener == nil {
+			return ""
+		}
+		return opts.tokener.UserID()
+	}
+
+	var cache *docCache
+	if opts.cacheDir != "" {
+		var err error
+		if cache, err = newDocCache(opts.cacheDir, pool.get, userID); err != nil {
+			log.Println("Error loading jdocs cache, running without it:", err)
+		} else {
+			go cache.syncRoutine(ctx)
+		}
+	}
+
+	formatList := make(map[string][]DocFormat)
+	for docName, formats := range defaultFormats {
+		formatList[docName] = formats
+	}
+	for docName, formats := range opts.formats {
+		formatList[docName] = formats
+	}
+	formats, err := newFormatRegistry(formatList)
+	if err != nil {
+		log.Println("Error loading jdocs formats, running without them:", err)
+	}
+
 	if opts.server {
-		runServer(ctx, &opts)
+		runServer(ctx, &opts, &handler{pool: pool, cache: cache, formats: formats}, userID)
 	}
 }
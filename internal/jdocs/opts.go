@@ -10,6 +10,8 @@ type options struct {
 	socketNameSuffix string
 	tokener          token.Accessor
 	errListener      util.ErrorListener
+	cacheDir         string
+	formats          map[string][]DocFormat
 }
 
 // Option defines an option that can be set on the token server
@@ -45,3 +47,22 @@ func WithErrorListener(value util.ErrorListener) Option {
 		o.errListener = value
 	}
 }
+
+// WithCacheDir specifies a directory to keep a local copy of documents in, so they can
+// be read and written while the server can't be reached
+func WithCacheDir(dir string) Option {
+	return func(o *options) {
+		o.cacheDir = dir
+	}
+}
+
+// WithDocFormats specifies the formats, oldest first, of the named document; writes
+// must match the latest and reads are upgraded to it. It replaces any built-in ones.
+func WithDocFormats(docName string, formats ...DocFormat) Option {
+	return func(o *options) {
+		if o.formats == nil {
+			o.formats = make(map[string][]DocFormat)
+		}
+		o.formats[docName] = formats
+	}
+}
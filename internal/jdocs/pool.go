@@ -0,0 +1,116 @@
+package jdocs
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/digital-dream-labs/vector-cloud/internal/config"
+	"github.com/digital-dream-labs/vector-cloud/internal/token"
+	"github.com/digital-dream-labs/vector-cloud/internal/util"
+
+	pb "github.com/digital-dream-labs/api/go/jdocspb"
+	"github.com/gwatts/rootcerts"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/keepalive"
+)
+
+const (
+	// requestTimeout bounds each document request
+	requestTimeout = 15 * time.Second
+	// keepaliveTime is how long the connection can be idle before it's checked;
+	// servers turn away clients that ping more often than every 5 minutes
+	keepaliveTime    = 5 * time.Minute
+	keepaliveTimeout = 20 * time.Second
+)
+
+var errPoolClosed = errors.New("jdocs connection closed")
+
+// connPool holds the connection to the jdocs server that all requests share. It's
+// dialed on first use; gRPC reconnects it as needed after that.
+type connPool struct {
+	target    string
+	transport grpc.DialOption
+	tokener   token.Accessor
+
+	mu     sync.Mutex
+	cc     *grpc.ClientConn
+	closed bool
+}
+
+func newConnPool(opts *options) *connPool {
+	pool := rootcerts.ServerCertPool()
+	_ = pool.AppendCertsFromPEM([]byte(escapepodRootPEM))
+	return &connPool{
+		target:    config.Env.JDocs,
+		transport: grpc.WithTransportCredentials(credentials.NewClientTLSFromCert(pool, "")),
+		tokener:   opts.tokener,
+	}
+}
+
+// get returns a conn on the shared connection for requests on behalf of the given
+// account's user, or of the active user if account is empty
+func (p *connPool) get(ctx context.Context, account string) (*conn, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil, errPoolClosed
+	}
+	if p.cc == nil {
+		dialOpts := []grpc.DialOption{
+			p.transport,
+			grpc.WithKeepaliveParams(keepalive.ClientParameters{
+				Time:    keepaliveTime,
+				Timeout: keepaliveTimeout,
+			}),
+		}
+		dialOpts = append(dialOpts, util.CommonGRPC()...)
+		if p.tokener != nil {
+			dialOpts = append(dialOpts, grpc.WithPerRPCCredentials(accountCredentials{p.tokener}))
+		}
+		cc, err := grpc.DialContext(ctx, p.target, dialOpts...)
+		if err != nil {
+			return nil, err
+		}
+		p.cc = cc
+	}
+	return &conn{client: pb.NewJdocsClient(p.cc), account: account}, nil
+}
+
+func (p *connPool) close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	if p.cc == nil {
+		return nil
+	}
+	return p.cc.Close()
+}
+
+type accountKey struct{}
+
+// withAccount returns a context for requests on behalf of the given account's user
+func withAccount(ctx context.Context, account string) context.Context {
+	return context.WithValue(ctx, accountKey{}, account)
+}
+
+// accountCredentials gets credentials from the tokener for every call, so that they
+// carry a current token of the user the call is made for
+type accountCredentials struct {
+	tokener token.Accessor
+}
+
+func (a accountCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	account, _ := ctx.Value(accountKey{}).(string)
+	creds, err := a.tokener.UserCredentials(ctx, account)
+	if err != nil {
+		return nil, err
+	}
+	return creds.GetRequestMetadata(ctx, uri...)
+}
+
+func (a accountCredentials) RequireTransportSecurity() bool {
+	return true
+}
@@ -0,0 +1,105 @@
+package jdocs
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"testing"
+
+	"github.com/digital-dream-labs/vector-cloud/internal/clad/cloud"
+	"github.com/digital-dream-labs/vector-cloud/internal/token"
+
+	pb "github.com/digital-dream-labs/api/go/jdocspb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	gc "google.golang.org/grpc/credentials"
+)
+
+// countingListener counts the connections it accepts
+type countingListener struct {
+	net.Listener
+	accepted int32
+}
+
+func (l *countingListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err == nil {
+		atomic.AddInt32(&l.accepted, 1)
+	}
+	return c, err
+}
+
+type readServer struct {
+	pb.UnimplementedJdocsServer
+}
+
+func (s *readServer) ReadDocs(ctx context.Context, in *pb.ReadDocsReq) (*pb.ReadDocsResp, error) {
+	return &pb.ReadDocsResp{}, nil
+}
+
+func TestPoolSharesConnection(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	counter := &countingListener{Listener: lis}
+	srv := grpc.NewServer()
+	pb.RegisterJdocsServer(srv, &readServer{})
+	go srv.Serve(counter)
+	defer srv.Stop()
+
+	pool := &connPool{target: lis.Addr().String(), transport: grpc.WithInsecure()}
+	read := cloud.NewDocRequestWithRead(&cloud.ReadRequest{Items: []cloud.ReadItem{{DocName: "doc"}}})
+	for i := 0; i < 3; i++ {
+		c, err := pool.get(context.Background(), "")
+		require.NoError(t, err)
+		resp, err := c.handleRequest(context.Background(), read)
+		require.NoError(t, err)
+		assert.Equal(t, cloud.DocResponseTag_Read, resp.Tag())
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&counter.accepted))
+
+	require.NoError(t, pool.close())
+	_, err = pool.get(context.Background(), "")
+	assert.Equal(t, errPoolClosed, err)
+}
+
+type userCreds string
+
+func (u userCreds) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{"authorization": string(u)}, nil
+}
+
+func (u userCreds) RequireTransportSecurity() bool {
+	return true
+}
+
+// refreshingTokener hands out a new token for every request
+type refreshingTokener struct {
+	token.Accessor
+	count int
+}
+
+func (r *refreshingTokener) UserCredentials(_ context.Context, userID string) (gc.PerRPCCredentials, error) {
+	r.count++
+	if userID == "" {
+		userID = "active"
+	}
+	return userCreds(fmt.Sprint(userID, r.count)), nil
+}
+
+func TestAccountCredentials(t *testing.T) {
+	creds := accountCredentials{&refreshingTokener{}}
+
+	md, err := creds.GetRequestMetadata(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "active1", md["authorization"])
+
+	md, err = creds.GetRequestMetadata(withAccount(context.Background(), "user"))
+	require.NoError(t, err)
+	assert.Equal(t, "user2", md["authorization"])
+
+	md, err = creds.GetRequestMetadata(withAccount(context.Background(), "user"))
+	require.NoError(t, err)
+	assert.Equal(t, "user3", md["authorization"])
+}
@@ -0,0 +1,143 @@
+package jdocs
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// jsonSchema is the part of JSON Schema that document formats are described with:
+// type, properties, required, items and additionalProperties
+type jsonSchema struct {
+	Type                 string                 `json:"type"`
+	Properties           map[string]*jsonSchema `json:"properties"`
+	Required             []string               `json:"required"`
+	Items                *jsonSchema            `json:"items"`
+	AdditionalProperties *bool                  `json:"additionalProperties"`
+}
+
+var schemaTypes = map[string]bool{
+	"": true, "object": true, "array": true, "string": true, "number": true,
+	"integer": true, "boolean": true, "null": true,
+}
+
+func parseSchema(text string) (*jsonSchema, error) {
+	var s jsonSchema
+	if err := json.Unmarshal([]byte(text), &s); err != nil {
+		return nil, err
+	}
+	if err := s.check(""); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// check makes sure the schema only uses types it knows
+func (s *jsonSchema) check(path string) error {
+	if !schemaTypes[s.Type] {
+		return fmt.Errorf("%s: unknown type %q", pathName(path), s.Type)
+	}
+	for name, prop := range s.Properties {
+		if err := prop.check(path + "." + name); err != nil {
+			return err
+		}
+	}
+	if s.Items != nil {
+		return s.Items.check(path + "[]")
+	}
+	return nil
+}
+
+// decodeJSON decodes a document, keeping numbers as they were written
+func decodeJSON(doc string) (interface{}, error) {
+	dec := json.NewDecoder(strings.NewReader(doc))
+	dec.UseNumber()
+	var ret interface{}
+	if err := dec.Decode(&ret); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, fmt.Errorf("unexpected data after document")
+	}
+	return ret, nil
+}
+
+// validate returns an error describing the first place value doesn't match the schema
+func (s *jsonSchema) validate(value interface{}) error {
+	return s.validateAt("", value)
+}
+
+func (s *jsonSchema) validateAt(path string, value interface{}) error {
+	if s.Type != "" && jsonType(value, s.Type) != s.Type {
+		return fmt.Errorf("%s: expected %s, got %s", pathName(path), s.Type, jsonType(value, ""))
+	}
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				return fmt.Errorf("%s: missing %q", pathName(path), name)
+			}
+		}
+		// in order, so that the same document always gets the same error
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			prop, ok := s.Properties[name]
+			if !ok {
+				if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+					return fmt.Errorf("%s: unexpected %q", pathName(path), name)
+				}
+				continue
+			}
+			if err := prop.validateAt(path+"."+name, v[name]); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		if s.Items == nil {
+			break
+		}
+		for i, item := range v {
+			if err := s.Items.validateAt(fmt.Sprintf("%s[%d]", path, i), item); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// jsonType returns the JSON type of a decoded value; a whole number is reported as
+// an integer only if want is integer, since it's also a number
+func jsonType(value interface{}, want string) string {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case nil:
+		return "null"
+	case json.Number:
+		if want == "integer" {
+			if _, err := v.Int64(); err == nil {
+				return "integer"
+			}
+		}
+		return "number"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+func pathName(path string) string {
+	if path == "" {
+		return "document"
+	}
+	return strings.TrimPrefix(path, ".")
+}
@@ -12,7 +12,7 @@ import (
 	"github.com/digital-dream-labs/vector-cloud/internal/log"
 )
 
-func runServer(ctx context.Context, opts *options) {
+func runServer(ctx context.Context, opts *options, h *handler, userID func() string) {
 	socketName := "jdocs_server"
 	if opts.socketNameSuffix != "" {
 		socketName = fmt.Sprintf("%s_%s", socketName, opts.socketNameSuffix)
@@ -24,20 +24,76 @@ func runServer(ctx context.Context, opts *options) {
 		return
 	}
 
+	read := func(ctx context.Context, req *cloud.ReadRequest) (*cloud.DocResponse, error) {
+		return h.handleRequest(ctx, cloud.NewDocRequestWithRead(req))
+	}
+	w := newWatcher(read, userID)
+	go w.run(ctx)
+
 	// close on context?
 	for c := range serv.NewConns() {
-		cl := client{Conn: c, opts: opts}
+		cl := &client{Conn: c, opts: opts, handler: h, watcher: w}
 		go cl.handleConn(ctx)
 	}
 }
 
+// handler sends document requests to the server, through the cache if there is one,
+// checking that documents match their formats
+type handler struct {
+	pool    *connPool
+	cache   *docCache
+	formats *formatRegistry
+}
+
+func (h *handler) handleRequest(ctx context.Context, msg *cloud.DocRequest) (*cloud.DocResponse, error) {
+	if h.formats != nil {
+		var writes []*cloud.WriteRequest
+		if write := msg.GetWrite(); write != nil {
+			writes = append(writes, write)
+		} else if batch := msg.GetBatch(); batch != nil {
+			for i := range batch.Writes {
+				writes = append(writes, &batch.Writes[i])
+			}
+		}
+		for _, write := range writes {
+			if err := h.formats.checkWrite(write); err != nil {
+				return invalidDocResponse, err
+			}
+		}
+	}
+	resp, err := h.send(ctx, msg)
+	if read := msg.GetRead(); read != nil && resp != nil && resp.GetRead() != nil && h.formats != nil {
+		for i, item := range resp.GetRead().Items {
+			if item.Status == cloud.ReadStatus_Changed && i < len(read.Items) {
+				h.formats.upgradeRead(read.Items[i].DocName, &resp.GetRead().Items[i].Doc)
+			}
+		}
+	}
+	return resp, err
+}
+
+func (h *handler) send(ctx context.Context, msg *cloud.DocRequest) (*cloud.DocResponse, error) {
+	if h.cache != nil {
+		return h.cache.handleRequest(ctx, msg)
+	}
+	conn, err := h.pool.get(ctx, requestAccount(msg))
+	if err != nil {
+		return connectErrorResponse, err
+	}
+	return conn.handleRequest(ctx, msg)
+}
+
 type client struct {
 	ipc.Conn
-	opts     *options
-	reqMutex sync.Mutex
+	opts    *options
+	handler *handler
+	watcher *watcher
+	// writeMu keeps responses and pushed changes from interleaving
+	writeMu sync.Mutex
 }
 
 func (c *client) handleConn(ctx context.Context) {
+	defer c.watcher.unsubscribe(c)
 	for {
 		buf := c.ReadBlock()
 		// TODO: will this ever close?
@@ -58,26 +114,48 @@ func (c *client) handleConn(ctx context.Context) {
 			}
 		}
 		if resp != nil {
-			var buf bytes.Buffer
-			if err := resp.Pack(&buf); err != nil {
-				log.Println("Error packing jdocs response:", err)
-			} else if n, err := c.Write(buf.Bytes()); n != buf.Len() || err != nil {
-				log.Println("Error sending jdocs response:", fmt.Sprintf("%d/%d,", n, buf.Len()), err)
-			}
+			c.send(resp)
+		}
+		if msg.Tag() == cloud.DocRequestTag_Subscribe {
+			// subscribers get the current state of their documents after the response
+			c.watcher.wake()
 		}
 	}
 }
 
+func (c *client) send(resp *cloud.DocResponse) {
+	var buf bytes.Buffer
+	if err := resp.Pack(&buf); err != nil {
+		log.Println("Error packing jdocs response:", err)
+		return
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if n, err := c.Write(buf.Bytes()); n != buf.Len() || err != nil {
+		log.Println("Error sending jdocs response:", fmt.Sprintf("%d/%d,", n, buf.Len()), err)
+	}
+}
+
+func (c *client) notify(resp *cloud.DocResponse) {
+	c.send(resp)
+}
+
 func (c *client) handleRequest(ctx context.Context, msg *cloud.DocRequest) (*cloud.DocResponse, error) {
-	c.reqMutex.Lock()
-	defer c.reqMutex.Unlock()
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
 	if ok, resp, err := c.handleConnectionless(msg); ok {
 		return resp, err
 	}
-	conn, err := newConn(ctx, c.opts)
-	if err != nil {
-		return connectErrorResponse, err
+	resp, err := c.handler.handleRequest(ctx, msg)
+	if resp == nil {
+		return resp, err
 	}
-	defer conn.close()
-	return conn.handleRequest(ctx, msg)
+	if write := resp.GetWrite(); write != nil && msg.Tag() == cloud.DocRequestTag_Write {
+		c.watcher.written(msg.GetWrite(), write)
+	} else if batch := resp.GetBatch(); batch != nil && msg.Tag() == cloud.DocRequestTag_Batch {
+		for i := range batch.Writes {
+			c.watcher.written(&msg.GetBatch().Writes[i], &batch.Writes[i])
+		}
+	}
+	return resp, err
 }
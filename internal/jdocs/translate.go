@@ -12,6 +12,7 @@ type pbDoc pb.Jdoc
 type cladWriteReq cloud.WriteRequest
 type cladReadReq cloud.ReadRequest
 type cladDeleteReq cloud.DeleteRequest
+type cladBatchReq cloud.BatchRequest
 
 type protoWriteResp pb.WriteDocResp
 type protoWriteStatus pb.WriteDocResp_Status
@@ -68,6 +69,52 @@ func (c *cladDeleteReq) toProto() *pb.DeleteDocReq {
 	}
 }
 
+// toProto translates the writes and deletes of a batch, which the server takes one
+// at a time
+func (c *cladBatchReq) toProto() ([]*pb.WriteDocReq, []*pb.DeleteDocReq) {
+	writes := make([]*pb.WriteDocReq, len(c.Writes))
+	for i := range c.Writes {
+		writes[i] = (*cladWriteReq)(&c.Writes[i]).toProto()
+	}
+	deletes := make([]*pb.DeleteDocReq, len(c.Deletes))
+	for i := range c.Deletes {
+		deletes[i] = (*cladDeleteReq)(&c.Deletes[i]).toProto()
+	}
+	return writes, deletes
+}
+
+// snapshotReqs returns requests for the current copies of the documents a batch
+// changes, one for each account and thing
+func (c *cladBatchReq) snapshotReqs() []*pb.ReadDocsReq {
+	var ret []*pb.ReadDocsReq
+	seen := make(map[docRef]bool)
+	add := func(account, thing, docName string) {
+		ref := docRef{account, thing, docName}
+		if seen[ref] {
+			return
+		}
+		seen[ref] = true
+		for _, req := range ret {
+			if req.UserId == account && req.Thing == thing {
+				req.Items = append(req.Items, &pb.ReadDocsReq_Item{DocName: docName})
+				return
+			}
+		}
+		ret = append(ret, &pb.ReadDocsReq{
+			UserId: account,
+			Thing:  thing,
+			Items:  []*pb.ReadDocsReq_Item{{DocName: docName}},
+		})
+	}
+	for _, w := range c.Writes {
+		add(w.Account, w.Thing, w.DocName)
+	}
+	for _, d := range c.Deletes {
+		add(d.Account, d.Thing, d.DocName)
+	}
+	return ret
+}
+
 func (p *protoWriteResp) toClad() *cloud.WriteResponse {
 	return &cloud.WriteResponse{
 		LatestVersion: p.LatestDocVersion,
@@ -0,0 +1,218 @@
+package jdocs
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/digital-dream-labs/vector-cloud/internal/clad/cloud"
+
+	"github.com/digital-dream-labs/vector-cloud/internal/log"
+)
+
+// watchInterval is how often subscribed documents are checked for changes; the
+// server can't push them, so they're polled, asking only for newer versions
+const watchInterval = 15 * time.Second
+
+// subscriber is told about changes to the documents it subscribed to
+type subscriber interface {
+	notify(*cloud.DocResponse)
+}
+
+// watchKey identifies a subscribed document; an empty account is the active user's,
+// whoever that is when it's checked
+type watchKey struct {
+	account string
+	thing   string
+	docName string
+}
+
+type watchGroup struct {
+	account string
+	thing   string
+}
+
+// watchedDoc is a document with at least one subscriber
+type watchedDoc struct {
+	// last is what the server last said about the document of account, once known
+	// is set
+	account string
+	last    cloud.ResponseDoc
+	known   bool
+	// subs holds the version of the document each subscriber has, 0 if none
+	subs map[subscriber]uint64
+}
+
+// watcher pushes changes to subscribed documents, both those made through this
+// service and, checking periodically, those made elsewhere
+type watcher struct {
+	read   func(ctx context.Context, req *cloud.ReadRequest) (*cloud.DocResponse, error)
+	userID func() string
+	wakeCh chan struct{}
+
+	mu   sync.Mutex
+	docs map[watchKey]*watchedDoc
+}
+
+func newWatcher(read func(context.Context, *cloud.ReadRequest) (*cloud.DocResponse, error), userID func() string) *watcher {
+	return &watcher{
+		read:   read,
+		userID: userID,
+		wakeCh: make(chan struct{}, 1),
+		docs:   make(map[watchKey]*watchedDoc),
+	}
+}
+
+// subscribe adds the given documents to those sub is told about, starting from the
+// versions it says it has; changes are only sent once the watcher next checks
+func (w *watcher) subscribe(sub subscriber, req *cloud.SubscribeRequest) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, item := range req.Items {
+		key := watchKey{req.Account, req.Thing, item.DocName}
+		doc := w.docs[key]
+		if doc == nil {
+			doc = &watchedDoc{subs: make(map[subscriber]uint64)}
+			w.docs[key] = doc
+		}
+		doc.subs[sub] = item.MyDocVersion
+	}
+}
+
+// unsubscribe stops telling sub about any documents
+func (w *watcher) unsubscribe(sub subscriber) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for key, doc := range w.docs {
+		delete(doc.subs, sub)
+		if len(doc.subs) == 0 {
+			delete(w.docs, key)
+		}
+	}
+}
+
+// written tells subscribers about a document written through this service
+func (w *watcher) written(req *cloud.WriteRequest, resp *cloud.WriteResponse) {
+	if resp.Status != cloud.WriteStatus_Accepted {
+		return
+	}
+	doc := req.Doc
+	doc.DocVersion = resp.LatestVersion
+	got := cloud.ResponseDoc{Status: cloud.ReadStatus_Changed, Doc: doc}
+	active, account := w.userID(), req.Account
+	if account == "" {
+		account = active
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.updateLocked(watchKey{account, req.Thing, req.DocName}, account, got)
+	if account == active {
+		w.updateLocked(watchKey{"", req.Thing, req.DocName}, account, got)
+	}
+}
+
+// wake makes the watcher check for changes now rather than at its next interval
+func (w *watcher) wake() {
+	select {
+	case w.wakeCh <- struct{}{}:
+	default:
+	}
+}
+
+// run checks for changes until ctx is done
+func (w *watcher) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-w.wakeCh:
+		case <-time.After(watchInterval):
+		}
+		w.check(ctx)
+	}
+}
+
+// check reads the subscribed documents that changed since they were last seen, and
+// tells their subscribers
+func (w *watcher) check(ctx context.Context) {
+	// the active user may have changed since the last check
+	active := w.userID()
+	w.mu.Lock()
+	groups := make(map[watchGroup]*cloud.ReadRequest)
+	for key, doc := range w.docs {
+		account := key.account
+		if account == "" {
+			account = active
+		}
+		group := watchGroup{key.account, key.thing}
+		req := groups[group]
+		if req == nil {
+			req = &cloud.ReadRequest{Account: account, Thing: key.thing}
+			groups[group] = req
+		}
+		item := cloud.ReadItem{DocName: key.docName}
+		if doc.known && doc.account == account {
+			item.MyDocVersion = doc.last.Doc.DocVersion
+		}
+		req.Items = append(req.Items, item)
+	}
+	w.mu.Unlock()
+
+	for group, req := range groups {
+		reqCtx, cancel := context.WithTimeout(ctx, requestTimeout)
+		resp, err := w.read(reqCtx, req)
+		cancel()
+		if err != nil {
+			log.Println("Error checking subscribed jdocs:", err)
+			continue
+		}
+		read := resp.GetRead()
+		if read == nil || len(read.Items) != len(req.Items) {
+			log.Println("Unexpected response checking subscribed jdocs:", resp)
+			continue
+		}
+		w.mu.Lock()
+		for i, got := range read.Items {
+			key := watchKey{group.account, req.Thing, req.Items[i].DocName}
+			if got.Status == cloud.ReadStatus_Unchanged {
+				if doc := w.docs[key]; doc != nil && doc.known && doc.account == req.Account {
+					got = doc.last
+				} else {
+					continue
+				}
+			}
+			w.updateLocked(key, req.Account, got)
+		}
+		w.mu.Unlock()
+	}
+}
+
+// updateLocked records what's now known about a document of the given account and
+// tells the subscribers that don't have it yet
+func (w *watcher) updateLocked(key watchKey, account string, got cloud.ResponseDoc) {
+	doc := w.docs[key]
+	if doc == nil {
+		return
+	}
+	// the versions subscribers have are of another account's copy if the active
+	// user changed
+	switched := doc.known && doc.account != account
+	doc.account, doc.last, doc.known = account, got, true
+	var version uint64
+	if got.Status == cloud.ReadStatus_Changed {
+		version = got.Doc.DocVersion
+	}
+	for sub, has := range doc.subs {
+		if has == version && !switched {
+			continue
+		}
+		doc.subs[sub] = version
+		sub.notify(cloud.NewDocResponseWithChanged(&cloud.DocChange{
+			Account: account,
+			Thing:   key.thing,
+			DocName: key.docName,
+			Status:  got.Status,
+			Doc:     got.Doc,
+		}))
+	}
+}
@@ -0,0 +1,185 @@
+package jdocs
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/digital-dream-labs/vector-cloud/internal/clad/cloud"
+
+	pb "github.com/digital-dream-labs/api/go/jdocspb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testSubscriber struct {
+	changes []*cloud.DocChange
+}
+
+func (s *testSubscriber) notify(resp *cloud.DocResponse) {
+	s.changes = append(s.changes, resp.GetChanged())
+}
+
+// take returns the changes the subscriber was told about since it was last asked
+func (s *testSubscriber) take() []*cloud.DocChange {
+	ret := s.changes
+	s.changes = nil
+	return ret
+}
+
+func newTestWatcher(server *testServer) *watcher {
+	read := func(ctx context.Context, req *cloud.ReadRequest) (*cloud.DocResponse, error) {
+		c := &conn{client: server}
+		return c.readRequest(ctx, req)
+	}
+	return newWatcher(read, func() string { return "user" })
+}
+
+func subscribeRequest(docName string, version uint64) *cloud.SubscribeRequest {
+	return &cloud.SubscribeRequest{
+		Thing: "vic:00000000",
+		Items: []cloud.ReadItem{{DocName: docName, MyDocVersion: version}},
+	}
+}
+
+func TestWatcher(t *testing.T) {
+	server := newTestServer()
+	server.docs["vic.AppTokens"] = &pb.Jdoc{DocVersion: 1, JsonDoc: `{"client_tokens":[]}`}
+	w := newTestWatcher(server)
+	ctx := context.Background()
+
+	// a subscriber without the document is sent it on the next check
+	sub := &testSubscriber{}
+	w.subscribe(sub, subscribeRequest("vic.AppTokens", 0))
+	assert.Empty(t, sub.take())
+	w.check(ctx)
+	changes := sub.take()
+	require.Len(t, changes, 1)
+	assert.Equal(t, "user", changes[0].Account)
+	assert.Equal(t, "vic.AppTokens", changes[0].DocName)
+	assert.Equal(t, cloud.ReadStatus_Changed, changes[0].Status)
+	assert.Equal(t, uint64(1), changes[0].Doc.DocVersion)
+
+	// and after that only asked for newer versions
+	w.check(ctx)
+	assert.Empty(t, sub.take())
+	assert.Equal(t, uint64(1), server.reads[len(server.reads)-1].Items[0].MyDocVersion)
+
+	// changes made elsewhere are found by checking
+	server.docs["vic.AppTokens"] = &pb.Jdoc{DocVersion: 2, JsonDoc: `{"client_tokens":[{"hash":"a"}]}`}
+	w.check(ctx)
+	changes = sub.take()
+	require.Len(t, changes, 1)
+	assert.Equal(t, `{"client_tokens":[{"hash":"a"}]}`, changes[0].Doc.JsonDoc)
+
+	// a subscriber that's up to date isn't sent anything, while a later one that
+	// isn't is sent what's already known
+	current, behind := &testSubscriber{}, &testSubscriber{}
+	w.subscribe(current, subscribeRequest("vic.AppTokens", 2))
+	w.subscribe(behind, subscribeRequest("vic.AppTokens", 1))
+	w.check(ctx)
+	assert.Empty(t, sub.take())
+	assert.Empty(t, current.take())
+	require.Len(t, behind.take(), 1)
+
+	// writes through the service are sent right away
+	w.written(&cloud.WriteRequest{Thing: "vic:00000000", DocName: "vic.AppTokens", Doc: cloud.Doc{DocVersion: 2, JsonDoc: "{}"}},
+		&cloud.WriteResponse{Status: cloud.WriteStatus_Accepted, LatestVersion: 3})
+	for _, s := range []*testSubscriber{sub, current, behind} {
+		changes = s.take()
+		require.Len(t, changes, 1)
+		assert.Equal(t, uint64(3), changes[0].Doc.DocVersion)
+	}
+	w.written(&cloud.WriteRequest{Thing: "vic:00000000", DocName: "vic.AppTokens"},
+		&cloud.WriteResponse{Status: cloud.WriteStatus_RejectedDocVersion, LatestVersion: 4})
+	assert.Empty(t, sub.take())
+
+	// deletions are sent too
+	delete(server.docs, "vic.AppTokens")
+	w.unsubscribe(current)
+	w.unsubscribe(behind)
+	w.check(ctx)
+	changes = sub.take()
+	require.Len(t, changes, 1)
+	assert.Equal(t, cloud.ReadStatus_NotFound, changes[0].Status)
+	assert.Empty(t, current.take())
+	assert.Empty(t, behind.take())
+
+	// nothing is sent while the server can't be reached
+	server.docs["vic.AppTokens"] = &pb.Jdoc{DocVersion: 5}
+	server.setOffline(true)
+	w.check(ctx)
+	assert.Empty(t, sub.take())
+	server.setOffline(false)
+	w.check(ctx)
+	assert.Len(t, sub.take(), 1)
+
+	// documents no one subscribes to anymore aren't checked
+	w.unsubscribe(sub)
+	reads := len(server.reads)
+	w.check(ctx)
+	assert.Equal(t, reads, len(server.reads))
+}
+
+func TestWatcherActiveUser(t *testing.T) {
+	server := newTestServer()
+	server.docs["vic.AppTokens"] = &pb.Jdoc{DocVersion: 1, JsonDoc: "{}"}
+	user := "a"
+	read := func(ctx context.Context, req *cloud.ReadRequest) (*cloud.DocResponse, error) {
+		c := &conn{client: server}
+		return c.readRequest(ctx, req)
+	}
+	w := newWatcher(read, func() string { return user })
+	ctx := context.Background()
+
+	sub := &testSubscriber{}
+	w.subscribe(sub, subscribeRequest("vic.AppTokens", 0))
+	w.check(ctx)
+	changes := sub.take()
+	require.Len(t, changes, 1)
+	assert.Equal(t, "a", changes[0].Account)
+
+	// once someone else is the active user, their copy is checked from scratch
+	user = "b"
+	w.check(ctx)
+	last := server.reads[len(server.reads)-1]
+	assert.Equal(t, "b", last.UserId)
+	assert.Equal(t, uint64(0), last.Items[0].MyDocVersion)
+	changes = sub.take()
+	require.Len(t, changes, 1)
+	assert.Equal(t, "b", changes[0].Account)
+
+	// and writes to it are sent right away
+	w.written(&cloud.WriteRequest{Account: "b", Thing: "vic:00000000", DocName: "vic.AppTokens", Doc: cloud.Doc{DocVersion: 1}},
+		&cloud.WriteResponse{Status: cloud.WriteStatus_Accepted, LatestVersion: 2})
+	changes = sub.take()
+	require.Len(t, changes, 1)
+	assert.Equal(t, uint64(2), changes[0].Doc.DocVersion)
+	w.written(&cloud.WriteRequest{Account: "a", Thing: "vic:00000000", DocName: "vic.AppTokens", Doc: cloud.Doc{DocVersion: 1}},
+		&cloud.WriteResponse{Status: cloud.WriteStatus_Accepted, LatestVersion: 2})
+	assert.Empty(t, sub.take())
+}
+
+func TestDocChangePacking(t *testing.T) {
+	msg := cloud.NewDocResponseWithChanged(&cloud.DocChange{
+		Account: "user",
+		Thing:   "vic:00000000",
+		DocName: "vic.AppTokens",
+		Status:  cloud.ReadStatus_Changed,
+		Doc:     cloud.Doc{DocVersion: 3, JsonDoc: "{}"},
+	})
+	var buf bytes.Buffer
+	require.NoError(t, msg.Pack(&buf))
+	assert.Equal(t, int(msg.Size()), buf.Len())
+	var got cloud.DocResponse
+	require.NoError(t, got.Unpack(&buf))
+	assert.Equal(t, msg.GetChanged(), got.GetChanged())
+
+	req := cloud.NewDocRequestWithSubscribe(subscribeRequest("vic.AppTokens", 2))
+	buf.Reset()
+	require.NoError(t, req.Pack(&buf))
+	assert.Equal(t, int(req.Size()), buf.Len())
+	var gotReq cloud.DocRequest
+	require.NoError(t, gotReq.Unpack(&buf))
+	assert.Equal(t, req.GetSubscribe(), gotReq.GetSubscribe())
+}
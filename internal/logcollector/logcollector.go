@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"os"
 	"path"
+	"sync"
 	"time"
 
 	"github.com/digital-dream-labs/vector-cloud/internal/log"
@@ -33,7 +34,10 @@ type logCollector struct {
 
 	httpClient *http.Client
 
-	uploader *s3manager.Uploader
+	uploaderMutex sync.Mutex
+	uploader      *s3manager.Uploader
+	// uploaderUser is the user whose credentials the uploader was created with
+	uploaderUser string
 }
 
 func newLogCollector(opts *options) (*logCollector, error) {
@@ -56,9 +60,19 @@ func newLogCollector(opts *options) (*logCollector, error) {
 
 	c.certCommonName = opts.tokener.IdentityProvider().CertCommonName()
 
+	if err := c.initUploader(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// initUploader creates an uploader using STS credentials obtained with the active
+// user's token
+func (c *logCollector) initUploader() error {
 	awsCredentials, err := c.tokener.GetStsCredentials()
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	awsSession, err := session.NewSession(&aws.Config{
@@ -72,12 +86,25 @@ func newLogCollector(opts *options) (*logCollector, error) {
 		DisableSSL:       aws.Bool(c.disableSSL),
 	})
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	c.uploader = s3manager.NewUploader(awsSession)
+	c.uploaderUser = c.tokener.UserID()
 
-	return c, nil
+	return nil
+}
+
+// userUploader returns an uploader for the active user
+func (c *logCollector) userUploader() (*s3manager.Uploader, error) {
+	c.uploaderMutex.Lock()
+	defer c.uploaderMutex.Unlock()
+	if c.tokener != nil && c.tokener.UserID() != c.uploaderUser {
+		if err := c.initUploader(); err != nil {
+			return nil, err
+		}
+	}
+	return c.uploader, nil
 }
 
 // Upload uploads file to cloud
@@ -94,6 +121,13 @@ func (c *logCollector) Upload(ctx context.Context, logFilePath string) (string,
 		}
 	}
 
+	// upload with the active user's credentials, which may not be the ones the
+	// uploader was created with
+	uploader, err := c.userUploader()
+	if err != nil {
+		return "", err
+	}
+
 	timestamp := time.Now().UTC()
 
 	encodedCertCommonName := base64.StdEncoding.EncodeToString([]byte(c.certCommonName))
@@ -112,7 +146,7 @@ func (c *logCollector) Upload(ctx context.Context, logFilePath string) (string,
 		Body:   logFile,
 	}
 
-	result, err := c.uploader.UploadWithContext(ctx, uploadInput)
+	result, err := uploader.UploadWithContext(ctx, uploadInput)
 
 	if err != nil {
 		if awsErr, ok := err.(awserr.Error); ok {
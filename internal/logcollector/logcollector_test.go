@@ -38,6 +38,10 @@ func (t TestTokener) Credentials() (gc.PerRPCCredentials, error) {
 	return nil, nil
 }
 
+func (t TestTokener) UserCredentials(context.Context, string) (gc.PerRPCCredentials, error) {
+	return nil, nil
+}
+
 func (t TestTokener) UserID() string {
 	return testUserID
 }
@@ -128,9 +128,7 @@ func PEMData(cloudDir string) ([]byte, error) {
 // TLSKeyPair returns the public and private key in the given
 // factory directory as a tls.Certificate
 func TLSKeyPair(cloudDir string) (tls.Certificate, error) {
-	certFile := filepath.Join(cloudDir, CertFilename)
-	keysFile := filepath.Join(cloudDir, KeysFilename)
-	return tls.LoadX509KeyPair(certFile, keysFile)
+	return TLSCertificate(DeviceKeySource(cloudDir))
 }
 
 var certCommonName string
@@ -5,4 +5,7 @@ package robot
 const (
 	GatewayKey  = "/tmp/anki/gateway/trust.key"
 	GatewayCert = "/tmp/anki/gateway/trust.cert"
+
+	// GatewayTokensFile holds the hashes of the client tokens vic-gateway accepts
+	GatewayTokensFile = "/tmp/anki/gateway/token-hashes.json"
 )
@@ -5,4 +5,7 @@ package robot
 const (
 	GatewayKey  = "/data/etc/robot.pem"
 	GatewayCert = "/data/vic-gateway/gateway.cert"
+
+	// GatewayTokensFile holds the hashes of the client tokens vic-gateway accepts
+	GatewayTokensFile = "/data/vic-gateway/token-hashes.json"
 )
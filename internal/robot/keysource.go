@@ -0,0 +1,123 @@
+package robot
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+)
+
+// KeySource provides a certificate and a signer for its private key, so that the key
+// can live somewhere other than a file we read it from, e.g. a keystore or an
+// external signer process
+type KeySource interface {
+	// Certificate returns the DER encoded certificate chain, leaf first
+	Certificate() ([][]byte, error)
+	// Signer returns the certificate's private key
+	Signer() (crypto.Signer, error)
+}
+
+// fileKeySource is a KeySource that reads PEM encoded files
+type fileKeySource struct {
+	certFile string
+	keyFile  string
+}
+
+// NewFileKeySource returns a KeySource that reads the certificate and private key
+// from the given PEM files
+func NewFileKeySource(certFile, keyFile string) KeySource {
+	return &fileKeySource{certFile: certFile, keyFile: keyFile}
+}
+
+// DeviceKeySource returns the source of the robot's certificate and key in the given
+// factory directory
+func DeviceKeySource(cloudDir string) KeySource {
+	return NewFileKeySource(filepath.Join(cloudDir, CertFilename), filepath.Join(cloudDir, KeysFilename))
+}
+
+// GatewayKeySource returns the source of vic-gateway's certificate and key
+func GatewayKeySource() KeySource {
+	return NewFileKeySource(GatewayCert, GatewayKey)
+}
+
+func (s *fileKeySource) Certificate() ([][]byte, error) {
+	buf, err := ioutil.ReadFile(s.certFile)
+	if err != nil {
+		return nil, err
+	}
+	var chain [][]byte
+	for {
+		var block *pem.Block
+		block, buf = pem.Decode(buf)
+		if block == nil {
+			break
+		}
+		if block.Type == "CERTIFICATE" {
+			chain = append(chain, block.Bytes)
+		}
+	}
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("no certificate found in %s", s.certFile)
+	}
+	return chain, nil
+}
+
+func (s *fileKeySource) Signer() (crypto.Signer, error) {
+	// let the TLS package deal with the many formats a key file might be in
+	pair, err := tls.LoadX509KeyPair(s.certFile, s.keyFile)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := pair.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("key in %s can't be used for signing", s.keyFile)
+	}
+	return signer, nil
+}
+
+// TLSCertificate returns the certificate and key of the given source for use in TLS,
+// checking that they belong together
+func TLSCertificate(src KeySource) (tls.Certificate, error) {
+	chain, err := src.Certificate()
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	leaf, err := x509.ParseCertificate(chain[0])
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	signer, err := src.Signer()
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	certKey, err := x509.MarshalPKIXPublicKey(leaf.PublicKey)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	signerKey, err := x509.MarshalPKIXPublicKey(signer.Public())
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	if !bytes.Equal(certKey, signerKey) {
+		return tls.Certificate{}, errors.New("private key does not match certificate")
+	}
+	return tls.Certificate{Certificate: chain, PrivateKey: signer, Leaf: leaf}, nil
+}
+
+// KeySourceCommonName returns the CommonName field of the given source's certificate
+func KeySourceCommonName(src KeySource) (string, error) {
+	chain, err := src.Certificate()
+	if err != nil {
+		return "", err
+	}
+	cert, err := x509.ParseCertificate(chain[0])
+	if err != nil {
+		return "", err
+	}
+	return cert.Subject.CommonName, nil
+}
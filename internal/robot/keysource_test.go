@@ -0,0 +1,102 @@
+package robot
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testTemplate(commonName string) *x509.Certificate {
+	return &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+}
+
+func TestFileKeySource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keysource")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.CreateCertificate(rand.Reader, testTemplate("vic:00000000"), testTemplate("vic:00000000"), &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, CertFilename),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, KeysFilename),
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer}), 0600))
+
+	src := DeviceKeySource(dir)
+	cert, err := TLSCertificate(src)
+	require.NoError(t, err)
+	assert.Equal(t, "vic:00000000", cert.Leaf.Subject.CommonName)
+	name, err := KeySourceCommonName(src)
+	require.NoError(t, err)
+	assert.Equal(t, "vic:00000000", name)
+
+	// same as loading the files directly
+	pair, err := TLSKeyPair(dir)
+	require.NoError(t, err)
+	loaded, err := tls.LoadX509KeyPair(filepath.Join(dir, CertFilename), filepath.Join(dir, KeysFilename))
+	require.NoError(t, err)
+	assert.Equal(t, loaded.Certificate, pair.Certificate)
+}
+
+func TestSoftKeystore(t *testing.T) {
+	ks := NewSoftKeystore()
+	src := ks.KeySource("device")
+	_, err := TLSCertificate(src)
+	assert.Error(t, err)
+
+	require.NoError(t, ks.Generate("device", testTemplate("vic:00000000")))
+	cert, err := TLSCertificate(src)
+	require.NoError(t, err)
+	assert.Equal(t, "vic:00000000", cert.Leaf.Subject.CommonName)
+
+	// the key can sign but not be exported
+	signer, err := src.Signer()
+	require.NoError(t, err)
+	digest := make([]byte, 32)
+	sig, err := signer.Sign(rand.Reader, digest, nil)
+	require.NoError(t, err)
+	var esig struct{ R, S *big.Int }
+	_, err = asn1.Unmarshal(sig, &esig)
+	require.NoError(t, err)
+	assert.True(t, ecdsa.Verify(cert.Leaf.PublicKey.(*ecdsa.PublicKey), digest, esig.R, esig.S))
+	_, err = x509.MarshalPKCS8PrivateKey(signer)
+	assert.Error(t, err)
+
+	// a certificate that doesn't go with the key is refused
+	other := NewSoftKeystore()
+	require.NoError(t, other.Generate("device", testTemplate("vic:11111111")))
+	otherChain, err := other.KeySource("device").Certificate()
+	require.NoError(t, err)
+	signer, err = src.Signer()
+	require.NoError(t, err)
+	ks.Import("mismatched", otherChain, signer)
+	_, err = TLSCertificate(ks.KeySource("mismatched"))
+	assert.Error(t, err)
+
+	ks.Delete("device")
+	_, err = src.Certificate()
+	assert.Error(t, err)
+}
@@ -0,0 +1,108 @@
+package robot
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// SoftKeystore is an in-memory keystore, mostly for tests. Like a hardware keystore,
+// it only hands out signers for the keys it holds, never the keys themselves.
+type SoftKeystore struct {
+	mu      sync.Mutex
+	entries map[string]*softKeyEntry
+}
+
+type softKeyEntry struct {
+	chain [][]byte
+	key   crypto.Signer
+}
+
+// NewSoftKeystore creates an empty keystore
+func NewSoftKeystore() *SoftKeystore {
+	return &SoftKeystore{entries: make(map[string]*softKeyEntry)}
+}
+
+// Import stores the given certificate chain and key under the given label
+func (k *SoftKeystore) Import(label string, chain [][]byte, key crypto.Signer) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.entries[label] = &softKeyEntry{chain: chain, key: key}
+}
+
+// Generate creates a P-256 key under the given label, along with a certificate for it
+// made from template and signed by the key itself
+func (k *SoftKeystore) Generate(label string, template *x509.Certificate) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	k.Import(label, [][]byte{der}, key)
+	return nil
+}
+
+// Delete removes the key under the given label
+func (k *SoftKeystore) Delete(label string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	delete(k.entries, label)
+}
+
+// KeySource returns a KeySource for the key under the given label; it fails until a
+// key is stored there
+func (k *SoftKeystore) KeySource(label string) KeySource {
+	return &softKeySource{keystore: k, label: label}
+}
+
+func (k *SoftKeystore) entry(label string) (*softKeyEntry, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	e, ok := k.entries[label]
+	if !ok {
+		return nil, fmt.Errorf("no key in keystore with label %q", label)
+	}
+	return e, nil
+}
+
+type softKeySource struct {
+	keystore *SoftKeystore
+	label    string
+}
+
+func (s *softKeySource) Certificate() ([][]byte, error) {
+	e, err := s.keystore.entry(s.label)
+	if err != nil {
+		return nil, err
+	}
+	return e.chain, nil
+}
+
+func (s *softKeySource) Signer() (crypto.Signer, error) {
+	e, err := s.keystore.entry(s.label)
+	if err != nil {
+		return nil, err
+	}
+	return opaqueSigner{e.key}, nil
+}
+
+// opaqueSigner hides the concrete type of a key so that it can only be used to sign
+type opaqueSigner struct {
+	key crypto.Signer
+}
+
+func (s opaqueSigner) Public() crypto.PublicKey {
+	return s.key.Public()
+}
+
+func (s opaqueSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	return s.key.Sign(rand, digest, opts)
+}
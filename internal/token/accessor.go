@@ -1,6 +1,7 @@
 package token
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/digital-dream-labs/vector-cloud/internal/clad/cloud"
@@ -15,6 +16,9 @@ import (
 
 type Accessor interface {
 	Credentials() (gc.PerRPCCredentials, error)
+	// UserCredentials returns credentials using the token of the given user, or of
+	// the active user if userID is empty, giving up when ctx is done
+	UserCredentials(ctx context.Context, userID string) (gc.PerRPCCredentials, error)
 	GetStsCredentials() (*ac.Credentials, error)
 	IdentityProvider() identity.Provider
 	UserID() string
@@ -22,13 +26,17 @@ type Accessor interface {
 
 type accessor struct {
 	identityProvider identity.Provider
-	stsCache         stsCredentialsCache
+	stsCache         *stsCredentialsCache
 	handler          RequestHandler
 }
 
 func (a accessor) Credentials() (gc.PerRPCCredentials, error) {
-	req := cloud.NewTokenRequestWithJwt(&cloud.JwtRequest{})
-	resp, err := a.handler.handleRequest(req)
+	return a.UserCredentials(context.Background(), "")
+}
+
+func (a accessor) UserCredentials(ctx context.Context, userID string) (gc.PerRPCCredentials, error) {
+	req := cloud.NewTokenRequestWithJwt(&cloud.JwtRequest{UserId: userID})
+	resp, err := a.handler.handleRequest(ctx, req)
 	if err != nil {
 		return nil, err
 	}
@@ -56,7 +64,14 @@ func (a accessor) IdentityProvider() identity.Provider {
 }
 
 func GetAccessor(identityProvider identity.Provider, handler RequestHandler) Accessor {
-	return &accessor{identityProvider: identityProvider, handler: handler}
+	// accessors for a server share its STS credentials, which it keeps refreshed
+	var stsCache *stsCredentialsCache
+	if s, ok := handler.(*Server); ok {
+		stsCache = s.stsCredentials()
+	} else {
+		stsCache = new(stsCredentialsCache)
+	}
+	return &accessor{identityProvider: identityProvider, stsCache: stsCache, handler: handler}
 }
 
 func tokenMetadata(jwtToken string) util.MapCredentials {
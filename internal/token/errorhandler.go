@@ -2,6 +2,7 @@ package token
 
 import (
 	"context"
+	"sync"
 	"time"
 
 	"github.com/digital-dream-labs/vector-cloud/internal/clad/cloud"
@@ -13,38 +14,57 @@ import (
 	"google.golang.org/grpc/status"
 )
 
+// retryInitialInterval is roughly how long the backoff handler first waits between
+// retries of a refused token refresh; the first retry is made right away
+const retryInitialInterval = 10 * time.Second
+
 type backoffHandler struct {
 	handler RequestHandler
+	// mu guards the fields below
+	mu      sync.Mutex
 	cancel  context.CancelFunc
 	backoff backoff.BackOff
 	denied  bool
 }
 
 func (b *backoffHandler) reset() {
-	// reset can be called from multiple routines, so store cancel in a temp var before
+	// reset can be called from multiple routines, so take cancel under the lock before
 	// executing it so something else doesn't set it to nil first
+	b.mu.Lock()
 	f := b.cancel
 	b.cancel = nil
+	b.backoff = nil
+	b.mu.Unlock()
 	if f != nil {
 		f()
 	}
-	if b.backoff != nil {
-		b.backoff = nil
-	}
 }
 
 func (b *backoffHandler) onSuccess() {
 	// if backoff retries were previously disabled due to repeated PermissionDenied errors,
 	// a successful call to the server should reset that state
+	b.mu.Lock()
+	defer b.mu.Unlock()
 	b.denied = false
 }
 
+// state returns whether a backoff is currently retrying token refreshes, and whether
+// retries have been given up on due to repeated PermissionDenied errors
+func (b *backoffHandler) state() (backingOff bool, denied bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.backoff != nil, b.denied
+}
+
 func (b *backoffHandler) OnError(err error) {
 	// we only care about grpc PermissionDenied errors
 	if status.Code(err) != codes.PermissionDenied {
 		return
 	}
 
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
 	// if we already have a backoff running, ignore
 	if b.backoff != nil {
 		return
@@ -57,12 +77,13 @@ func (b *backoffHandler) OnError(err error) {
 
 	// create backoff to manage retry attempts
 	bo := backoff.NewExponentialBackOff()
-	bo.InitialInterval = 10 * time.Second
+	bo.InitialInterval = retryInitialInterval
 	bo.Multiplier = 1.5
 	bo.MaxInterval = 2 * time.Minute
 	bo.MaxElapsedTime = 60 * time.Minute
 	ctx, cancel := context.WithCancel(context.Background())
-	b.backoff = backoff.WithContext(bo, ctx)
+	retryBackoff := backoff.WithContext(bo, ctx)
+	b.backoff = retryBackoff
 	b.cancel = cancel
 
 	log.Println("Got PermissionDenied, creating backoff for token refresh...")
@@ -70,16 +91,18 @@ func (b *backoffHandler) OnError(err error) {
 	go func() {
 		// this blocks until the time limit expires or the request succeeds, at which point we'll lift the
 		// backoff and allow further retries:
-		backoff.Retry(b.retry, b.backoff)
+		backoff.Retry(b.retry, retryBackoff)
 		b.reset()
 	}()
 }
 
 func (b *backoffHandler) retry() error {
 	// make request
-	_, err := b.handler.handleRequest(cloud.NewTokenRequestWithJwt(&cloud.JwtRequest{ForceRefresh: true}))
+	_, err := b.handler.handleRequest(context.Background(), cloud.NewTokenRequestWithJwt(&cloud.JwtRequest{ForceRefresh: true}))
 	if status.Code(err) == codes.PermissionDenied {
+		b.mu.Lock()
 		b.denied = true
+		b.mu.Unlock()
 		log.Println("Token retry got PermissionDenied, stopping")
 		return &backoff.PermanentError{Err: err}
 	} else if err != nil {
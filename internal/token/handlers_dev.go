@@ -3,8 +3,10 @@
 package token
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/digital-dream-labs/vector-cloud/internal/clad/cloud"
 
@@ -16,9 +18,63 @@ var TokenServer *Server
 func init() {
 	devHandlers = func(s *http.ServeMux) {
 		s.HandleFunc("/tokenauth", provisionHandler)
+		s.HandleFunc("/tokenstatus", statusHandler)
+		s.HandleFunc("/tokenmetrics", metricsHandler)
 	}
 }
 
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	if TokenServer == nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprint(w, "Token server not running")
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	TokenServer.queue.metrics.writePrometheus(w, len(TokenServer.queue.queue))
+}
+
+type statusJSON struct {
+	UserID        string `json:"user_id"`
+	RequestorID   string `json:"requestor_id"`
+	IssuedAt      string `json:"issued_at,omitempty"`
+	RefreshAt     string `json:"refresh_at,omitempty"`
+	ExpiresAt     string `json:"expires_at,omitempty"`
+	StsExpiresAt  string `json:"sts_expires_at,omitempty"`
+	BackingOff    bool   `json:"backing_off"`
+	Denied        bool   `json:"denied"`
+	LastError     string `json:"last_error,omitempty"`
+	LastErrorTime string `json:"last_error_time,omitempty"`
+}
+
+func statusHandler(w http.ResponseWriter, r *http.Request) {
+	if TokenServer == nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprint(w, "Token server not running")
+		return
+	}
+	formatTime := func(t int64) string {
+		if t == 0 {
+			return ""
+		}
+		return time.Unix(t, 0).UTC().Format(time.RFC3339)
+	}
+
+	status := TokenServer.status()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(statusJSON{
+		UserID:        status.UserId,
+		RequestorID:   status.RequestorId,
+		IssuedAt:      formatTime(status.IssuedAt),
+		RefreshAt:     formatTime(status.RefreshAt),
+		ExpiresAt:     formatTime(status.ExpiresAt),
+		StsExpiresAt:  formatTime(status.StsExpiresAt),
+		BackingOff:    status.BackingOff,
+		Denied:        status.Denied,
+		LastError:     status.LastError,
+		LastErrorTime: formatTime(status.LastErrorTime),
+	})
+}
+
 func provisionHandler(w http.ResponseWriter, r *http.Request) {
 	// TODO: can we pass this in so we have only a single instance?
 	// Create identity provider pointing to default JWT path and device certs
@@ -57,7 +113,7 @@ func provisionHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	authRequest := cloud.NewTokenRequestWithAuth(&cloud.AuthRequest{SessionToken: tok})
-	if authResp, err := TokenServer.handleRequest(authRequest); err != nil {
+	if authResp, err := TokenServer.handleRequest(r.Context(), authRequest); err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		fmt.Fprint(w, "Error attempting authorization: ", err)
 		return
@@ -0,0 +1,167 @@
+package identity
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/digital-dream-labs/vector-cloud/internal/log"
+	"github.com/digital-dream-labs/vector-cloud/internal/robot"
+	"github.com/digital-dream-labs/vector-cloud/internal/util"
+)
+
+const (
+	// CertWarnBefore is how long before a certificate expires that DAS events start
+	// warning about it
+	CertWarnBefore = 30 * 24 * time.Hour
+	// CertFaceWarnBefore is how long before a certificate expires that a warning is
+	// shown on the robot's face
+	CertFaceWarnBefore = 7 * 24 * time.Hour
+
+	certCheckInterval = 12 * time.Hour
+	// faceErrorCert is the face error shown for an unusable certificate, the same as
+	// at startup
+	faceErrorCert = 850
+	// faceErrorCertExpiring is the face error shown as a warning for a certificate
+	// that's about to expire; the robot keeps running with it
+	faceErrorCertExpiring = 852
+)
+
+// CertReloader is implemented by providers whose client certificate can be replaced
+// while running
+type CertReloader interface {
+	KeySource() robot.KeySource
+	ReloadCertificate() error
+}
+
+// CertStatus describes one of the certificates a CertMonitor watches
+type CertStatus struct {
+	Name       string
+	CommonName string
+	NotAfter   time.Time
+	Expired    bool
+	// ExpiringSoon is set within CertWarnBefore of expiry
+	ExpiringSoon bool
+	// Err is set if the certificate couldn't be read
+	Err error
+}
+
+// CertMonitor watches the expiry of the robot's certificates, warning ahead of it,
+// and notices when they're renewed
+type CertMonitor struct {
+	mu    sync.Mutex
+	certs []*monitoredCert
+	now   func() time.Time
+	// faceError shows an error code on the robot's face
+	faceError func(code uint16) error
+}
+
+type monitoredCert struct {
+	name      string
+	src       robot.KeySource
+	onRenewed func() error
+	// leaf is the certificate last seen
+	leaf *x509.Certificate
+	// faceWarned and faceShown are set once the face error has been shown for this
+	// certificate since it was about to expire and since it expired; each is shown
+	// again after a restart if it still applies
+	faceWarned bool
+	faceShown  bool
+}
+
+// NewCertMonitor creates a CertMonitor watching no certificates
+func NewCertMonitor() *CertMonitor {
+	return &CertMonitor{now: time.Now, faceError: robot.WriteFaceErrorCode}
+}
+
+// Watch adds a certificate to check; onRenewed, if given, is called when it changes
+func (m *CertMonitor) Watch(name string, src robot.KeySource, onRenewed func() error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.certs = append(m.certs, &monitoredCert{name: name, src: src, onRenewed: onRenewed})
+}
+
+// Run checks the certificates now and periodically until ctx is done
+func (m *CertMonitor) Run(ctx context.Context) {
+	for {
+		m.Check()
+		if util.SleepSelect(certCheckInterval, ctx.Done()) {
+			return
+		}
+	}
+}
+
+// Check checks the certificates, warning of any that are about to expire and
+// reloading any that were renewed
+func (m *CertMonitor) Check() []CertStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ret := make([]CertStatus, 0, len(m.certs))
+	for _, cert := range m.certs {
+		ret = append(ret, m.check(cert))
+	}
+	return ret
+}
+
+func (m *CertMonitor) check(cert *monitoredCert) CertStatus {
+	status := CertStatus{Name: cert.name}
+	leaf, err := readLeaf(cert.src)
+	if err != nil {
+		log.Printf("Error reading %s certificate: %v\n", cert.name, err)
+		status.Err = err
+		return status
+	}
+	status.CommonName = leaf.Subject.CommonName
+	status.NotAfter = leaf.NotAfter
+
+	if cert.leaf != nil && !bytes.Equal(cert.leaf.Raw, leaf.Raw) {
+		log.Printf("%s certificate renewed, now valid until %s\n", cert.name, leaf.NotAfter.Format(time.RFC3339))
+		log.Das("cert.renewed", (&log.DasFields{}).SetStrings(cert.name, leaf.NotAfter.Format(time.RFC3339)))
+		cert.faceWarned, cert.faceShown = false, false
+		if cert.onRenewed != nil {
+			if err := cert.onRenewed(); err != nil {
+				log.Printf("Error reloading renewed %s certificate: %v\n", cert.name, err)
+			}
+		}
+	}
+	cert.leaf = leaf
+
+	left := leaf.NotAfter.Sub(m.now())
+	status.Expired = left <= 0
+	status.ExpiringSoon = left < CertWarnBefore
+	switch {
+	case status.Expired:
+		log.Printf("%s certificate expired at %s\n", cert.name, leaf.NotAfter.Format(time.RFC3339))
+		log.Das("cert.expired", (&log.DasFields{}).SetStrings(cert.name, leaf.NotAfter.Format(time.RFC3339)))
+		if !cert.faceShown {
+			m.showFaceError(faceErrorCert)
+			cert.faceShown = true
+		}
+	case status.ExpiringSoon:
+		days := fmt.Sprint(int(left.Hours() / 24))
+		log.Printf("%s certificate expires in %s days\n", cert.name, days)
+		log.Das("cert.expiring", (&log.DasFields{}).SetStrings(cert.name, leaf.NotAfter.Format(time.RFC3339), days))
+		if left < CertFaceWarnBefore && !cert.faceWarned {
+			m.showFaceError(faceErrorCertExpiring)
+			cert.faceWarned = true
+		}
+	}
+	return status
+}
+
+func (m *CertMonitor) showFaceError(code uint16) {
+	if err := m.faceError(code); err != nil {
+		log.Println("Couldn't print face error:", err)
+	}
+}
+
+func readLeaf(src robot.KeySource) (*x509.Certificate, error) {
+	chain, err := src.Certificate()
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(chain[0])
+}
@@ -0,0 +1,85 @@
+package identity
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/digital-dream-labs/vector-cloud/internal/robot"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func certTemplate(commonName string, notAfter time.Time) *x509.Certificate {
+	return &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    notAfter.Add(-365 * 24 * time.Hour),
+		NotAfter:     notAfter,
+	}
+}
+
+func TestCertMonitor(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	ks := robot.NewSoftKeystore()
+	require.NoError(t, ks.Generate("device", certTemplate("vic:00000000", now.Add(10*24*time.Hour))))
+	require.NoError(t, ks.Generate("gateway", certTemplate("gateway", now.Add(365*24*time.Hour))))
+
+	cert, err := newClientCert(ks.KeySource("device"))
+	require.NoError(t, err)
+	m := NewCertMonitor()
+	m.now = func() time.Time { return now }
+	var faceErrors []uint16
+	m.faceError = func(code uint16) error {
+		faceErrors = append(faceErrors, code)
+		return nil
+	}
+	m.Watch("device", ks.KeySource("device"), func() error { return cert.reload("vic:00000000") })
+	m.Watch("gateway", ks.KeySource("gateway"), nil)
+
+	status := m.Check()
+	require.Len(t, status, 2)
+	assert.Equal(t, "vic:00000000", status[0].CommonName)
+	assert.True(t, status[0].ExpiringSoon)
+	assert.False(t, status[0].Expired)
+	assert.False(t, status[1].ExpiringSoon)
+	// a certificate that expires in a while yet isn't shown on the face
+	assert.Empty(t, faceErrors)
+
+	// one that's about to is shown once, as a warning
+	m.now = func() time.Time { return now.Add(5 * 24 * time.Hour) }
+	status = m.Check()
+	assert.False(t, status[0].Expired)
+	m.Check()
+	assert.Equal(t, []uint16{faceErrorCertExpiring}, faceErrors)
+
+	// and once it's expired, as an error
+	m.now = func() time.Time { return now.Add(11 * 24 * time.Hour) }
+	status = m.Check()
+	assert.True(t, status[0].Expired)
+	m.Check()
+	assert.Equal(t, []uint16{faceErrorCertExpiring, faceErrorCert}, faceErrors)
+
+	// a renewed certificate is swapped in for new connections
+	require.NoError(t, ks.Generate("device", certTemplate("vic:00000000", now.Add(400*24*time.Hour))))
+	status = m.Check()
+	assert.False(t, status[0].ExpiringSoon)
+	current, err := cert.get(nil)
+	require.NoError(t, err)
+	assert.Equal(t, status[0].NotAfter, current.Leaf.NotAfter)
+
+	// but not one issued to a different robot
+	require.NoError(t, ks.Generate("device", certTemplate("vic:11111111", now.Add(400*24*time.Hour))))
+	m.Check()
+	current, err = cert.get(nil)
+	require.NoError(t, err)
+	assert.Equal(t, "vic:00000000", current.Leaf.Subject.CommonName)
+
+	// a certificate that can't be read is reported
+	ks.Delete("gateway")
+	status = m.Check()
+	assert.Error(t, status[1].Err)
+}
@@ -0,0 +1,48 @@
+package identity
+
+import (
+	"crypto/tls"
+	"fmt"
+	"sync"
+
+	"github.com/digital-dream-labs/vector-cloud/internal/robot"
+)
+
+// clientCert holds the certificate presented in TLS handshakes, so that a renewed one
+// can be swapped in for new connections without a restart
+type clientCert struct {
+	src  robot.KeySource
+	mu   sync.Mutex
+	cert *tls.Certificate
+}
+
+func newClientCert(src robot.KeySource) (*clientCert, error) {
+	c := &clientCert{src: src}
+	if err := c.reload(""); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// reload loads the certificate from the key source again; if commonName is given,
+// the certificate must still be issued to it
+func (c *clientCert) reload(commonName string) error {
+	cert, err := robot.TLSCertificate(c.src)
+	if err != nil {
+		return err
+	}
+	if commonName != "" && cert.Leaf.Subject.CommonName != commonName {
+		return fmt.Errorf("certificate is issued to %s, not %s", cert.Leaf.Subject.CommonName, commonName)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cert = &cert
+	return nil
+}
+
+// get returns the current certificate, as tls.Config.GetClientCertificate
+func (c *clientCert) get(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.cert, nil
+}
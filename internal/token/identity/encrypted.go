@@ -0,0 +1,183 @@
+package identity
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+
+	"github.com/digital-dream-labs/vector-cloud/internal/log"
+	"github.com/digital-dream-labs/vector-cloud/internal/robot"
+)
+
+const encryptedJwtFile = "token.jwt.enc"
+
+// keyContext separates the token storage key from any other use of the device key
+var keyContext = []byte("vic-cloud token storage v1")
+
+var errorCiphertext = errors.New("encrypted token is too short")
+
+// encryptedFileProvider stores the JWT encrypted with a key derived from the robot's
+// device private key, so it can't be lifted from the filesystem without the key
+type encryptedFileProvider struct {
+	*fileProvider
+	aead cipher.AEAD
+}
+
+// NewEncryptedFileProvider creates a new file backed Provider interface implementation
+// that encrypts the token at rest. A plaintext token left by the file provider is
+// migrated to encrypted storage on Init.
+func NewEncryptedFileProvider(jwtPath, cloudDir string, providerOptions ...Option) (*encryptedFileProvider, error) {
+	base, err := NewFileProvider(jwtPath, cloudDir, providerOptions...)
+	if err != nil {
+		return nil, err
+	}
+	key, err := base.keySource.Signer()
+	if err != nil {
+		return nil, err
+	}
+	return newEncryptedFileProvider(base, key)
+}
+
+func newEncryptedFileProvider(base *fileProvider, key crypto.PrivateKey) (*encryptedFileProvider, error) {
+	// the token key is derived from the device key, so this only works with keys
+	// that can be exported, unlike ones kept in a keystore
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("device key can't be used to encrypt tokens: %v", err)
+	}
+	mac := hmac.New(sha256.New, der)
+	mac.Write(keyContext)
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptedFileProvider{fileProvider: base, aead: aead}, nil
+}
+
+// ParseAndStoreToken parses the given token received from the server and saves it,
+// encrypted, to our persistent store
+func (c *encryptedFileProvider) ParseAndStoreToken(token string) (Token, error) {
+	tok, err := c.parseToken(token)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.saveToken(token); err != nil {
+		return nil, err
+	}
+	c.setToken(tok)
+	logUserID(tok)
+	return tokWrapper{tok}, nil
+}
+
+// Init loads and decrypts the token from disk, migrating a plaintext token if one
+// is found instead
+func (c *encryptedFileProvider) Init() error {
+	err := c.init()
+	if err != nil {
+		if err := robot.WriteFaceErrorCode(851); err != nil {
+			log.Println("Couldn't print face error:", err)
+		}
+	}
+	return err
+}
+
+func (c *encryptedFileProvider) init() error {
+	if err := c.makeDir(); err != nil {
+		return err
+	}
+	buf, err := ioutil.ReadFile(c.encryptedTokenFile())
+	if err == nil {
+		token, err := c.decrypt(buf)
+		if err != nil {
+			// most likely encrypted with a different device key; a new token will
+			// need to be requested, which isn't an error starting up
+			log.Println("Couldn't decrypt stored token, discarding:", err)
+			os.Remove(c.encryptedTokenFile())
+			return nil
+		}
+		return c.loadToken(string(token), c.encryptedTokenFile())
+	}
+
+	// no encrypted token - migrate a plaintext one if it exists
+	buf, err = ioutil.ReadFile(c.tokenFile())
+	if err != nil {
+		return nil
+	}
+	if err := c.loadToken(string(buf), c.tokenFile()); err != nil || c.GetToken() == nil {
+		return err
+	}
+	if err := c.saveToken(string(buf)); err != nil {
+		// keep the plaintext token so we aren't logged out; migration will be
+		// retried next time
+		log.Println("Error migrating token to encrypted storage:", err)
+		return nil
+	}
+	if err := os.Remove(c.tokenFile()); err != nil {
+		log.Println("Error removing plaintext token after migration:", err)
+	}
+	log.Println("Migrated token to encrypted storage")
+	return nil
+}
+
+// RemoveToken forgets the current token and deletes it from disk, along with any
+// plaintext token not yet migrated
+func (c *encryptedFileProvider) RemoveToken() error {
+	if err := c.fileProvider.RemoveToken(); err != nil {
+		return err
+	}
+	if err := os.Remove(c.encryptedTokenFile()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (c *encryptedFileProvider) encryptedTokenFile() string {
+	return path.Join(c.jwtPath, encryptedJwtFile)
+}
+
+func (c *encryptedFileProvider) saveToken(token string) error {
+	if err := os.Mkdir(c.jwtPath, 0700); err != nil && !os.IsExist(err) {
+		return err
+	}
+	buf, err := c.encrypt([]byte(token))
+	if err != nil {
+		return err
+	}
+	fileName := c.encryptedTokenFile()
+	tmpFileName := fileName + ".tmp"
+	if err := ioutil.WriteFile(tmpFileName, buf, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpFileName, fileName)
+}
+
+// encrypt returns the nonce followed by the sealed token
+func (c *encryptedFileProvider) encrypt(plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return c.aead.Seal(nonce, nonce, plaintext, []byte(encryptedJwtFile)), nil
+}
+
+func (c *encryptedFileProvider) decrypt(buf []byte) ([]byte, error) {
+	n := c.aead.NonceSize()
+	if len(buf) < n {
+		return nil, errorCiphertext
+	}
+	return c.aead.Open(nil, buf[:n], buf[n:], []byte(encryptedJwtFile))
+}
@@ -0,0 +1,107 @@
+package identity
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/digital-dream-labs/vector-cloud/internal/robot"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testToken(t *testing.T, userID string) string {
+	now := time.Now().UTC()
+	tok := TokenInfo{
+		Id:          "token",
+		Type:        "user+robot",
+		UserId:      userID,
+		RequestorId: "vic:00000000",
+		IssuedAt:    now,
+		ExpiresAt:   now.Add(24 * time.Hour),
+	}
+	str, err := tok.JwtToken(jwt.SigningMethodHS256).SignedString([]byte("secret"))
+	require.NoError(t, err)
+	return str
+}
+
+func newTestEncryptedProvider(t *testing.T, dir string, key *ecdsa.PrivateKey) *encryptedFileProvider {
+	p, err := newEncryptedFileProvider(&fileProvider{jwtPath: dir}, key)
+	require.NoError(t, err)
+	return p
+}
+
+func TestEncryptedProvider(t *testing.T) {
+	dir, err := ioutil.TempDir("", "encrypted_token")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	// a plaintext token from the file provider is migrated on init
+	plain := testToken(t, "user1")
+	require.NoError(t, ioutil.WriteFile(path.Join(dir, jwtFile), []byte(plain), 0600))
+	p := newTestEncryptedProvider(t, dir, key)
+	require.NoError(t, p.Init())
+	require.NotNil(t, p.GetToken())
+	assert.Equal(t, "user1", p.GetToken().UserID())
+	_, err = os.Stat(path.Join(dir, jwtFile))
+	assert.True(t, os.IsNotExist(err))
+
+	// the stored token isn't readable without the key
+	buf, err := ioutil.ReadFile(path.Join(dir, encryptedJwtFile))
+	require.NoError(t, err)
+	assert.False(t, bytes.Contains(buf, []byte(plain)))
+	assert.False(t, bytes.Contains(buf, []byte(plain[len(plain)/2:])))
+
+	// new tokens are stored encrypted and survive a restart
+	_, err = p.ParseAndStoreToken(testToken(t, "user2"))
+	require.NoError(t, err)
+	p = newTestEncryptedProvider(t, dir, key)
+	require.NoError(t, p.Init())
+	require.NotNil(t, p.GetToken())
+	assert.Equal(t, "user2", p.GetToken().UserID())
+
+	// a different device key can't decrypt it, and it's discarded without failing
+	// startup
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	p = newTestEncryptedProvider(t, dir, otherKey)
+	assert.NoError(t, p.init())
+	assert.Nil(t, p.GetToken())
+	_, err = os.Stat(path.Join(dir, encryptedJwtFile))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestKeystoreProvider(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keystore_token")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	ks := robot.NewSoftKeystore()
+	require.NoError(t, ks.Generate("device", &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "vic:00000000"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}))
+
+	// the certificate comes from the keystore rather than the cloud directory
+	p, err := NewFileProvider(dir, path.Join(dir, "nonexistent"), WithKeySource(ks.KeySource("device")))
+	require.NoError(t, err)
+	assert.Equal(t, "vic:00000000", p.CertCommonName())
+
+	// a key that never leaves the keystore can't be used to encrypt tokens
+	_, err = NewEncryptedFileProvider(dir, path.Join(dir, "nonexistent"), WithKeySource(ks.KeySource("device")))
+	assert.Error(t, err)
+}
@@ -17,17 +17,17 @@ const DefaultTokenPath = "/tmp/victoken"
 // UseClientCert can be set to true to force the use of client certs
 var UseClientCert = false
 
-func getTLSCert(cloudDir string) (credentials.TransportCredentials, error) {
+func getTLSCert(keySource robot.KeySource) (credentials.TransportCredentials, *clientCert, error) {
 	if !UseClientCert {
-		return credentials.NewClientTLSFromCert(rootcerts.ServerCertPool(), ""), nil
+		return credentials.NewClientTLSFromCert(rootcerts.ServerCertPool(), ""), nil, nil
 	}
 
-	cert, err := robot.TLSKeyPair(cloudDir)
+	cert, err := newClientCert(keySource)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	return credentials.NewTLS(&tls.Config{
-		Certificates: []tls.Certificate{cert},
-		RootCAs:      rootcerts.ServerCertPool(),
-	}), nil
+		GetClientCertificate: cert.get,
+		RootCAs:              rootcerts.ServerCertPool(),
+	}), cert, nil
 }
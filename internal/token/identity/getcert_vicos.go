@@ -13,13 +13,13 @@ import (
 
 const DefaultTokenPath = "/data/data/com.anki.victor/persistent/token"
 
-func getTLSCert(cloudDir string) (credentials.TransportCredentials, error) {
-	cert, err := robot.TLSKeyPair(cloudDir)
+func getTLSCert(keySource robot.KeySource) (credentials.TransportCredentials, *clientCert, error) {
+	cert, err := newClientCert(keySource)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	return credentials.NewTLS(&tls.Config{
-		Certificates: []tls.Certificate{cert},
-		RootCAs:      rootcerts.ServerCertPool(),
-	}), nil
+		GetClientCertificate: cert.get,
+		RootCAs:              rootcerts.ServerCertPool(),
+	}), cert, nil
 }
@@ -5,6 +5,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path"
+	"sync"
 	"time"
 
 	"github.com/digital-dream-labs/vector-cloud/internal/log"
@@ -21,8 +22,10 @@ const jwtFile = "token.jwt"
 type Token interface {
 	IssuedAt() time.Time
 	RefreshTime() time.Time
+	ExpiresAt() time.Time
 	String() string
 	UserID() string
+	RequestorID() string
 }
 
 // Provider is an interface to manage JWT tokens and TLS certs for a single robot
@@ -30,19 +33,33 @@ type Provider interface {
 	Init() error
 	ParseAndStoreToken(token string) (Token, error)
 	GetToken() Token
+	// RemoveToken deletes the stored token, e.g. once the server has revoked it
+	RemoveToken() error
 	CertCommonName() string
 	TransportCredentials() credentials.TransportCredentials
 }
 
 type fileProvider struct {
-	credentials    credentials.TransportCredentials
-	jwtPath        string
+	credentials credentials.TransportCredentials
+	jwtPath     string
+	// tokenMu guards currentToken, which is read by other routines while a new
+	// token is stored
+	tokenMu        sync.Mutex
 	currentToken   *TokenInfo
 	certCommonName string
+	keySource      robot.KeySource
+	// clientCert is the certificate presented to the server, if any
+	clientCert *clientCert
+	verifier   *Verifier
 }
 
 // NewFileProvider creates a new file backed Provider interface implementation
-func NewFileProvider(jwtPath, cloudDir string) (*fileProvider, error) {
+func NewFileProvider(jwtPath, cloudDir string, providerOptions ...Option) (*fileProvider, error) {
+	var opts options
+	for _, o := range providerOptions {
+		o(&opts)
+	}
+
 	if jwtPath == "" {
 		jwtPath = DefaultTokenPath
 	}
@@ -50,12 +67,20 @@ func NewFileProvider(jwtPath, cloudDir string) (*fileProvider, error) {
 		cloudDir = robot.DefaultCloudDir
 	}
 
-	certCommonName, err := robot.CertCommonName(cloudDir)
+	keySource := opts.keySource
+	var certCommonName string
+	var err error
+	if keySource != nil {
+		certCommonName, err = robot.KeySourceCommonName(keySource)
+	} else {
+		keySource = robot.DeviceKeySource(cloudDir)
+		certCommonName, err = robot.CertCommonName(cloudDir)
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	credentials, err := getTLSCert(cloudDir)
+	credentials, clientCert, err := getTLSCert(keySource)
 	if err != nil {
 		return nil, err
 	}
@@ -64,6 +89,9 @@ func NewFileProvider(jwtPath, cloudDir string) (*fileProvider, error) {
 		credentials:    credentials,
 		jwtPath:        jwtPath,
 		certCommonName: certCommonName,
+		keySource:      keySource,
+		clientCert:     clientCert,
+		verifier:       opts.verifier,
 	}, nil
 }
 
@@ -75,6 +103,21 @@ func (c *fileProvider) TransportCredentials() credentials.TransportCredentials {
 	return c.credentials
 }
 
+// KeySource returns where the robot's certificate and key come from
+func (c *fileProvider) KeySource() robot.KeySource {
+	return c.keySource
+}
+
+// ReloadCertificate loads the client certificate from the key source again, so that
+// a renewed one is used for new connections without a restart
+func (c *fileProvider) ReloadCertificate() error {
+	if c.clientCert == nil {
+		// not presenting one
+		return nil
+	}
+	return c.clientCert.reload(c.certCommonName)
+}
+
 // ParseAndStoreToken parses the given token received from the server and saves it
 // to our persistent store
 func (c *fileProvider) ParseAndStoreToken(token string) (Token, error) {
@@ -86,7 +129,7 @@ func (c *fileProvider) ParseAndStoreToken(token string) (Token, error) {
 	if err := c.saveToken(token); err != nil {
 		return nil, err
 	}
-	c.currentToken = tok
+	c.setToken(tok)
 	logUserID(tok)
 	return tokWrapper{tok}, nil
 }
@@ -107,14 +150,43 @@ func (c *fileProvider) Init() error {
 // checking ShouldRefresh() on the token to see if a new one should be requested
 // anyway.
 func (c *fileProvider) GetToken() Token {
+	c.tokenMu.Lock()
+	defer c.tokenMu.Unlock()
 	if c.currentToken == nil {
 		return nil
 	}
 	return tokWrapper{c.currentToken}
 }
 
+// RemoveToken forgets the current token and deletes it from disk
+func (c *fileProvider) RemoveToken() error {
+	c.setToken(nil)
+	if err := os.Remove(c.tokenFile()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (c *fileProvider) setToken(tok *TokenInfo) {
+	c.tokenMu.Lock()
+	defer c.tokenMu.Unlock()
+	c.currentToken = tok
+}
+
 func (c *fileProvider) init() error {
-	// try to create dir token will live in
+	if err := c.makeDir(); err != nil {
+		return err
+	}
+	// see if a token already lives on disk
+	buf, err := ioutil.ReadFile(c.tokenFile())
+	if err == nil {
+		return c.loadToken(string(buf), c.tokenFile())
+	}
+	return nil
+}
+
+// makeDir tries to create the dir the token will live in
+func (c *fileProvider) makeDir() error {
 	if err := os.Mkdir(c.jwtPath, 0777); err != nil {
 		// if this failed, make sure it's because it already exists
 		s, err := os.Stat(c.jwtPath)
@@ -127,26 +199,36 @@ func (c *fileProvider) init() error {
 			return err
 		}
 	}
-	// see if a token already lives on disk
-	buf, err := ioutil.ReadFile(c.tokenFile())
-	if err == nil {
-		tok, err := c.parseToken(string(buf))
-		if err != nil {
-			os.Remove(c.tokenFile())
-			return err
-		}
+	return nil
+}
 
-		// TODO DELETE AFTER SEPTEMBER 7TH-ISH
-		// delete fake, no-userid token TMS used to generate for testing
-		if tok.UserId == "" {
-			log.Println("Deleting old test token")
-			os.Remove(c.tokenFile())
+// loadToken parses a token read from the given file and makes it the current token,
+// removing the file if the token is invalid. Only its signature is checked; one
+// that looks expired may just mean the clock isn't set yet, and is left to be
+// refreshed.
+func (c *fileProvider) loadToken(token, fileName string) error {
+	tok, err := c.parseStoredToken(token)
+	if err != nil {
+		os.Remove(fileName)
+		if _, ok := err.(*VerificationError); ok {
+			// not a token we can trust, but not a failure to start either; a new
+			// one will be requested
+			log.Println("Discarding stored token:", err)
 			return nil
 		}
+		return err
+	}
 
-		c.currentToken = tok
-		logUserID(tok)
+	// TODO DELETE AFTER SEPTEMBER 7TH-ISH
+	// delete fake, no-userid token TMS used to generate for testing
+	if tok.UserId == "" {
+		log.Println("Deleting old test token")
+		os.Remove(fileName)
+		return nil
 	}
+
+	c.setToken(tok)
+	logUserID(tok)
 	return nil
 }
 
@@ -155,6 +237,22 @@ func (c *fileProvider) tokenFile() string {
 }
 
 func (c *fileProvider) parseToken(token string) (*TokenInfo, error) {
+	if c.verifier != nil {
+		return c.verifier.Verify(token)
+	}
+	return ParseUnverified(token)
+}
+
+func (c *fileProvider) parseStoredToken(token string) (*TokenInfo, error) {
+	if c.verifier != nil {
+		return c.verifier.VerifySignature(token)
+	}
+	return ParseUnverified(token)
+}
+
+// ParseUnverified reads the claims of the given token without checking its
+// signature; they can't be trusted until a Provider has stored the token
+func ParseUnverified(token string) (*TokenInfo, error) {
 	t, _, err := new(jwt.Parser).ParseUnverified(token, jwt.MapClaims{})
 	if err != nil {
 		return nil, err
@@ -207,6 +305,14 @@ func (t tokWrapper) UserID() string {
 	return t.tok.UserId
 }
 
+func (t tokWrapper) ExpiresAt() time.Time {
+	return t.tok.ExpiresAt
+}
+
+func (t tokWrapper) RequestorID() string {
+	return t.tok.RequestorId
+}
+
 var platformTokenPath string
 var testTokenPath string
 
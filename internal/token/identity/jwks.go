@@ -0,0 +1,293 @@
+package identity
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/digital-dream-labs/vector-cloud/internal/config"
+	"github.com/digital-dream-labs/vector-cloud/internal/log"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// DefaultClockSkew is how far the robot's clock may be off from the token server's
+// before a token is considered not yet issued or expired
+const DefaultClockSkew = 5 * time.Minute
+
+// minReloadInterval limits how often an unknown key ID can trigger a reload of the
+// JWKS file
+const minReloadInterval = time.Minute
+
+// VerificationError is returned when a token's signature or validity period can't
+// be verified against the configured key set
+type VerificationError struct {
+	Err error
+}
+
+func (e *VerificationError) Error() string {
+	return "token verification failed: " + e.Err.Error()
+}
+
+func (e *VerificationError) Unwrap() error {
+	return e.Err
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// EC
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+type jwkSet struct {
+	Keys []jwk `json:"keys"`
+}
+
+// Verifier checks the signatures of tokens against a JSON Web Key Set. Keys are
+// matched by the kid header of the token; if a token names a key that isn't known
+// and the set was loaded from a file, the file is read again, so a rotated key set
+// can be picked up without restarting.
+type Verifier struct {
+	mu         sync.Mutex
+	keys       map[string]crypto.PublicKey
+	filename   string
+	lastReload time.Time
+	skew       time.Duration
+}
+
+// NewVerifier creates a Verifier for the given JWKS document
+func NewVerifier(jwks []byte) (*Verifier, error) {
+	keys, err := parseJWKS(jwks)
+	if err != nil {
+		return nil, err
+	}
+	return &Verifier{keys: keys, skew: DefaultClockSkew}, nil
+}
+
+// LoadVerifier creates a Verifier for the JWKS document in the given file
+func LoadVerifier(filename string) (*Verifier, error) {
+	buf, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	v, err := NewVerifier(buf)
+	if err != nil {
+		return nil, err
+	}
+	v.filename = filename
+	v.lastReload = time.Now()
+	return v, nil
+}
+
+// emptyVerifier returns a Verifier without keys, which rejects every token until a
+// key set can be read from the given file, if any
+func emptyVerifier(filename string) *Verifier {
+	return &Verifier{keys: make(map[string]crypto.PublicKey), filename: filename, skew: DefaultClockSkew}
+}
+
+// VerifierFromConfig returns a Verifier for the key set given in the server config,
+// or nil if it doesn't specify one
+func VerifierFromConfig(urls *config.URLs) (*Verifier, error) {
+	if len(urls.JWKS) > 0 {
+		return NewVerifier(urls.JWKS)
+	}
+	if urls.JWKSFile != "" {
+		return LoadVerifier(urls.JWKSFile)
+	}
+	return nil, nil
+}
+
+// SetClockSkew sets how far token issue and expiry times may be off from the
+// local clock
+func (v *Verifier) SetClockSkew(skew time.Duration) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.skew = skew
+}
+
+// Update replaces the key set with the given JWKS document
+func (v *Verifier) Update(jwks []byte) error {
+	keys, err := parseJWKS(jwks)
+	if err != nil {
+		return err
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.keys = keys
+	return nil
+}
+
+// Verify parses the given token, checking its signature and validity period
+func (v *Verifier) Verify(token string) (*TokenInfo, error) {
+	tok, err := v.VerifySignature(token)
+	if err != nil {
+		return nil, err
+	}
+
+	v.mu.Lock()
+	skew := v.skew
+	v.mu.Unlock()
+	now := time.Now().UTC()
+	if tok.IssuedAt.After(now.Add(skew)) {
+		return nil, &VerificationError{fmt.Errorf("token issued in the future (%v)", tok.IssuedAt)}
+	}
+	if now.Add(-skew).After(tok.ExpiresAt) {
+		return nil, &VerificationError{fmt.Errorf("token expired (%v)", tok.ExpiresAt)}
+	}
+	return tok, nil
+}
+
+// VerifySignature parses the given token, checking only that it's signed by a known
+// key. Tokens read back from storage are checked this way, since the clock may not
+// have been set yet at boot.
+func (v *Verifier) VerifySignature(token string) (*TokenInfo, error) {
+	t, err := new(jwt.Parser).Parse(token, v.keyFunc)
+	if err != nil {
+		if verr, ok := err.(*jwt.ValidationError); ok && verr.Inner != nil {
+			err = verr.Inner
+		}
+		return nil, &VerificationError{err}
+	}
+	return FromJwtToken(t)
+}
+
+func (v *Verifier) keyFunc(t *jwt.Token) (interface{}, error) {
+	kid, _ := t.Header["kid"].(string)
+	key, err := v.key(kid)
+	if err != nil {
+		return nil, err
+	}
+	switch key.(type) {
+	case *rsa.PublicKey:
+		if _, ok := t.Method.(*jwt.SigningMethodRSA); !ok {
+			return nil, fmt.Errorf("unexpected signing method %s for RSA key", t.Method.Alg())
+		}
+	case *ecdsa.PublicKey:
+		if _, ok := t.Method.(*jwt.SigningMethodECDSA); !ok {
+			return nil, fmt.Errorf("unexpected signing method %s for EC key", t.Method.Alg())
+		}
+	}
+	return key, nil
+}
+
+// key returns the public key with the given ID, reloading the key set from its file
+// if the ID is unknown
+func (v *Verifier) key(kid string) (crypto.PublicKey, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if key, ok := v.lookup(kid); ok {
+		return key, nil
+	}
+	if v.filename != "" && time.Since(v.lastReload) >= minReloadInterval {
+		v.lastReload = time.Now()
+		if buf, err := ioutil.ReadFile(v.filename); err != nil {
+			log.Println("Error reloading JWKS:", err)
+		} else if keys, err := parseJWKS(buf); err != nil {
+			log.Println("Error parsing reloaded JWKS:", err)
+		} else {
+			v.keys = keys
+		}
+		if key, ok := v.lookup(kid); ok {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// lookup returns the key with the given ID; a token without an ID can only be
+// verified if the set has exactly one key
+func (v *Verifier) lookup(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(v.keys) == 1 {
+		for _, key := range v.keys {
+			return key, true
+		}
+	}
+	key, ok := v.keys[kid]
+	return key, ok
+}
+
+func parseJWKS(buf []byte) (map[string]crypto.PublicKey, error) {
+	var set jwkSet
+	if err := json.Unmarshal(buf, &set); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]crypto.PublicKey)
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %s", k.Kid, err)
+		}
+		keys[k.Kid] = key
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no signing keys in JWKS")
+	}
+	return keys, nil
+}
+
+func (k *jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("point is not on curve %s", k.Crv)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %s", k.Kty)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	buf, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(buf), nil
+}
@@ -0,0 +1,204 @@
+package identity
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/digital-dream-labs/vector-cloud/internal/config"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func b64(i *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(i.Bytes())
+}
+
+func jwksFor(t *testing.T, keys map[string]interface{}) []byte {
+	var set jwkSet
+	for kid, key := range keys {
+		switch k := key.(type) {
+		case *rsa.PublicKey:
+			set.Keys = append(set.Keys, jwk{Kty: "RSA", Kid: kid, N: b64(k.N), E: b64(big.NewInt(int64(k.E)))})
+		case *ecdsa.PublicKey:
+			set.Keys = append(set.Keys, jwk{Kty: "EC", Kid: kid, Crv: "P-256", X: b64(k.X), Y: b64(k.Y)})
+		}
+	}
+	buf, err := json.Marshal(set)
+	require.NoError(t, err)
+	return buf
+}
+
+func signToken(t *testing.T, method jwt.SigningMethod, kid string, key interface{}, issued time.Time) string {
+	tok := TokenInfo{
+		Id:          "token",
+		Type:        "user+robot",
+		UserId:      "user",
+		RequestorId: "vic:00000000",
+		IssuedAt:    issued.UTC(),
+		ExpiresAt:   issued.UTC().Add(24 * time.Hour),
+	}
+	jt := tok.JwtToken(method)
+	jt.Header["kid"] = kid
+	str, err := jt.SignedString(key)
+	require.NoError(t, err)
+	return str
+}
+
+func TestVerifier(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	v, err := NewVerifier(jwksFor(t, map[string]interface{}{"rsa": &rsaKey.PublicKey, "ec": &ecKey.PublicKey}))
+	require.NoError(t, err)
+
+	now := time.Now()
+	tok, err := v.Verify(signToken(t, jwt.SigningMethodRS256, "rsa", rsaKey, now))
+	assert.NoError(t, err)
+	assert.Equal(t, "user", tok.UserId)
+	_, err = v.Verify(signToken(t, jwt.SigningMethodES256, "ec", ecKey, now))
+	assert.NoError(t, err)
+
+	isVerificationError := func(err error) bool {
+		_, ok := err.(*VerificationError)
+		return ok
+	}
+
+	// wrong key, unknown key, unsigned, and HMAC with the public key as secret
+	_, err = v.Verify(signToken(t, jwt.SigningMethodES256, "rsa", ecKey, now))
+	assert.True(t, isVerificationError(err))
+	_, err = v.Verify(signToken(t, jwt.SigningMethodRS256, "other", rsaKey, now))
+	assert.True(t, isVerificationError(err))
+	_, err = v.Verify(signToken(t, jwt.SigningMethodNone, "rsa", jwt.UnsafeAllowNoneSignatureType, now))
+	assert.True(t, isVerificationError(err))
+	_, err = v.Verify(signToken(t, jwt.SigningMethodHS256, "ec", []byte("secret"), now))
+	assert.True(t, isVerificationError(err))
+
+	// clock skew is tolerated, up to a point
+	_, err = v.Verify(signToken(t, jwt.SigningMethodRS256, "rsa", rsaKey, now.Add(time.Minute)))
+	assert.NoError(t, err)
+	_, err = v.Verify(signToken(t, jwt.SigningMethodRS256, "rsa", rsaKey, now.Add(time.Hour)))
+	assert.True(t, isVerificationError(err))
+	_, err = v.Verify(signToken(t, jwt.SigningMethodRS256, "rsa", rsaKey, now.Add(-24*time.Hour-time.Minute)))
+	assert.NoError(t, err)
+	_, err = v.Verify(signToken(t, jwt.SigningMethodRS256, "rsa", rsaKey, now.Add(-25*time.Hour)))
+	assert.True(t, isVerificationError(err))
+}
+
+func TestVerifierRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jwks")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	oldKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	newKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	filename := path.Join(dir, "jwks.json")
+	require.NoError(t, ioutil.WriteFile(filename, jwksFor(t, map[string]interface{}{"1": &oldKey.PublicKey}), 0644))
+	v, err := LoadVerifier(filename)
+	require.NoError(t, err)
+
+	token := signToken(t, jwt.SigningMethodES256, "2", newKey, time.Now())
+	_, err = v.Verify(token)
+	assert.Error(t, err)
+
+	// the server rotates to a new key; the file is reread when a token names it
+	require.NoError(t, ioutil.WriteFile(filename, jwksFor(t, map[string]interface{}{
+		"1": &oldKey.PublicKey, "2": &newKey.PublicKey}), 0644))
+	v.lastReload = time.Now().Add(-minReloadInterval)
+	_, err = v.Verify(token)
+	assert.NoError(t, err)
+
+	// a provider rejects tokens that don't verify
+	p := &fileProvider{jwtPath: dir, verifier: v}
+	_, err = p.ParseAndStoreToken(signToken(t, jwt.SigningMethodES256, "3", newKey, time.Now()))
+	assert.Error(t, err)
+	assert.Nil(t, p.GetToken())
+	_, err = p.ParseAndStoreToken(token)
+	assert.NoError(t, err)
+	assert.NotNil(t, p.GetToken())
+}
+
+func TestConfigOptions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jwks")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	prev := config.Env
+	defer func() { config.Env = prev }()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	token := signToken(t, jwt.SigningMethodES256, "1", key, time.Now())
+	configured := func() *Verifier {
+		var o options
+		for _, opt := range ConfigOptions() {
+			opt(&o)
+		}
+		return o.verifier
+	}
+
+	// without a key set, tokens aren't verified
+	config.Env = config.URLs{}
+	assert.Nil(t, configured())
+
+	// one that's configured but broken accepts nothing
+	config.Env = config.URLs{JWKS: []byte(`{"keys":[]}`)}
+	v := configured()
+	require.NotNil(t, v)
+	_, err = v.Verify(token)
+	assert.Error(t, err)
+
+	// until a configured file can be read
+	filename := path.Join(dir, "jwks.json")
+	config.Env = config.URLs{JWKSFile: filename}
+	v = configured()
+	require.NotNil(t, v)
+	_, err = v.Verify(token)
+	assert.Error(t, err)
+	require.NoError(t, ioutil.WriteFile(filename, jwksFor(t, map[string]interface{}{"1": &key.PublicKey}), 0644))
+	v.lastReload = time.Now().Add(-minReloadInterval)
+	_, err = v.Verify(token)
+	assert.NoError(t, err)
+}
+
+func TestLoadStoredToken(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jwks")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	v, err := NewVerifier(jwksFor(t, map[string]interface{}{"1": &key.PublicKey}))
+	require.NoError(t, err)
+	p := &fileProvider{jwtPath: dir, verifier: v}
+
+	// a stored token that looks expired is kept, since the clock may be what's wrong
+	expired := signToken(t, jwt.SigningMethodES256, "1", key, time.Now().Add(-48*time.Hour))
+	require.NoError(t, p.saveToken(expired))
+	require.NoError(t, p.init())
+	require.NotNil(t, p.GetToken())
+	assert.Equal(t, expired, p.GetToken().String())
+	assert.FileExists(t, p.tokenFile())
+
+	// one that isn't signed by a known key is discarded
+	p.setToken(nil)
+	require.NoError(t, p.saveToken(signToken(t, jwt.SigningMethodES256, "2", key, time.Now())))
+	require.NoError(t, p.init())
+	assert.Nil(t, p.GetToken())
+	assert.NoFileExists(t, p.tokenFile())
+}
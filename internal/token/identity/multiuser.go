@@ -0,0 +1,259 @@
+package identity
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/digital-dream-labs/vector-cloud/internal/log"
+)
+
+const (
+	usersDir       = "users"
+	activeUserFile = "active_user"
+	userFileSuffix = ".jwt"
+)
+
+// MultiUserProvider is a Provider that can hold tokens for several users of the same
+// robot. GetToken returns the token of the active user; ParseAndStoreToken stores a
+// token in the slot of the user it was issued to, making that user active if no user
+// is active yet.
+type MultiUserProvider interface {
+	Provider
+	// GetUserToken returns the token stored for the given user, or nil if there is none
+	GetUserToken(userID string) Token
+	// UserIDs returns the IDs of all users with a stored token
+	UserIDs() []string
+	// ActiveUser returns the ID of the active user, or "" if there is none
+	ActiveUser() string
+	// SetActiveUser selects the user whose token GetToken will return
+	SetActiveUser(userID string) error
+	// RemoveUser deletes the stored token for the given user
+	RemoveUser(userID string) error
+}
+
+type multiUserProvider struct {
+	*fileProvider
+	mu     sync.Mutex
+	tokens map[string]*TokenInfo
+	active string
+}
+
+// NewMultiUserFileProvider creates a new file backed MultiUserProvider. A token left
+// by the single user file provider is imported as the active user's on Init.
+func NewMultiUserFileProvider(jwtPath, cloudDir string, providerOptions ...Option) (*multiUserProvider, error) {
+	base, err := NewFileProvider(jwtPath, cloudDir, providerOptions...)
+	if err != nil {
+		return nil, err
+	}
+	return newMultiUserProvider(base), nil
+}
+
+func newMultiUserProvider(base *fileProvider) *multiUserProvider {
+	return &multiUserProvider{fileProvider: base, tokens: make(map[string]*TokenInfo)}
+}
+
+// Init loads every user's token from disk
+func (c *multiUserProvider) Init() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.makeDir(); err != nil {
+		return err
+	}
+	if err := os.Mkdir(c.usersPath(), 0700); err != nil && !os.IsExist(err) {
+		return err
+	}
+
+	files, err := ioutil.ReadDir(c.usersPath())
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if !strings.HasSuffix(f.Name(), userFileSuffix) {
+			continue
+		}
+		fileName := path.Join(c.usersPath(), f.Name())
+		buf, err := ioutil.ReadFile(fileName)
+		if err != nil {
+			log.Println("Error reading user token:", err)
+			continue
+		}
+		tok, err := c.parseStoredToken(string(buf))
+		if err != nil || tok.UserId == "" {
+			log.Println("Discarding invalid user token", f.Name()+":", err)
+			os.Remove(fileName)
+			continue
+		}
+		c.tokens[tok.UserId] = tok
+	}
+
+	if buf, err := ioutil.ReadFile(c.activeFile()); err == nil {
+		if user := string(buf); c.tokens[user] != nil {
+			c.active = user
+		}
+	}
+
+	// import the single user provider's token
+	if buf, err := ioutil.ReadFile(c.tokenFile()); err == nil {
+		if tok, err := c.parseStoredToken(string(buf)); err == nil && tok.UserId != "" {
+			if c.tokens[tok.UserId] == nil {
+				if err := c.storeLocked(tok, string(buf)); err != nil {
+					return err
+				}
+			}
+			if c.active == "" {
+				c.setActiveLocked(tok.UserId)
+			}
+			log.Println("Imported existing token into multi-user storage")
+		}
+		os.Remove(c.tokenFile())
+	}
+
+	if c.active == "" && len(c.tokens) > 0 {
+		c.setActiveLocked(c.userIDsLocked()[0])
+	}
+	if tok := c.tokens[c.active]; tok != nil {
+		logUserID(tok)
+	}
+	return nil
+}
+
+// ParseAndStoreToken parses the given token received from the server and saves it
+// in the slot of the user it was issued to
+func (c *multiUserProvider) ParseAndStoreToken(token string) (Token, error) {
+	tok, err := c.parseToken(token)
+	if err != nil {
+		return nil, err
+	}
+	if tok.UserId == "" {
+		return nil, fmt.Errorf("token has no user")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.storeLocked(tok, token); err != nil {
+		return nil, err
+	}
+	if c.active == "" {
+		c.setActiveLocked(tok.UserId)
+	}
+	if tok.UserId == c.active {
+		logUserID(tok)
+	}
+	return tokWrapper{tok}, nil
+}
+
+// GetToken returns the active user's token, if there is one
+func (c *multiUserProvider) GetToken() Token {
+	return c.GetUserToken(c.ActiveUser())
+}
+
+// GetUserToken returns the token stored for the given user, if there is one
+func (c *multiUserProvider) GetUserToken(userID string) Token {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if tok := c.tokens[userID]; tok != nil {
+		return tokWrapper{tok}
+	}
+	return nil
+}
+
+// UserIDs returns the IDs of all users with a stored token, sorted
+func (c *multiUserProvider) UserIDs() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.userIDsLocked()
+}
+
+// ActiveUser returns the ID of the active user
+func (c *multiUserProvider) ActiveUser() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.active
+}
+
+// SetActiveUser selects the user whose token GetToken will return
+func (c *multiUserProvider) SetActiveUser(userID string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.tokens[userID] == nil {
+		return fmt.Errorf("no token for user %s", userID)
+	}
+	c.setActiveLocked(userID)
+	logUserID(c.tokens[userID])
+	return nil
+}
+
+// RemoveUser deletes the stored token for the given user; if they were the active
+// user, no user is active afterward
+func (c *multiUserProvider) RemoveUser(userID string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.tokens[userID] == nil {
+		return nil
+	}
+	delete(c.tokens, userID)
+	if c.active == userID {
+		c.active = ""
+		os.Remove(c.activeFile())
+	}
+	if err := os.Remove(c.userFile(userID)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// RemoveToken deletes the active user's token
+func (c *multiUserProvider) RemoveToken() error {
+	return c.RemoveUser(c.ActiveUser())
+}
+
+func (c *multiUserProvider) storeLocked(tok *TokenInfo, token string) error {
+	if err := os.MkdirAll(c.usersPath(), 0700); err != nil {
+		return err
+	}
+	fileName := c.userFile(tok.UserId)
+	tmpFileName := fileName + ".tmp"
+	if err := ioutil.WriteFile(tmpFileName, []byte(token), 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpFileName, fileName); err != nil {
+		return err
+	}
+	c.tokens[tok.UserId] = tok
+	return nil
+}
+
+func (c *multiUserProvider) setActiveLocked(userID string) {
+	c.active = userID
+	if err := ioutil.WriteFile(c.activeFile(), []byte(userID), 0600); err != nil {
+		log.Println("Error saving active user:", err)
+	}
+}
+
+func (c *multiUserProvider) userIDsLocked() []string {
+	ret := make([]string, 0, len(c.tokens))
+	for user := range c.tokens {
+		ret = append(ret, user)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+func (c *multiUserProvider) usersPath() string {
+	return path.Join(c.jwtPath, usersDir)
+}
+
+// userFile returns the file a user's token is stored in; user IDs are encoded so
+// they can't escape the users dir
+func (c *multiUserProvider) userFile(userID string) string {
+	return path.Join(c.usersPath(), base64.RawURLEncoding.EncodeToString([]byte(userID))+userFileSuffix)
+}
+
+func (c *multiUserProvider) activeFile() string {
+	return path.Join(c.jwtPath, activeUserFile)
+}
@@ -0,0 +1,89 @@
+package identity
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMultiUserProvider(t *testing.T) {
+	dir, err := ioutil.TempDir("", "multiuser")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// an existing single user token is imported and made active
+	require.NoError(t, ioutil.WriteFile(path.Join(dir, jwtFile), []byte(testToken(t, "alice")), 0600))
+	p := newMultiUserProvider(&fileProvider{jwtPath: dir})
+	require.NoError(t, p.Init())
+	assert.Equal(t, "alice", p.ActiveUser())
+	require.NotNil(t, p.GetToken())
+	assert.Equal(t, "alice", p.GetToken().UserID())
+	_, err = os.Stat(path.Join(dir, jwtFile))
+	assert.True(t, os.IsNotExist(err))
+
+	// storing another user's token doesn't change the active user
+	_, err = p.ParseAndStoreToken(testToken(t, "bob/../../etc"))
+	require.NoError(t, err)
+	assert.Equal(t, "alice", p.ActiveUser())
+	assert.Equal(t, []string{"alice", "bob/../../etc"}, p.UserIDs())
+	require.NotNil(t, p.GetUserToken("bob/../../etc"))
+	assert.Nil(t, p.GetUserToken("carol"))
+
+	assert.Error(t, p.SetActiveUser("carol"))
+	require.NoError(t, p.SetActiveUser("bob/../../etc"))
+	assert.Equal(t, "bob/../../etc", p.GetToken().UserID())
+
+	// tokens and the selection survive a restart
+	p = newMultiUserProvider(&fileProvider{jwtPath: dir})
+	require.NoError(t, p.Init())
+	assert.Equal(t, "bob/../../etc", p.ActiveUser())
+	assert.Equal(t, []string{"alice", "bob/../../etc"}, p.UserIDs())
+
+	require.NoError(t, p.RemoveUser("bob/../../etc"))
+	assert.Equal(t, "", p.ActiveUser())
+	assert.Nil(t, p.GetToken())
+	p = newMultiUserProvider(&fileProvider{jwtPath: dir})
+	require.NoError(t, p.Init())
+	assert.Equal(t, []string{"alice"}, p.UserIDs())
+	assert.Equal(t, "alice", p.ActiveUser())
+}
+
+func TestMultiUserStoredTokens(t *testing.T) {
+	dir, err := ioutil.TempDir("", "multiuser")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	v, err := NewVerifier(jwksFor(t, map[string]interface{}{"1": &key.PublicKey}))
+	require.NoError(t, err)
+	p := newMultiUserProvider(&fileProvider{jwtPath: dir, verifier: v})
+	require.NoError(t, p.Init())
+	_, err = p.ParseAndStoreToken(signToken(t, jwt.SigningMethodES256, "1", key, time.Now()))
+	require.NoError(t, err)
+
+	// a stored token isn't discarded for looking expired, since the clock may be wrong
+	expired := signToken(t, jwt.SigningMethodES256, "1", key, time.Now().Add(-48*time.Hour))
+	require.NoError(t, ioutil.WriteFile(p.userFile("user"), []byte(expired), 0600))
+	p = newMultiUserProvider(&fileProvider{jwtPath: dir, verifier: v})
+	require.NoError(t, p.Init())
+	require.NotNil(t, p.GetUserToken("user"))
+	assert.Equal(t, expired, p.GetUserToken("user").String())
+
+	// but one that isn't signed by a known key is
+	require.NoError(t, ioutil.WriteFile(p.userFile("user"),
+		[]byte(signToken(t, jwt.SigningMethodES256, "2", key, time.Now())), 0600))
+	p = newMultiUserProvider(&fileProvider{jwtPath: dir, verifier: v})
+	require.NoError(t, p.Init())
+	assert.Nil(t, p.GetUserToken("user"))
+	assert.NoFileExists(t, p.userFile("user"))
+}
@@ -0,0 +1,49 @@
+package identity
+
+import (
+	"github.com/digital-dream-labs/vector-cloud/internal/config"
+	"github.com/digital-dream-labs/vector-cloud/internal/log"
+	"github.com/digital-dream-labs/vector-cloud/internal/robot"
+)
+
+// Option defines an option that can be set on an identity provider
+type Option func(o *options)
+
+type options struct {
+	verifier  *Verifier
+	keySource robot.KeySource
+}
+
+// WithVerifier specifies that tokens must have a valid signature from the given
+// key set to be accepted
+func WithVerifier(v *Verifier) Option {
+	return func(o *options) {
+		o.verifier = v
+	}
+}
+
+// WithKeySource specifies where the robot's certificate and private key come from,
+// instead of the files in the cloud directory
+func WithKeySource(src robot.KeySource) Option {
+	return func(o *options) {
+		o.keySource = src
+	}
+}
+
+// ConfigOptions returns the provider options specified by the server config. If it
+// specifies a key set that can't be loaded, no token is accepted until one can be.
+func ConfigOptions() []Option {
+	verifier, err := VerifierFromConfig(&config.Env)
+	if err != nil {
+		log.Println("Error loading token signing keys, no tokens will be accepted:", err)
+		var filename string
+		if len(config.Env.JWKS) == 0 {
+			filename = config.Env.JWKSFile
+		}
+		verifier = emptyVerifier(filename)
+	}
+	if verifier == nil {
+		return nil
+	}
+	return []Option{WithVerifier(verifier)}
+}
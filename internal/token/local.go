@@ -0,0 +1,47 @@
+package token
+
+import (
+	"sync"
+
+	"github.com/digital-dream-labs/vector-cloud/internal/config"
+	"github.com/digital-dream-labs/vector-cloud/internal/log"
+	"github.com/digital-dream-labs/vector-cloud/internal/token/identity"
+	"github.com/digital-dream-labs/vector-cloud/internal/token/local"
+
+	"google.golang.org/grpc/credentials"
+)
+
+// the local token server selected by config, loaded on first use
+var localBackend struct {
+	sync.Mutex
+	filename string
+	server   *local.Server
+}
+
+func newLocalConn(identityProvider identity.Provider, creds credentials.PerRPCCredentials) (*conn, error) {
+	server, err := localServer(config.Env.LocalToken)
+	if err != nil {
+		return nil, err
+	}
+	return &conn{client: local.NewClient(server, identityProvider.CertCommonName(), creds)}, nil
+}
+
+func localServer(filename string) (*local.Server, error) {
+	localBackend.Lock()
+	defer localBackend.Unlock()
+	if localBackend.server != nil && localBackend.filename == filename {
+		return localBackend.server, nil
+	}
+	cfg, err := local.LoadConfig(filename)
+	if err != nil {
+		return nil, err
+	}
+	server, err := local.NewServer(cfg)
+	if err != nil {
+		return nil, err
+	}
+	log.Println("Using local token server configured by", filename)
+	localBackend.filename = filename
+	localBackend.server = server
+	return server, nil
+}
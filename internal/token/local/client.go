@@ -0,0 +1,102 @@
+package local
+
+import (
+	"context"
+
+	pb "github.com/digital-dream-labs/api/go/tokenpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+)
+
+type client struct {
+	server      *Server
+	requestorID string
+	creds       credentials.PerRPCCredentials
+}
+
+// NewClient returns a client that calls the given server in-process, as the robot
+// with the given ID; creds supplies the request metadata that would otherwise be
+// sent over the wire
+func NewClient(server *Server, requestorID string, creds credentials.PerRPCCredentials) pb.TokenClient {
+	return &client{server: server, requestorID: requestorID, creds: creds}
+}
+
+// context turns the client's credentials into what the server would receive from
+// a remote client
+func (c *client) context(ctx context.Context) (context.Context, error) {
+	md := metadata.MD{}
+	if c.creds != nil {
+		m, err := c.creds.GetRequestMetadata(ctx)
+		if err != nil {
+			return nil, err
+		}
+		md = metadata.New(m)
+	}
+	ctx = metadata.NewIncomingContext(ctx, md)
+	return context.WithValue(ctx, requestorKey{}, c.requestorID), nil
+}
+
+func (c *client) AssociatePrimaryUser(ctx context.Context, in *pb.AssociatePrimaryUserRequest, opts ...grpc.CallOption) (*pb.AssociatePrimaryUserResponse, error) {
+	ctx, err := c.context(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return c.server.AssociatePrimaryUser(ctx, in)
+}
+
+func (c *client) ReassociatePrimaryUser(ctx context.Context, in *pb.ReassociatePrimaryUserRequest, opts ...grpc.CallOption) (*pb.ReassociatePrimaryUserResponse, error) {
+	ctx, err := c.context(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return c.server.ReassociatePrimaryUser(ctx, in)
+}
+
+func (c *client) AssociateSecondaryClient(ctx context.Context, in *pb.AssociateSecondaryClientRequest, opts ...grpc.CallOption) (*pb.AssociateSecondaryClientResponse, error) {
+	ctx, err := c.context(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return c.server.AssociateSecondaryClient(ctx, in)
+}
+
+func (c *client) DisassociatePrimaryUser(ctx context.Context, in *pb.DisassociatePrimaryUserRequest, opts ...grpc.CallOption) (*pb.DisassociatePrimaryUserResponse, error) {
+	ctx, err := c.context(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return c.server.DisassociatePrimaryUser(ctx, in)
+}
+
+func (c *client) RefreshToken(ctx context.Context, in *pb.RefreshTokenRequest, opts ...grpc.CallOption) (*pb.RefreshTokenResponse, error) {
+	ctx, err := c.context(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return c.server.RefreshToken(ctx, in)
+}
+
+func (c *client) ListRevokedTokens(ctx context.Context, in *pb.ListRevokedTokensRequest, opts ...grpc.CallOption) (*pb.ListRevokedTokensResponse, error) {
+	ctx, err := c.context(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return c.server.ListRevokedTokens(ctx, in)
+}
+
+func (c *client) RevokeFactoryCertificate(ctx context.Context, in *pb.RevokeFactoryCertificateRequest, opts ...grpc.CallOption) (*pb.RevokeFactoryCertificateResponse, error) {
+	ctx, err := c.context(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return c.server.RevokeFactoryCertificate(ctx, in)
+}
+
+func (c *client) RevokeTokens(ctx context.Context, in *pb.RevokeTokensRequest, opts ...grpc.CallOption) (*pb.RevokeTokensResponse, error) {
+	ctx, err := c.context(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return c.server.RevokeTokens(ctx, in)
+}
@@ -0,0 +1,76 @@
+package local
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"time"
+)
+
+// DefaultTokenLifetime is how long issued access tokens are valid for if the config
+// doesn't say otherwise
+const DefaultTokenLifetime = 24 * time.Hour
+
+// DefaultStsLifetime is how long handed out STS credentials are reported to be
+// valid for if the config doesn't say otherwise
+const DefaultStsLifetime = time.Hour
+
+// Config describes a local token server
+type Config struct {
+	// Dir is where the signing key and association state are stored
+	Dir string `json:"dir"`
+	// Sessions maps the user session tokens that are accepted to the ID of the user
+	// they belong to; if empty, any session is accepted and the user ID is derived
+	// from it
+	Sessions map[string]string `json:"sessions,omitempty"`
+	// AppKey, if set, must be sent by clients in the anki-app-key header
+	AppKey string `json:"appkey,omitempty"`
+	// RobotID is the requestor that tokens are issued to when a client's certificate
+	// isn't available to identify it
+	RobotID string `json:"robot_id,omitempty"`
+	// InsecureClientCerts identifies robots by client certificates that weren't
+	// verified against a CA, which anyone can make; for testing only
+	InsecureClientCerts bool `json:"-"`
+	// TokenLifetimeMinutes is how long access tokens are valid for
+	TokenLifetimeMinutes uint32 `json:"token_lifetime_minutes,omitempty"`
+	// Sts optionally holds static AWS credentials to hand out for STS requests;
+	// without them, STS requests fail
+	Sts *StsConfig `json:"sts,omitempty"`
+}
+
+// StsConfig holds the AWS credentials handed out by a local token server
+type StsConfig struct {
+	AccessKeyID     string `json:"access_key_id"`
+	SecretAccessKey string `json:"secret_access_key"`
+	SessionToken    string `json:"session_token,omitempty"`
+	LifetimeMinutes uint32 `json:"lifetime_minutes,omitempty"`
+}
+
+// LoadConfig reads a local token server config from the given file
+func LoadConfig(filename string) (*Config, error) {
+	buf, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var cfg Config
+	if err := json.Unmarshal(buf, &cfg); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+func (c *Config) tokenLifetime(expirationMinutes uint32) time.Duration {
+	if expirationMinutes != 0 {
+		return time.Duration(expirationMinutes) * time.Minute
+	}
+	if c.TokenLifetimeMinutes != 0 {
+		return time.Duration(c.TokenLifetimeMinutes) * time.Minute
+	}
+	return DefaultTokenLifetime
+}
+
+func (c *StsConfig) lifetime() time.Duration {
+	if c.LifetimeMinutes != 0 {
+		return time.Duration(c.LifetimeMinutes) * time.Minute
+	}
+	return DefaultStsLifetime
+}
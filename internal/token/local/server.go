@@ -0,0 +1,471 @@
+// Package local implements the token service API without Anki's cloud, so that a
+// robot can be run self-contained or against a small self-hosted server. Access
+// tokens are signed with a key of the server's own, and publishing its JWKS lets
+// robots verify them.
+package local
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/digital-dream-labs/vector-cloud/internal/token/identity"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	pb "github.com/digital-dream-labs/api/go/tokenpb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	keyFile   = "signing_key.pem"
+	stateFile = "state.json"
+
+	// sizes of client tokens and the salt of their stored hashes, matching what
+	// vic-gateway expects
+	clientTokenSize = 16
+	saltSize        = 16
+)
+
+// Server is a local implementation of the token service
+type Server struct {
+	pb.UnimplementedTokenServer
+
+	cfg      Config
+	key      *ecdsa.PrivateKey
+	kid      string
+	verifier *identity.Verifier
+
+	// mu guards state and its file
+	mu    sync.Mutex
+	state state
+}
+
+type state struct {
+	Robots map[string]*robotState `json:"robots"`
+}
+
+// robotState records who a robot is associated with; access tokens issued before
+// the association are revoked
+type robotState struct {
+	UserID       string        `json:"user_id"`
+	AssociatedAt time.Time     `json:"associated_at"`
+	ClientTokens []clientToken `json:"client_tokens"`
+}
+
+type clientToken struct {
+	Hash       string `json:"hash"`
+	ClientName string `json:"client_name"`
+	AppID      string `json:"app_id"`
+	IssuedAt   string `json:"issued_at"`
+}
+
+// NewServer creates a local token server, loading its signing key and state from
+// the configured directory and creating them if they don't exist
+func NewServer(cfg *Config) (*Server, error) {
+	if cfg.Dir == "" {
+		return nil, errors.New("no directory configured for local token server")
+	}
+	if err := os.MkdirAll(cfg.Dir, 0700); err != nil {
+		return nil, err
+	}
+	s := &Server{cfg: *cfg, state: state{Robots: make(map[string]*robotState)}}
+	if err := s.loadKey(); err != nil {
+		return nil, err
+	}
+	jwks, err := s.JWKS()
+	if err != nil {
+		return nil, err
+	}
+	if s.verifier, err = identity.NewVerifier(jwks); err != nil {
+		return nil, err
+	}
+	if buf, err := ioutil.ReadFile(path.Join(cfg.Dir, stateFile)); err == nil {
+		if err := json.Unmarshal(buf, &s.state); err != nil {
+			return nil, err
+		}
+		if s.state.Robots == nil {
+			s.state.Robots = make(map[string]*robotState)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	return s, nil
+}
+
+// JWKS returns the JSON Web Key Set that tokens issued by the server can be
+// verified with
+func (s *Server) JWKS() ([]byte, error) {
+	b64 := base64.RawURLEncoding.EncodeToString
+	size := (s.key.Curve.Params().BitSize + 7) / 8
+	pad := func(b []byte) []byte {
+		return append(make([]byte, size-len(b)), b...)
+	}
+	return json.Marshal(map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "EC",
+			"kid": s.kid,
+			"use": "sig",
+			"alg": "ES256",
+			"crv": "P-256",
+			"x":   b64(pad(s.key.X.Bytes())),
+			"y":   b64(pad(s.key.Y.Bytes())),
+		}},
+	})
+}
+
+// AssociatePrimaryUser makes the user whose session is given the owner of the calling
+// robot, revoking any access tokens issued before
+func (s *Server) AssociatePrimaryUser(ctx context.Context, req *pb.AssociatePrimaryUserRequest) (*pb.AssociatePrimaryUserResponse, error) {
+	robotID, userID, err := s.sessionAuth(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UTC()
+	robot := &robotState{UserID: userID, AssociatedAt: now}
+	if prev := s.state.Robots[robotID]; prev != nil && prev.UserID == userID && !req.RevokeClientTokens {
+		robot.ClientTokens = prev.ClientTokens
+	}
+	s.state.Robots[robotID] = robot
+
+	bundle, err := s.issue(robotID, robot, now, 0, !req.SkipClientToken, req.ClientName, req.AppId, req.GenerateStsToken)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.AssociatePrimaryUserResponse{Data: bundle}, nil
+}
+
+// ReassociatePrimaryUser issues new tokens to the owner of the calling robot without
+// revoking existing ones
+func (s *Server) ReassociatePrimaryUser(ctx context.Context, req *pb.ReassociatePrimaryUserRequest) (*pb.ReassociatePrimaryUserResponse, error) {
+	robotID, userID, err := s.sessionAuth(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UTC()
+	robot := s.state.Robots[robotID]
+	if robot == nil {
+		robot = &robotState{UserID: userID, AssociatedAt: now}
+		s.state.Robots[robotID] = robot
+	} else if robot.UserID != userID {
+		return nil, status.Error(codes.InvalidArgument, "robot is associated with a different user")
+	}
+
+	bundle, err := s.issue(robotID, robot, now, req.ExpirationMinutes, !req.SkipClientToken, req.ClientName, req.AppId, req.GenerateStsToken)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.ReassociatePrimaryUserResponse{Data: bundle}, nil
+}
+
+// AssociateSecondaryClient issues a client token for another app of the robot's owner
+func (s *Server) AssociateSecondaryClient(ctx context.Context, req *pb.AssociateSecondaryClientRequest) (*pb.AssociateSecondaryClientResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	robotID, tok, err := s.tokenAuth(ctx)
+	if err != nil {
+		return nil, err
+	}
+	userID, err := s.sessionUser(req.UserSession)
+	if err != nil {
+		return nil, err
+	}
+	if userID != tok.UserId {
+		return nil, status.Error(codes.InvalidArgument, "only the primary user can associate clients")
+	}
+
+	bundle, err := s.issue(robotID, s.state.Robots[robotID], time.Now().UTC(), 0, true, req.ClientName, req.AppId, false)
+	if err != nil {
+		return nil, err
+	}
+	// the caller already has an access token
+	bundle.Token = ""
+	return &pb.AssociateSecondaryClientResponse{Data: bundle}, nil
+}
+
+// DisassociatePrimaryUser removes the calling robot's owner, revoking all the tokens
+// issued to it
+func (s *Server) DisassociatePrimaryUser(ctx context.Context, req *pb.DisassociatePrimaryUserRequest) (*pb.DisassociatePrimaryUserResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	robotID, _, err := s.tokenAuth(ctx)
+	if err != nil {
+		return nil, err
+	}
+	delete(s.state.Robots, robotID)
+	if err := s.saveState(); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &pb.DisassociatePrimaryUserResponse{}, nil
+}
+
+// RefreshToken issues a new access token and/or STS credentials in exchange for a
+// valid access token
+func (s *Server) RefreshToken(ctx context.Context, req *pb.RefreshTokenRequest) (*pb.RefreshTokenResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	robotID, _, err := s.tokenAuth(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	bundle := &pb.TokenBundle{}
+	now := time.Now().UTC()
+	if req.RefreshJwtTokens {
+		if bundle.Token, err = s.accessToken(robotID, s.state.Robots[robotID].UserID, now, req.ExpirationMinutes); err != nil {
+			return nil, err
+		}
+	}
+	if req.RefreshStsTokens {
+		if bundle.StsToken, err = s.stsToken(now); err != nil {
+			return nil, err
+		}
+	}
+	return &pb.RefreshTokenResponse{Data: bundle}, nil
+}
+
+// issue creates a token bundle for the given robot and saves the state; mu must be
+// held
+func (s *Server) issue(robotID string, robot *robotState, now time.Time, expirationMinutes uint32,
+	withClientToken bool, clientName, appID string, withSts bool) (*pb.TokenBundle, error) {
+
+	var bundle pb.TokenBundle
+	var err error
+	if bundle.Token, err = s.accessToken(robotID, robot.UserID, now, expirationMinutes); err != nil {
+		return nil, err
+	}
+	if withClientToken {
+		token, hash, err := newClientToken()
+		if err != nil {
+			return nil, err
+		}
+		robot.ClientTokens = append(robot.ClientTokens, clientToken{
+			Hash:       hash,
+			ClientName: clientName,
+			AppID:      appID,
+			IssuedAt:   now.Format(time.RFC3339),
+		})
+		bundle.ClientToken = token
+	}
+	if withSts {
+		if bundle.StsToken, err = s.stsToken(now); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.saveState(); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &bundle, nil
+}
+
+func (s *Server) accessToken(robotID, userID string, now time.Time, expirationMinutes uint32) (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	tok := identity.TokenInfo{
+		Id:          hex.EncodeToString(id),
+		Type:        "user+robot",
+		RequestorId: robotID,
+		UserId:      userID,
+		IssuedAt:    now,
+		ExpiresAt:   now.Add(s.cfg.tokenLifetime(expirationMinutes)),
+	}
+	jt := tok.JwtToken(jwt.SigningMethodES256)
+	jt.Header["kid"] = s.kid
+	return jt.SignedString(s.key)
+}
+
+func (s *Server) stsToken(now time.Time) (*pb.StsToken, error) {
+	if s.cfg.Sts == nil {
+		return nil, status.Error(codes.Unimplemented, "no STS credentials configured")
+	}
+	return &pb.StsToken{
+		AccessKeyId:     s.cfg.Sts.AccessKeyID,
+		SecretAccessKey: s.cfg.Sts.SecretAccessKey,
+		SessionToken:    s.cfg.Sts.SessionToken,
+		Expiration:      now.Add(s.cfg.Sts.lifetime()).Format(time.RFC3339),
+	}, nil
+}
+
+// newClientToken returns a new client token and its salted hash, in the format
+// vic-gateway compares them in
+func newClientToken() (token string, hash string, err error) {
+	buf := make([]byte, clientTokenSize+saltSize)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	tok, salt := buf[:clientTokenSize], buf[clientTokenSize:]
+	sum := sha256.Sum256(buf)
+	return base64.StdEncoding.EncodeToString(tok),
+		base64.StdEncoding.EncodeToString(append(sum[:], salt...)), nil
+}
+
+// sessionAuth identifies the calling robot and the user whose session it sent
+func (s *Server) sessionAuth(ctx context.Context) (robotID string, userID string, err error) {
+	md, err := s.metadata(ctx)
+	if err != nil {
+		return "", "", err
+	}
+	if robotID, err = s.requestor(ctx); err != nil {
+		return "", "", err
+	}
+	if userID, err = s.sessionUser(first(md, "anki-user-session")); err != nil {
+		return "", "", err
+	}
+	return robotID, userID, nil
+}
+
+// tokenAuth identifies the calling robot and checks the access token it sent was
+// issued to it by this server and hasn't been revoked; mu must be held
+func (s *Server) tokenAuth(ctx context.Context) (string, *identity.TokenInfo, error) {
+	md, err := s.metadata(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+	robotID, err := s.requestor(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+	tok, err := s.verifier.Verify(first(md, "anki-access-token"))
+	if err != nil {
+		return "", nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	robot := s.state.Robots[robotID]
+	if tok.RequestorId != robotID || robot == nil || robot.UserID != tok.UserId ||
+		tok.IssuedAt.Before(robot.AssociatedAt) {
+		return "", nil, status.Error(codes.PermissionDenied, "token has been revoked")
+	}
+	return robotID, tok, nil
+}
+
+func (s *Server) metadata(ctx context.Context) (metadata.MD, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if s.cfg.AppKey != "" && first(md, "anki-app-key") != s.cfg.AppKey {
+		return nil, status.Error(codes.Unauthenticated, "invalid app key")
+	}
+	return md, nil
+}
+
+func (s *Server) sessionUser(session string) (string, error) {
+	if session == "" {
+		return "", status.Error(codes.Unauthenticated, "no user session")
+	}
+	if len(s.cfg.Sessions) == 0 {
+		sum := sha256.Sum256([]byte(session))
+		return hex.EncodeToString(sum[:8]), nil
+	}
+	if user, ok := s.cfg.Sessions[session]; ok {
+		return user, nil
+	}
+	return "", status.Error(codes.Unauthenticated, "unknown user session")
+}
+
+type requestorKey struct{}
+
+// requestor returns the ID of the calling robot: the common name of its verified
+// certificate, or the ID given by an in-process client, or the configured robot ID
+func (s *Server) requestor(ctx context.Context) (string, error) {
+	if p, ok := peer.FromContext(ctx); ok {
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok && len(info.State.PeerCertificates) > 0 {
+			if len(info.State.VerifiedChains) == 0 && !s.cfg.InsecureClientCerts {
+				return "", status.Error(codes.Unauthenticated, "robot certificate wasn't verified")
+			}
+			return info.State.PeerCertificates[0].Subject.CommonName, nil
+		}
+	}
+	if id, ok := ctx.Value(requestorKey{}).(string); ok && id != "" {
+		return id, nil
+	}
+	if s.cfg.RobotID != "" {
+		return s.cfg.RobotID, nil
+	}
+	return "", status.Error(codes.Unauthenticated, "can't identify robot")
+}
+
+func first(md metadata.MD, key string) string {
+	if vals := md.Get(key); len(vals) > 0 {
+		return vals[0]
+	}
+	return ""
+}
+
+func (s *Server) loadKey() error {
+	fileName := path.Join(s.cfg.Dir, keyFile)
+	buf, err := ioutil.ReadFile(fileName)
+	if os.IsNotExist(err) {
+		if s.key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+			return err
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(s.key)
+		if err != nil {
+			return err
+		}
+		buf = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+		if err := writeFile(fileName, buf); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	} else {
+		block, _ := pem.Decode(buf)
+		if block == nil {
+			return errors.New("invalid signing key file")
+		}
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return err
+		}
+		ecKey, ok := key.(*ecdsa.PrivateKey)
+		if !ok || ecKey.Curve != elliptic.P256() {
+			return errors.New("signing key must be an EC P-256 key")
+		}
+		s.key = ecKey
+	}
+
+	pub, err := x509.MarshalPKIXPublicKey(&s.key.PublicKey)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(pub)
+	s.kid = hex.EncodeToString(sum[:8])
+	return nil
+}
+
+func (s *Server) saveState() error {
+	buf, err := json.Marshal(s.state)
+	if err != nil {
+		return err
+	}
+	return writeFile(path.Join(s.cfg.Dir, stateFile), buf)
+}
+
+func writeFile(fileName string, buf []byte) error {
+	tmpFileName := fileName + ".tmp"
+	if err := ioutil.WriteFile(tmpFileName, buf, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpFileName, fileName)
+}
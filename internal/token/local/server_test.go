@@ -0,0 +1,94 @@
+package local
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/digital-dream-labs/vector-cloud/internal/token/identity"
+	"github.com/digital-dream-labs/vector-cloud/internal/util"
+
+	pb "github.com/digital-dream-labs/api/go/tokenpb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+func TestServerAuth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "local_token")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	s, err := NewServer(&Config{Dir: dir, AppKey: "appkey", RobotID: "vic:00000000"})
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	// the app key is required
+	client := NewClient(s, "", util.MapCredentials{"anki-user-session": "session"})
+	_, err = client.AssociatePrimaryUser(ctx, &pb.AssociatePrimaryUserRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// without configured sessions, any session is accepted and always maps to the same
+	// user; without a certificate, the configured robot ID is used
+	client = NewClient(s, "", util.MapCredentials{"anki-app-key": "appkey", "anki-user-session": "session"})
+	resp, err := client.AssociatePrimaryUser(ctx, &pb.AssociatePrimaryUserRequest{SkipClientToken: true})
+	require.NoError(t, err)
+	assert.Empty(t, resp.Data.ClientToken)
+	jwks, err := s.JWKS()
+	require.NoError(t, err)
+	v, err := identity.NewVerifier(jwks)
+	require.NoError(t, err)
+	tok, err := v.Verify(resp.Data.Token)
+	require.NoError(t, err)
+	assert.Equal(t, "vic:00000000", tok.RequestorId)
+	userID := tok.UserId
+	assert.NotEmpty(t, userID)
+
+	resp, err = client.AssociatePrimaryUser(ctx, &pb.AssociatePrimaryUserRequest{})
+	require.NoError(t, err)
+	tok, err = v.Verify(resp.Data.Token)
+	require.NoError(t, err)
+	assert.Equal(t, userID, tok.UserId)
+
+	// STS requests fail without configured credentials
+	client = NewClient(s, "", util.MapCredentials{"anki-app-key": "appkey", "anki-access-token": resp.Data.Token})
+	_, err = client.RefreshToken(ctx, &pb.RefreshTokenRequest{RefreshStsTokens: true})
+	assert.Equal(t, codes.Unimplemented, status.Code(err))
+}
+
+func TestServerRequestor(t *testing.T) {
+	dir, err := ioutil.TempDir("", "local_token")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	s, err := NewServer(&Config{Dir: dir})
+	require.NoError(t, err)
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "vic:00000001"}}
+	withCert := func(verified bool) context.Context {
+		state := tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+		if verified {
+			state.VerifiedChains = [][]*x509.Certificate{{cert}}
+		}
+		return peer.NewContext(context.Background(), &peer.Peer{AuthInfo: credentials.TLSInfo{State: state}})
+	}
+
+	// robots are identified by verified certificates only
+	robotID, err := s.requestor(withCert(true))
+	require.NoError(t, err)
+	assert.Equal(t, "vic:00000001", robotID)
+	_, err = s.requestor(withCert(false))
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// unless told otherwise for testing
+	s.cfg.InsecureClientCerts = true
+	robotID, err = s.requestor(withCert(false))
+	require.NoError(t, err)
+	assert.Equal(t, "vic:00000001", robotID)
+}
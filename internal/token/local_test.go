@@ -0,0 +1,249 @@
+package token
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/digital-dream-labs/vector-cloud/internal/clad/cloud"
+	"github.com/digital-dream-labs/vector-cloud/internal/config"
+	"github.com/digital-dream-labs/vector-cloud/internal/robot"
+	testtime "github.com/digital-dream-labs/vector-cloud/internal/testing/time"
+	"github.com/digital-dream-labs/vector-cloud/internal/token/identity"
+	"github.com/digital-dream-labs/vector-cloud/internal/token/local"
+
+	pb "github.com/digital-dream-labs/api/go/tokenpb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const testRobotID = "vic:00000000"
+
+// writeTestCert creates a robot certificate with the given common name in cloudDir
+func writeTestCert(t *testing.T, cloudDir, commonName string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &tmpl, &tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(path.Join(cloudDir, robot.CertFilename),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+}
+
+// setupLocalBackend configures a local token server in dir and returns it along with
+// a provider that only accepts tokens it signs
+func setupLocalBackend(t *testing.T, dir string) (local.Config, *local.Server, identity.Provider) {
+	cloudDir, jwtDir, serverDir := path.Join(dir, "cloud"), path.Join(dir, "jwt"), path.Join(dir, "server")
+	require.NoError(t, os.Mkdir(cloudDir, 0700))
+	writeTestCert(t, cloudDir, testRobotID)
+
+	// primary association sends the gateway's certificate along
+	if _, err := os.Stat(robot.GatewayCert); os.IsNotExist(err) {
+		require.NoError(t, os.MkdirAll(filepath.Dir(robot.GatewayCert), 0700))
+		require.NoError(t, ioutil.WriteFile(robot.GatewayCert, []byte("cert"), 0600))
+		t.Cleanup(func() { os.Remove(robot.GatewayCert) })
+	}
+
+	cfg := local.Config{
+		Dir:      serverDir,
+		Sessions: map[string]string{"session-a": "userA", "session-b": "userB"},
+		Sts:      &local.StsConfig{AccessKeyID: "key", SecretAccessKey: "secret"},
+	}
+	buf, err := json.Marshal(cfg)
+	require.NoError(t, err)
+	configFile := path.Join(dir, "local.json")
+	require.NoError(t, ioutil.WriteFile(configFile, buf, 0600))
+
+	prevEnv := config.Env
+	t.Cleanup(func() { config.Env = prevEnv })
+	config.Env.LocalToken = configFile
+
+	// the robot only accepts tokens signed by the local server
+	backend, err := localServer(configFile)
+	require.NoError(t, err)
+	jwks, err := backend.JWKS()
+	require.NoError(t, err)
+	verifier, err := identity.NewVerifier(jwks)
+	require.NoError(t, err)
+	provider, err := identity.NewFileProvider(jwtDir, cloudDir, identity.WithVerifier(verifier))
+	require.NoError(t, err)
+	return cfg, backend, provider
+}
+
+func TestLocalBackend(t *testing.T) {
+	dir, err := ioutil.TempDir("", "local_token")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	cfg, backend, provider := setupLocalBackend(t, dir)
+	serverDir := cfg.Dir
+
+	var s Server
+	require.NoError(t, s.Init(provider))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s.Run(ctx)
+
+	// primary association
+	resp, err := s.handleRequest(ctx, cloud.NewTokenRequestWithAuth(&cloud.AuthRequest{SessionToken: "session-a"}))
+	require.NoError(t, err)
+	auth := resp.GetAuth()
+	require.Equal(t, cloud.TokenError_NoError, auth.Error)
+	assert.NotEmpty(t, auth.AppToken)
+	tok := provider.GetToken()
+	require.NotNil(t, tok)
+	assert.Equal(t, "userA", tok.UserID())
+	assert.Equal(t, testRobotID, tok.RequestorID())
+	firstToken := tok.String()
+
+	// the client token is stored hashed the way vic-gateway checks it
+	var state struct {
+		Robots map[string]struct {
+			ClientTokens []struct {
+				Hash string `json:"hash"`
+			} `json:"client_tokens"`
+		} `json:"robots"`
+	}
+	buf, err := ioutil.ReadFile(path.Join(serverDir, "state.json"))
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(buf, &state))
+	require.Len(t, state.Robots[testRobotID].ClientTokens, 1)
+	assert.NoError(t, CompareHashAndToken(state.Robots[testRobotID].ClientTokens[0].Hash, auth.AppToken))
+
+	// refresh
+	resp, err = s.handleRequest(ctx, cloud.NewTokenRequestWithJwt(&cloud.JwtRequest{ForceRefresh: true}))
+	require.NoError(t, err)
+	require.Equal(t, cloud.TokenError_NoError, resp.GetJwt().Error)
+	assert.NotEqual(t, firstToken, resp.GetJwt().JwtToken)
+	assert.Equal(t, "userA", provider.GetToken().UserID())
+
+	// secondary clients and reassociation are only for the primary user
+	resp, err = s.handleRequest(ctx, cloud.NewTokenRequestWithSecondary(&cloud.SecondaryAuthRequest{
+		SessionToken: "session-a", ClientName: "phone", AppId: "app"}))
+	require.NoError(t, err)
+	assert.NotEmpty(t, resp.GetAuth().AppToken)
+	resp, _ = s.handleRequest(ctx, cloud.NewTokenRequestWithSecondary(&cloud.SecondaryAuthRequest{
+		SessionToken: "session-b", ClientName: "phone", AppId: "app"}))
+	assert.Equal(t, cloud.TokenError_WrongAccount, resp.GetAuth().Error)
+	resp, err = s.handleRequest(ctx, cloud.NewTokenRequestWithReassociate(&cloud.ReassociateRequest{
+		SessionToken: "session-a", ClientName: "phone", AppId: "app"}))
+	require.NoError(t, err)
+	assert.NotEmpty(t, resp.GetAuth().AppToken)
+	resp, _ = s.handleRequest(ctx, cloud.NewTokenRequestWithReassociate(&cloud.ReassociateRequest{
+		SessionToken: "session-b", ClientName: "phone", AppId: "app"}))
+	assert.Equal(t, cloud.TokenError_WrongAccount, resp.GetAuth().Error)
+	resp, _ = s.handleRequest(ctx, cloud.NewTokenRequestWithAuth(&cloud.AuthRequest{SessionToken: "session-c"}))
+	assert.Equal(t, cloud.TokenError_Connection, resp.GetAuth().Error)
+
+	// STS credentials
+	creds, err := GetAccessor(provider, &s).GetStsCredentials()
+	require.NoError(t, err)
+	value, err := creds.Get()
+	require.NoError(t, err)
+	assert.Equal(t, "key", value.AccessKeyID)
+
+	// a new primary user revokes tokens issued before
+	currentToken := provider.GetToken().String()
+	resp, err = s.handleRequest(ctx, cloud.NewTokenRequestWithAuth(&cloud.AuthRequest{SessionToken: "session-b"}))
+	require.NoError(t, err)
+	assert.Equal(t, "userB", provider.GetToken().UserID())
+	client := local.NewClient(backend, testRobotID, tokenMetadata(currentToken))
+	_, err = client.RefreshToken(ctx, &pb.RefreshTokenRequest{RefreshJwtTokens: true})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	// the server's key and state survive a restart
+	restarted, err := local.NewServer(&cfg)
+	require.NoError(t, err)
+	client = local.NewClient(restarted, testRobotID, tokenMetadata(provider.GetToken().String()))
+	_, err = client.RefreshToken(ctx, &pb.RefreshTokenRequest{RefreshJwtTokens: true})
+	assert.NoError(t, err)
+
+	// tokens can't be used by a different robot
+	client = local.NewClient(restarted, "vic:11111111", tokenMetadata(provider.GetToken().String()))
+	_, err = client.RefreshToken(ctx, &pb.RefreshTokenRequest{RefreshJwtTokens: true})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestRevocation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "local_token")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	_, backend, provider := setupLocalBackend(t, dir)
+
+	var s Server
+	require.NoError(t, s.Init(provider))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	revoked := make(chan string, 1)
+	s.Run(ctx, WithRevocationHandler(func(userID string) { revoked <- userID }))
+
+	resp, err := s.handleRequest(ctx, cloud.NewTokenRequestWithAuth(&cloud.AuthRequest{SessionToken: "session-a"}))
+	require.NoError(t, err)
+	require.Equal(t, cloud.TokenError_NoError, resp.GetAuth().Error)
+	_, err = GetAccessor(provider, &s).GetStsCredentials()
+	require.NoError(t, err)
+	defer func(prev string) { gatewayTokensFile = prev }(gatewayTokensFile)
+	gatewayTokensFile = path.Join(dir, "token-hashes.json")
+	require.NoError(t, ioutil.WriteFile(gatewayTokensFile, []byte(`{"client_tokens":[]}`), 0600))
+
+	// the robot is logged out remotely
+	client := local.NewClient(backend, testRobotID, tokenMetadata(provider.GetToken().String()))
+	_, err = client.DisassociatePrimaryUser(ctx, &pb.DisassociatePrimaryUserRequest{})
+	require.NoError(t, err)
+
+	// a single refused refresh isn't trusted, nor is the backoff handler's retry of it
+	// right after
+	resp, err = s.handleRequest(ctx, cloud.NewTokenRequestWithJwt(&cloud.JwtRequest{ForceRefresh: true}))
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	assert.Equal(t, cloud.TokenError_Connection, resp.GetJwt().Error)
+	for {
+		if backingOff, denied := s.backoffHandler.state(); !backingOff && denied {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	assert.NotNil(t, provider.GetToken())
+	assert.Empty(t, revoked)
+
+	// but once a later refresh is refused too, everything the old owner was given is
+	// purged
+	testableTime.(testtime.TestableTime).WithNowDelta(confirmDeniedAfter, func() {
+		resp, err = s.handleRequest(ctx, cloud.NewTokenRequestWithJwt(&cloud.JwtRequest{ForceRefresh: true}))
+	})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	assert.Equal(t, cloud.TokenError_Revoked, resp.GetJwt().Error)
+	assert.Equal(t, "userA", <-revoked)
+	assert.Nil(t, provider.GetToken())
+	_, err = os.Stat(gatewayTokensFile)
+	assert.True(t, os.IsNotExist(err))
+	assert.True(t, s.stsCredentials().expiresAt().IsZero())
+
+	// and it stays logged out after a restart
+	restarted, err := identity.NewFileProvider(path.Join(dir, "jwt"), path.Join(dir, "cloud"))
+	require.NoError(t, err)
+	require.NoError(t, restarted.Init())
+	assert.Nil(t, restarted.GetToken())
+
+	// later requests just find no token
+	resp, err = s.handleRequest(ctx, cloud.NewTokenRequestWithJwt(&cloud.JwtRequest{}))
+	require.NoError(t, err)
+	assert.Equal(t, cloud.TokenError_NullToken, resp.GetJwt().Error)
+}
@@ -0,0 +1,58 @@
+package token
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// queueMetrics counts how token requests are handled
+type queueMetrics struct {
+	mu sync.Mutex
+	// JWT requests answered with the stored token
+	cached uint64
+	// JWT refreshes made, and requests that waited on one already in progress
+	refreshes uint64
+	coalesced uint64
+	// auth requests turned away because the queue was full
+	rejected uint64
+	// requests whose caller gave up before they were answered
+	cancelled uint64
+	// tokens purged because the server revoked them
+	revoked uint64
+	// JWT refreshes in progress
+	refreshing int64
+}
+
+func (m *queueMetrics) add(counter *uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	*counter++
+}
+
+func (m *queueMetrics) addRefreshing(delta int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.refreshing += delta
+}
+
+// writePrometheus writes the metrics in the Prometheus text format, along with the
+// number of auth requests waiting in the queue
+func (m *queueMetrics) writePrometheus(w io.Writer, queueDepth int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	gauge := func(name, help string, value int64) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %d\n", name, help, name, name, value)
+	}
+	counter := func(name, help string, value uint64) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", name, help, name, name, value)
+	}
+	gauge("token_queue_depth", "Auth requests waiting to be processed.", int64(queueDepth))
+	gauge("token_refreshes_in_progress", "JWT refreshes in progress.", m.refreshing)
+	counter("token_cached_total", "JWT requests answered with the stored token.", m.cached)
+	counter("token_refreshes_total", "JWT refreshes made.", m.refreshes)
+	counter("token_coalesced_total", "JWT requests that waited on a refresh already in progress.", m.coalesced)
+	counter("token_rejected_total", "Auth requests turned away because the queue was full.", m.rejected)
+	counter("token_cancelled_total", "Requests whose caller gave up before they were answered.", m.cancelled)
+	counter("token_revoked_total", "Tokens purged because the server revoked them.", m.revoked)
+}
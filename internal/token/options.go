@@ -3,6 +3,7 @@ package token
 type options struct {
 	server           bool
 	socketNameSuffix string
+	onRevoked        func(userID string)
 }
 
 // Option defines an option that can be set on the token server
@@ -22,3 +23,11 @@ func WithSocketNameSuffix(socketNameSuffix string) Option {
 		o.socketNameSuffix = socketNameSuffix
 	}
 }
+
+// WithRevocationHandler specifies a function to be called with the user ID of a token
+// after the server revoked it and it was purged, e.g. to prompt for re-pairing
+func WithRevocationHandler(handler func(userID string)) Option {
+	return func(o *options) {
+		o.onRevoked = handler
+	}
+}
@@ -2,12 +2,17 @@ package token
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
 	"time"
 
 	"github.com/digital-dream-labs/vector-cloud/internal/clad/cloud"
 
 	"github.com/digital-dream-labs/vector-cloud/internal/config"
 	"github.com/digital-dream-labs/vector-cloud/internal/log"
+	"github.com/digital-dream-labs/vector-cloud/internal/robot"
 	"github.com/digital-dream-labs/vector-cloud/internal/token/identity"
 	"github.com/digital-dream-labs/vector-cloud/internal/util"
 
@@ -17,12 +22,27 @@ import (
 	"google.golang.org/grpc/status"
 )
 
-// The tokenQueue struct consumes CLAD requests from a message queue (i.e. channel) and forwards them to the conn
-// struct in client.go. The conn struct issues GRPC requests. Messages are produced by the TokenService struct in
-// token.go
+// The tokenQueue struct processes CLAD requests and forwards them to the conn struct in client.go. The conn
+// struct issues GRPC requests. Requests are produced by the TokenService struct in token.go.
+//
+// JWT requests that can be answered from the stored token never wait on anything, and concurrent refreshes of
+// the same token are coalesced into one; auth requests, which are slow and rare, are processed one at a time
+// from a bounded queue so they can't hold up JWT requests.
+
+const (
+	// maxQueueDepth is how many auth requests can wait to be processed before new
+	// ones are turned away
+	maxQueueDepth = 8
+	// defaultRequestTimeout bounds requests whose context has no deadline
+	defaultRequestTimeout = 30 * time.Second
+)
+
+var errQueueFull = errors.New("token request queue is full")
 
 type request struct {
-	m  *cloud.TokenRequest
+	ctx context.Context
+	m   *cloud.TokenRequest
+	// ch is buffered so that the queue never blocks on a caller that gave up
 	ch chan *response
 }
 
@@ -31,40 +51,190 @@ type response struct {
 	err  error
 }
 
+// refreshCall is a JWT refresh in progress, which concurrent requests for the same
+// token wait on instead of making their own
+type refreshCall struct {
+	done chan struct{}
+	tok  identity.Token
+	code cloud.TokenError
+	err  error
+}
+
+// deniedToken is a token the server refused to refresh, and when it first did
+type deniedToken struct {
+	token string
+	at    time.Time
+}
+
+// confirmDeniedAfter is how long after a first refusal to refresh a token another
+// one is trusted, so that the backoff handler's retry, which is made right away,
+// can't confirm it on its own
+const confirmDeniedAfter = retryInitialInterval
+
+// gatewayTokensFile is where the client tokens vic-gateway accepts are kept
+var gatewayTokensFile = robot.GatewayTokensFile
+
 type tokenQueue struct {
 	queue            chan request
 	identityProvider identity.Provider
 	errorHandler     *backoffHandler
+	metrics          queueMetrics
+	// onRevoked, if set, is called with the user ID of a token after it was found
+	// to be revoked and purged
+	onRevoked func(userID string)
+	// storeMu serializes storing tokens, which auth requests and refreshes both do
+	storeMu sync.Mutex
+	// refreshMu guards the refreshes in progress, keyed by user
+	refreshMu sync.Mutex
+	refreshes map[string]*refreshCall
+	// deniedMu guards the tokens the server last refused to refresh, keyed by user
+	deniedMu sync.Mutex
+	denied   map[string]deniedToken
+	// errMu guards the last error seen while handling a request
+	errMu         sync.Mutex
+	lastError     error
+	lastErrorTime time.Time
 }
 
 func (q *tokenQueue) init(ctx context.Context, errorHandler *backoffHandler, identityProvider identity.Provider) error {
-	q.queue = make(chan request)
+	q.queue = make(chan request, maxQueueDepth)
+	q.refreshes = make(map[string]*refreshCall)
+	q.denied = make(map[string]deniedToken)
 	q.errorHandler = errorHandler
 	q.identityProvider = identityProvider
 	go q.routine(ctx)
 	return nil
 }
 
-func (q *tokenQueue) handleRequest(req *request) error {
+// handleRequest processes the given request, returning when it's done or when ctx
+// is; a default deadline is applied if ctx has none
+func (q *tokenQueue) handleRequest(ctx context.Context, m *cloud.TokenRequest) (*cloud.TokenResponse, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultRequestTimeout)
+		defer cancel()
+	}
+
+	if m.Tag() == cloud.TokenRequestTag_Jwt {
+		resp, err := q.handleJwtRequest(ctx, m.GetJwt())
+		q.finish(m, err)
+		return resp, err
+	}
+
+	req := request{ctx: ctx, m: m, ch: make(chan *response, 1)}
+	select {
+	case q.queue <- req:
+	default:
+		q.metrics.add(&q.metrics.rejected)
+		q.finish(m, errQueueFull)
+		return errorResponse(m, cloud.TokenError_Connection), errQueueFull
+	}
+	select {
+	case resp := <-req.ch:
+		return resp.resp, resp.err
+	case <-ctx.Done():
+		q.metrics.add(&q.metrics.cancelled)
+		return errorResponse(m, cloud.TokenError_Connection), ctx.Err()
+	}
+}
+
+// process handles a request from the queue
+func (q *tokenQueue) process(req *request) error {
 	var err error
 	var resp *cloud.TokenResponse
+	if err = req.ctx.Err(); err != nil {
+		// the caller already gave up
+		req.ch <- &response{errorResponse(req.m, cloud.TokenError_Connection), err}
+		return err
+	}
 	switch req.m.Tag() {
 	case cloud.TokenRequestTag_Auth:
-		resp, err = q.handleAuthRequest(req.m.GetAuth().SessionToken)
+		resp, err = q.handleAuthRequest(req.ctx, req.m.GetAuth().SessionToken)
 	case cloud.TokenRequestTag_Secondary:
-		resp, err = q.handleSecondaryAuthRequest(req.m.GetSecondary())
+		resp, err = q.handleSecondaryAuthRequest(req.ctx, req.m.GetSecondary())
 	case cloud.TokenRequestTag_Reassociate:
-		resp, err = q.handleReassociateRequest(req.m.GetReassociate())
-	case cloud.TokenRequestTag_Jwt:
-		resp, err = q.handleJwtRequest(req.m.GetJwt())
+		resp, err = q.handleReassociateRequest(req.ctx, req.m.GetReassociate())
+	default:
+		err = fmt.Errorf("unexpected token request %s", req.m.Tag())
 	}
+	q.finish(req.m, err)
+	req.ch <- &response{resp, err}
+	return err
+}
+
+// finish records the outcome of a request
+func (q *tokenQueue) finish(m *cloud.TokenRequest, err error) {
 	// if we successfully reach the server for a request, re-enable our error handler
 	// (ignore JWT requests, which are very unlikely to actually hit the server)
-	if err == nil && req.m.Tag() != cloud.TokenRequestTag_Jwt {
+	if err == nil && m.Tag() != cloud.TokenRequestTag_Jwt {
 		q.errorHandler.onSuccess()
 	}
-	req.ch <- &response{resp, err}
-	return err
+	if err != nil {
+		q.errMu.Lock()
+		q.lastError = err
+		q.lastErrorTime = time.Now()
+		q.errMu.Unlock()
+	}
+}
+
+// errorResponse returns a response of the right kind for the given request with
+// the given error
+func errorResponse(m *cloud.TokenRequest, code cloud.TokenError) *cloud.TokenResponse {
+	if m.Tag() == cloud.TokenRequestTag_Jwt {
+		return cloud.NewTokenResponseWithJwt(&cloud.JwtResponse{Error: code})
+	}
+	return authErrorResp(code)
+}
+
+// lastErr returns the last error returned by a request and when it occurred
+func (q *tokenQueue) lastErr() (time.Time, error) {
+	q.errMu.Lock()
+	defer q.errMu.Unlock()
+	return q.lastErrorTime, q.lastError
+}
+
+// storeToken parses and stores a token received from the server
+func (q *tokenQueue) storeToken(token string) (identity.Token, error) {
+	q.storeMu.Lock()
+	defer q.storeMu.Unlock()
+	return q.identityProvider.ParseAndStoreToken(token)
+}
+
+// storeUserToken parses a token refreshed from the given one and stores it, unless it
+// belongs to someone else or the given token has been replaced while it was being
+// refreshed, so that a refresh can't overwrite another user's token
+func (q *tokenQueue) storeUserToken(token string, existing identity.Token) (identity.Token, cloud.TokenError, error) {
+	userID := existing.UserID()
+	info, err := identity.ParseUnverified(token)
+	if err != nil {
+		return nil, cloud.TokenError_InvalidToken, err
+	}
+	if info.UserId != userID {
+		return nil, cloud.TokenError_WrongAccount,
+			fmt.Errorf("refreshed token for user %s belongs to %s", userID, info.UserId)
+	}
+
+	q.storeMu.Lock()
+	defer q.storeMu.Unlock()
+	stored := q.identityProvider.GetToken()
+	if multi, ok := q.identityProvider.(identity.MultiUserProvider); ok {
+		stored = multi.GetUserToken(userID)
+	}
+	switch {
+	case stored == nil:
+		return nil, cloud.TokenError_NullToken, fmt.Errorf("token of user %s was removed while being refreshed", userID)
+	case stored.UserID() != userID:
+		return nil, cloud.TokenError_WrongAccount,
+			fmt.Errorf("token of user %s was replaced by %s's while being refreshed", userID, stored.UserID())
+	case stored.String() != existing.String():
+		// someone else stored a newer token for the user meanwhile
+		return stored, cloud.TokenError_NoError, nil
+	}
+	tok, err := q.identityProvider.ParseAndStoreToken(token)
+	if err != nil {
+		return nil, parseErrorCode(err), err
+	}
+	return tok, cloud.TokenError_NoError, nil
 }
 
 func (q *tokenQueue) getConnection(creds credentials.PerRPCCredentials) (*conn, error) {
@@ -79,8 +249,13 @@ func (q *tokenQueue) getConnection(creds credentials.PerRPCCredentials) (*conn,
 // by a request should be returned for logging by processing code, but we need to
 // generate a CLAD response for token requests no matter what, and those responses
 // should indicate the stage of the request where an error occurred
-func (q *tokenQueue) handleJwtRequest(req *cloud.JwtRequest) (*cloud.TokenResponse, error) {
+func (q *tokenQueue) handleJwtRequest(ctx context.Context, req *cloud.JwtRequest) (*cloud.TokenResponse, error) {
 	existing := q.identityProvider.GetToken()
+	// a specific user's token can be requested if the provider stores several;
+	// otherwise there's only one token to give out
+	if multi, ok := q.identityProvider.(identity.MultiUserProvider); ok && req.UserId != "" {
+		existing = multi.GetUserToken(req.UserId)
+	}
 	errorResp := func(code cloud.TokenError) *cloud.TokenResponse {
 		return cloud.NewTokenResponseWithJwt(&cloud.JwtResponse{Error: code})
 	}
@@ -89,21 +264,13 @@ func (q *tokenQueue) handleJwtRequest(req *cloud.JwtRequest) (*cloud.TokenRespon
 	}
 	if existing != nil {
 		if time.Now().After(existing.RefreshTime()) || req.ForceRefresh {
-			c, err := q.getConnection(tokenMetadata(existing.String()))
-			if err != nil {
-				return errorResp(cloud.TokenError_Connection), err
-			}
-			defer c.Close()
-			bundle, err := c.refreshJwtToken()
-			if err != nil {
-				return errorResp(cloud.TokenError_Connection), err
-			}
-			tok, err := q.identityProvider.ParseAndStoreToken(bundle.Token)
+			tok, code, err := q.refreshJwt(ctx, existing)
 			if err != nil {
-				return errorResp(cloud.TokenError_InvalidToken), err
+				return errorResp(code), err
 			}
 			return tokenResp(tok.String()), nil
 		}
+		q.metrics.add(&q.metrics.cached)
 		return tokenResp(existing.String()), nil
 	}
 	// no token: this is an error for whoever we're sending the CLAD response to,
@@ -112,6 +279,146 @@ func (q *tokenQueue) handleJwtRequest(req *cloud.JwtRequest) (*cloud.TokenRespon
 	return errorResp(cloud.TokenError_NullToken), nil
 }
 
+// refreshJwt refreshes the given token, or waits for a refresh of it that's already
+// in progress. The refresh isn't tied to ctx, so a caller giving up doesn't fail it
+// for others waiting on it.
+func (q *tokenQueue) refreshJwt(ctx context.Context, existing identity.Token) (identity.Token, cloud.TokenError, error) {
+	key := existing.UserID()
+	q.refreshMu.Lock()
+	call, ok := q.refreshes[key]
+	if ok {
+		q.metrics.add(&q.metrics.coalesced)
+	} else {
+		call = &refreshCall{done: make(chan struct{})}
+		q.refreshes[key] = call
+		q.metrics.add(&q.metrics.refreshes)
+		q.metrics.addRefreshing(1)
+		go func() {
+			refreshCtx, cancel := context.WithTimeout(context.Background(), defaultRequestTimeout)
+			defer cancel()
+			call.tok, call.code, call.err = q.doRefreshJwt(refreshCtx, existing)
+			q.refreshMu.Lock()
+			delete(q.refreshes, key)
+			q.refreshMu.Unlock()
+			q.metrics.addRefreshing(-1)
+			close(call.done)
+		}()
+	}
+	q.refreshMu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.tok, call.code, call.err
+	case <-ctx.Done():
+		q.metrics.add(&q.metrics.cancelled)
+		return nil, cloud.TokenError_Connection, ctx.Err()
+	}
+}
+
+func (q *tokenQueue) doRefreshJwt(ctx context.Context, existing identity.Token) (identity.Token, cloud.TokenError, error) {
+	c, err := q.getConnection(tokenMetadata(existing.String()))
+	if err != nil {
+		return nil, cloud.TokenError_Connection, err
+	}
+	defer c.Close()
+	bundle, err := c.refreshJwtToken(ctx)
+	if status.Code(err) == codes.PermissionDenied {
+		// the robot may have been disassociated from the account, e.g. by its new
+		// owner, but that's only acted on once a retry is refused too
+		if q.confirmDenied(existing) {
+			q.revoke(existing)
+			return nil, cloud.TokenError_Revoked, err
+		}
+		q.errorHandler.OnError(err)
+		return nil, cloud.TokenError_Connection, err
+	} else if err != nil {
+		return nil, cloud.TokenError_Connection, err
+	}
+	q.clearDenied(existing.UserID())
+	return q.storeUserToken(bundle.Token, existing)
+}
+
+// confirmDenied records that the server refused to refresh the given token, and
+// returns true if it had already refused it at least confirmDeniedAfter before
+func (q *tokenQueue) confirmDenied(tok identity.Token) bool {
+	q.deniedMu.Lock()
+	defer q.deniedMu.Unlock()
+	now := testableTime.Now()
+	if prev, ok := q.denied[tok.UserID()]; ok && prev.token == tok.String() {
+		if now.Sub(prev.at) < confirmDeniedAfter {
+			return false
+		}
+		delete(q.denied, tok.UserID())
+		return true
+	}
+	q.denied[tok.UserID()] = deniedToken{tok.String(), now}
+	return false
+}
+
+func (q *tokenQueue) clearDenied(userID string) {
+	q.deniedMu.Lock()
+	defer q.deniedMu.Unlock()
+	delete(q.denied, userID)
+}
+
+// revoke purges the given token, which the server refused to refresh, along with the
+// client tokens vic-gateway accepts if it belonged to the robot's primary user, so
+// that whoever the robot was taken from can no longer use it
+func (q *tokenQueue) revoke(tok identity.Token) {
+	userID := tok.UserID()
+	if !q.purge(tok) {
+		return
+	}
+	log.Println("Token for user", userID, "was revoked, removed it")
+	log.Das("token.revoked", (&log.DasFields{}).SetStrings(userID))
+	q.metrics.add(&q.metrics.revoked)
+	if q.onRevoked != nil {
+		q.onRevoked(userID)
+	}
+}
+
+// purge removes the given token, returning false if it's no longer stored (e.g. a
+// new primary user associated while it was being refreshed)
+func (q *tokenQueue) purge(tok identity.Token) bool {
+	q.storeMu.Lock()
+	defer q.storeMu.Unlock()
+
+	primary := q.identityProvider.GetToken()
+	multi, isMulti := q.identityProvider.(identity.MultiUserProvider)
+	stored := primary
+	if isMulti {
+		stored = multi.GetUserToken(tok.UserID())
+	}
+	if stored == nil || stored.String() != tok.String() {
+		return false
+	}
+
+	var err error
+	if isMulti {
+		err = multi.RemoveUser(tok.UserID())
+	} else {
+		err = q.identityProvider.RemoveToken()
+	}
+	if err != nil {
+		log.Println("Error removing revoked token:", err)
+	}
+	if primary != nil && primary.UserID() == tok.UserID() {
+		if err := os.Remove(gatewayTokensFile); err != nil && !os.IsNotExist(err) {
+			log.Println("Error removing client tokens:", err)
+		}
+	}
+	return true
+}
+
+// parseErrorCode returns the error code for a token from the server that couldn't
+// be parsed and stored
+func parseErrorCode(err error) cloud.TokenError {
+	if _, ok := err.(*identity.VerificationError); ok {
+		return cloud.TokenError_InvalidSignature
+	}
+	return cloud.TokenError_InvalidToken
+}
+
 func authErrorResp(code cloud.TokenError) *cloud.TokenResponse {
 	return cloud.NewTokenResponseWithAuth(&cloud.AuthResponse{Error: code})
 }
@@ -122,15 +429,15 @@ func sessionMetadata(sessionToken string) credentials.PerRPCCredentials {
 	return metadata
 }
 
-func (q *tokenQueue) handleAuthRequest(session string) (*cloud.TokenResponse, error) {
+func (q *tokenQueue) handleAuthRequest(ctx context.Context, session string) (*cloud.TokenResponse, error) {
 	metadata := sessionMetadata(session)
 	requester := func(c *conn) (*pb.TokenBundle, error) {
-		return c.associatePrimary(session)
+		return c.associatePrimary(ctx, session)
 	}
 	return q.authRequester(metadata, requester, true)
 }
 
-func (q *tokenQueue) handleSecondaryAuthRequest(req *cloud.SecondaryAuthRequest) (*cloud.TokenResponse, error) {
+func (q *tokenQueue) handleSecondaryAuthRequest(ctx context.Context, req *cloud.SecondaryAuthRequest) (*cloud.TokenResponse, error) {
 	existing := q.identityProvider.GetToken()
 	if existing == nil {
 		return authErrorResp(cloud.TokenError_NullToken), nil
@@ -138,15 +445,15 @@ func (q *tokenQueue) handleSecondaryAuthRequest(req *cloud.SecondaryAuthRequest)
 
 	metadata := tokenMetadata(existing.String())
 	requester := func(c *conn) (*pb.TokenBundle, error) {
-		return c.associateSecondary(req.SessionToken, req.ClientName, req.AppId)
+		return c.associateSecondary(ctx, req.SessionToken, req.ClientName, req.AppId)
 	}
 	return q.authRequester(metadata, requester, false)
 }
 
-func (q *tokenQueue) handleReassociateRequest(req *cloud.ReassociateRequest) (*cloud.TokenResponse, error) {
+func (q *tokenQueue) handleReassociateRequest(ctx context.Context, req *cloud.ReassociateRequest) (*cloud.TokenResponse, error) {
 	metadata := sessionMetadata(req.SessionToken)
 	requester := func(c *conn) (*pb.TokenBundle, error) {
-		return c.reassociatePrimary(req.ClientName, req.AppId)
+		return c.reassociatePrimary(ctx, req.ClientName, req.AppId)
 	}
 	return q.authRequester(metadata, requester, false)
 }
@@ -169,9 +476,9 @@ func (q *tokenQueue) authRequester(creds credentials.PerRPCCredentials,
 		return authErrorResp(cloud.TokenError_Connection), err
 	}
 	if parseJwt {
-		_, err = q.identityProvider.ParseAndStoreToken(bundle.Token)
+		_, err = q.storeToken(bundle.Token)
 		if err != nil {
-			return authErrorResp(cloud.TokenError_InvalidToken), err
+			return authErrorResp(parseErrorCode(err)), err
 		}
 	}
 	return cloud.NewTokenResponseWithAuth(&cloud.AuthResponse{
@@ -188,7 +495,7 @@ func (q *tokenQueue) routine(ctx context.Context) {
 		case req = <-q.queue:
 			break
 		}
-		if err := q.handleRequest(&req); err != nil {
+		if err := q.process(&req); err != nil {
 			log.Println("Token queue error:", err)
 		}
 	}
@@ -0,0 +1,137 @@
+package token
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/digital-dream-labs/vector-cloud/internal/clad/cloud"
+	"github.com/digital-dream-labs/vector-cloud/internal/token/identity"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newStalledQueue returns a queue whose auth requests are never processed, as if
+// the server were hanging
+func newStalledQueue(tok testToken) *tokenQueue {
+	return &tokenQueue{
+		queue:            make(chan request, maxQueueDepth),
+		refreshes:        make(map[string]*refreshCall),
+		denied:           make(map[string]deniedToken),
+		identityProvider: &testProvider{tok: tok},
+		errorHandler:     NewBackoffHandler(nil),
+	}
+}
+
+func TestQueuePriority(t *testing.T) {
+	q := newStalledQueue(testToken{time.Now()})
+	authRequest := cloud.NewTokenRequestWithAuth(&cloud.AuthRequest{SessionToken: "session"})
+
+	// auth requests give up when their context does
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	resp, err := q.handleRequest(ctx, authRequest)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Equal(t, cloud.TokenError_Connection, resp.GetAuth().Error)
+
+	// once the queue is full, more are turned away right away
+	for len(q.queue) < maxQueueDepth {
+		q.queue <- request{ctx: context.Background(), m: authRequest, ch: make(chan *response, 1)}
+	}
+	_, err = q.handleRequest(context.Background(), authRequest)
+	assert.Equal(t, errQueueFull, err)
+
+	// JWT requests don't wait behind them
+	resp, err = q.handleRequest(context.Background(), cloud.NewTokenRequestWithJwt(&cloud.JwtRequest{}))
+	require.NoError(t, err)
+	assert.Equal(t, "token", resp.GetJwt().JwtToken)
+
+	var buf bytes.Buffer
+	q.metrics.writePrometheus(&buf, len(q.queue))
+	for _, line := range []string{"token_queue_depth 8", "token_cached_total 1", "token_rejected_total 1", "token_cancelled_total 1"} {
+		assert.True(t, strings.Contains(buf.String(), line+"\n"), line)
+	}
+}
+
+func TestQueueCoalescing(t *testing.T) {
+	tok := testToken{time.Now()}
+	q := newStalledQueue(tok)
+
+	// a refresh of the user's token is already in progress
+	call := &refreshCall{done: make(chan struct{})}
+	q.refreshes[tok.UserID()] = call
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := q.handleRequest(context.Background(), cloud.NewTokenRequestWithJwt(&cloud.JwtRequest{ForceRefresh: true}))
+			assert.NoError(t, err)
+			assert.Equal(t, "token", resp.GetJwt().JwtToken)
+		}()
+	}
+	// wait for all of them to join it before it completes
+	for {
+		q.metrics.mu.Lock()
+		coalesced := q.metrics.coalesced
+		q.metrics.mu.Unlock()
+		if coalesced == 3 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	call.tok = tok
+	close(call.done)
+	wg.Wait()
+	assert.Equal(t, uint64(0), q.metrics.refreshes)
+}
+
+func TestStoreUserToken(t *testing.T) {
+	q := newStalledQueue(testToken{time.Now()})
+	info := identity.TokenInfo{
+		Id:          "token",
+		Type:        "user+robot",
+		UserId:      "other",
+		RequestorId: "vic:00000000",
+		IssuedAt:    time.Now().UTC(),
+		ExpiresAt:   time.Now().UTC().Add(24 * time.Hour),
+	}
+	token, err := info.JwtToken(jwt.SigningMethodNone).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	require.NoError(t, err)
+
+	// a token refreshed for one user that belongs to another is never stored
+	_, code, err := q.storeUserToken(token, testToken{})
+	assert.Error(t, err)
+	assert.Equal(t, cloud.TokenError_WrongAccount, code)
+	assert.Equal(t, "token", q.identityProvider.GetToken().String())
+
+	// nor is one refreshed after another user's token was stored in its place
+	q.identityProvider = &testProvider{tok: userToken{user: "user2", token: "user2's"}}
+	_, code, err = q.storeUserToken(token, userToken{user: "other", token: "stale"})
+	assert.Error(t, err)
+	assert.Equal(t, cloud.TokenError_WrongAccount, code)
+	assert.Equal(t, "user2's", q.identityProvider.GetToken().String())
+
+	// and one refreshed after the user's own token was replaced returns the newer one
+	q.identityProvider = &testProvider{tok: userToken{user: "other", token: "newer"}}
+	tok, code, err := q.storeUserToken(token, userToken{user: "other", token: "stale"})
+	assert.NoError(t, err)
+	assert.Equal(t, cloud.TokenError_NoError, code)
+	assert.Equal(t, "newer", tok.String())
+}
+
+// userToken is a token of the given user
+type userToken struct {
+	testToken
+	user  string
+	token string
+}
+
+func (t userToken) String() string { return t.token }
+func (t userToken) UserID() string { return t.user }
@@ -46,12 +46,8 @@ func refreshRoutine(ctx context.Context, tokenQueue *tokenQueue, identityProvide
 		refreshDuration := tok.RefreshTime().Sub(time.Now()) + 10*time.Second
 		if refreshDuration <= 0 {
 			log.Println("token refresh: refreshing")
-			ch := make(chan *response)
-			tokenQueue.queue <- request{m: cloud.NewTokenRequestWithJwt(&cloud.JwtRequest{}), ch: ch}
-			msg := <-ch
-			close(ch)
-			if msg.err != nil {
-				log.Println("Refresh routine error:", msg.err)
+			if _, err := tokenQueue.handleRequest(ctx, cloud.NewTokenRequestWithJwt(&cloud.JwtRequest{})); err != nil {
+				log.Println("Refresh routine error:", err)
 			}
 			log.Println("token refresh: refresh done, sleeping", tokSleep)
 			if util.SleepSelect(tokSleep, ctx.Done()) {
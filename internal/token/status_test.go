@@ -0,0 +1,84 @@
+package token
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/digital-dream-labs/vector-cloud/internal/clad/cloud"
+	"github.com/digital-dream-labs/vector-cloud/internal/token/identity"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/credentials"
+)
+
+type testToken struct {
+	issued time.Time
+}
+
+func (t testToken) IssuedAt() time.Time    { return t.issued }
+func (t testToken) RefreshTime() time.Time { return t.issued.Add(21 * time.Hour) }
+func (t testToken) ExpiresAt() time.Time   { return t.issued.Add(24 * time.Hour) }
+func (t testToken) String() string         { return "token" }
+func (t testToken) UserID() string         { return "user" }
+func (t testToken) RequestorID() string    { return "vic:00000000" }
+
+type testProvider struct {
+	tok identity.Token
+}
+
+func (p *testProvider) Init() error { return nil }
+func (p *testProvider) ParseAndStoreToken(token string) (identity.Token, error) {
+	return nil, errors.New("not implemented")
+}
+func (p *testProvider) GetToken() identity.Token                               { return p.tok }
+func (p *testProvider) RemoveToken() error                                     { p.tok = nil; return nil }
+func (p *testProvider) CertCommonName() string                                 { return "vic:00000000" }
+func (p *testProvider) TransportCredentials() credentials.TransportCredentials { return nil }
+
+func TestStatus(t *testing.T) {
+	issued := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	var s Server
+	require.NoError(t, s.Init(&testProvider{tok: testToken{issued}}))
+
+	s.stsCredentials().add(issued.Add(time.Hour).Format(time.RFC3339), testCredentials)
+	s.queue.lastError = errors.New("connection refused")
+	s.queue.lastErrorTime = issued.Add(time.Minute)
+	s.backoffHandler.denied = true
+
+	// status requests don't go through the queue, which isn't running
+	resp, err := s.handleRequest(context.Background(), cloud.NewTokenRequestWithStatus(&cloud.Void{}))
+	require.NoError(t, err)
+
+	// and make it through CLAD intact
+	var buf bytes.Buffer
+	require.NoError(t, resp.Pack(&buf))
+	assert.Equal(t, int(resp.Size()), buf.Len())
+	var unpacked cloud.TokenResponse
+	require.NoError(t, unpacked.Unpack(&buf))
+	status := unpacked.GetStatus()
+	require.NotNil(t, status)
+
+	assert.Equal(t, "user", status.UserId)
+	assert.Equal(t, "vic:00000000", status.RequestorId)
+	assert.Equal(t, issued.Unix(), status.IssuedAt)
+	assert.Equal(t, issued.Add(21*time.Hour).Unix(), status.RefreshAt)
+	assert.Equal(t, issued.Add(24*time.Hour).Unix(), status.ExpiresAt)
+	assert.Equal(t, issued.Add(time.Hour).Unix(), status.StsExpiresAt)
+	assert.False(t, status.BackingOff)
+	assert.True(t, status.Denied)
+	assert.Equal(t, "connection refused", status.LastError)
+	assert.Equal(t, issued.Add(time.Minute).Unix(), status.LastErrorTime)
+
+	// nothing known yet
+	var empty Server
+	require.NoError(t, empty.Init(&testProvider{}))
+	status = empty.status()
+	assert.Equal(t, "", status.UserId)
+	assert.Equal(t, int64(0), status.ExpiresAt)
+	assert.Equal(t, int64(0), status.StsExpiresAt)
+	assert.Equal(t, "", status.LastError)
+}
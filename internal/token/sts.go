@@ -1,12 +1,18 @@
 package token
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
 	"time"
 
 	"github.com/digital-dream-labs/vector-cloud/internal/config"
 	"github.com/digital-dream-labs/vector-cloud/internal/log"
+	"github.com/digital-dream-labs/vector-cloud/internal/util"
 
 	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/cenkalti/backoff"
 	testtime "github.com/digital-dream-labs/vector-cloud/internal/testing/time"
 )
 
@@ -16,19 +22,38 @@ var testableTime = testtime.New()
 const TokenRefreshWindow = time.Hour
 
 type stsCredentialsCache struct {
+	// mu guards the fields below; it's never held while talking to the token service
+	mu          sync.Mutex
 	expiration  time.Time
 	credentials *credentials.Credentials
+	// userID is the user whose token the credentials were obtained with
+	userID string
+	// pending is the fetch in progress, if any, which concurrent callers wait on
+	// instead of each making their own
+	pending *stsFetch
+	// generation is incremented by clear, so that a fetch started before it doesn't
+	// put credentials back
+	generation int
+	// request gets new credentials from the token service, returning their expiration
+	request func(Accessor) (string, *credentials.Credentials, error)
 }
 
-func (c *stsCredentialsCache) add(expiration string, credentials *credentials.Credentials) {
+type stsFetch struct {
+	done        chan struct{}
+	credentials *credentials.Credentials
+	err         error
+}
+
+// add and expired must be called with mu held
+func (c *stsCredentialsCache) add(expiration string, credentials *credentials.Credentials) error {
 	expirationTime, err := time.Parse(time.RFC3339, expiration)
 	if err != nil {
-		log.Printf("Error parsing StsToken exiration timestamp timestamp %q: %v\n", expiration, err)
-		return
+		return fmt.Errorf("error parsing StsToken expiration timestamp %q: %v", expiration, err)
 	}
 
 	c.expiration = expirationTime
 	c.credentials = credentials
+	return nil
 }
 
 func (c *stsCredentialsCache) expired() bool {
@@ -40,29 +65,121 @@ func (c *stsCredentialsCache) expired() bool {
 	return testableTime.Now().UTC().After(c.expiration.Add(-TokenRefreshWindow))
 }
 
-// Currently STS tokens are only refreshed on demand since they are rarely
-// used. The only current use (uploading of log files) is not time critical.
-// In the future we may implement a pro-active refreshing mechanism (similar
-// to the one in refresher.go).
+// untilRefresh returns how long until the cached credentials enter their refresh
+// window, or false if there are no cached credentials to keep fresh
+func (c *stsCredentialsCache) untilRefresh() (time.Duration, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.credentials == nil {
+		return 0, false
+	}
+	return c.expiration.Add(-TokenRefreshWindow).Sub(testableTime.Now().UTC()), true
+}
+
+// expiresAt returns when the cached credentials expire, or the zero time if there
+// are none
+func (c *stsCredentialsCache) expiresAt() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.credentials == nil {
+		return time.Time{}
+	}
+	return c.expiration
+}
+
+// clear discards the cached credentials, along with those of a fetch in progress
+func (c *stsCredentialsCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.expiration = time.Time{}
+	c.credentials = nil
+	c.userID = ""
+	c.generation++
+}
+
+// getStsCredentials returns the cached credentials, fetching new ones from the
+// token service if they're missing, about to expire, or belong to a different user
+// than the active one. While a fetch is in progress, credentials that are about to
+// expire but haven't yet are returned without waiting for it.
 func (c *stsCredentialsCache) getStsCredentials(accessor Accessor) (*credentials.Credentials, error) {
-	if !c.expired() && c.credentials != nil {
-		return c.credentials, nil
+	userID := accessor.UserID()
+	c.mu.Lock()
+	current := c.credentials != nil && c.userID == userID
+	fresh := current && !c.expired()
+	usable := current && c.pending != nil && testableTime.Now().UTC().Before(c.expiration)
+	creds := c.credentials
+	c.mu.Unlock()
+	if fresh || usable {
+		return creds, nil
 	}
+	return c.fetch(accessor)
+}
 
+// refresh fetches new credentials from the token service regardless of the state
+// of the cache
+func (c *stsCredentialsCache) refresh(accessor Accessor) (*credentials.Credentials, error) {
+	return c.fetch(accessor)
+}
+
+// fetch gets new credentials from the token service and caches them, or waits for
+// the fetch already in progress
+func (c *stsCredentialsCache) fetch(accessor Accessor) (*credentials.Credentials, error) {
+	c.mu.Lock()
+	if f := c.pending; f != nil {
+		c.mu.Unlock()
+		<-f.done
+		return f.credentials, f.err
+	}
+	f := &stsFetch{done: make(chan struct{})}
+	c.pending = f
+	generation := c.generation
+	request := c.request
+	c.mu.Unlock()
+
+	if request == nil {
+		request = requestStsCredentials
+	}
+	userID := accessor.UserID()
+	expiration, creds, err := request(accessor)
+
+	c.mu.Lock()
+	if err == nil && generation != c.generation {
+		err = errors.New("STS credentials were cleared while being fetched")
+	}
+	if err == nil {
+		if err = c.add(expiration, creds); err == nil {
+			c.userID = userID
+		}
+	}
+	if err == nil {
+		f.credentials = creds
+	}
+	f.err = err
+	c.pending = nil
+	c.mu.Unlock()
+	close(f.done)
+	return f.credentials, f.err
+}
+
+// requestStsCredentials gets new credentials from the token service, returning
+// them along with their expiration
+func requestStsCredentials(accessor Accessor) (string, *credentials.Credentials, error) {
 	perRPCCreds, err := accessor.Credentials()
 	if err != nil {
-		return nil, err
+		return "", nil, err
 	}
 
 	client, err := newConn(accessor.IdentityProvider(), config.Env.Token, perRPCCreds)
 	if err != nil {
-		return nil, err
+		return "", nil, err
 	}
 	defer client.Close()
 
-	bundle, err := client.refreshStsCredentials()
+	ctx, cancel := context.WithTimeout(context.Background(), defaultRequestTimeout)
+	defer cancel()
+	bundle, err := client.refreshStsCredentials(ctx)
 	if err != nil {
-		return nil, err
+		return "", nil, err
 	}
 
 	stsToken := bundle.GetStsToken()
@@ -72,8 +189,58 @@ func (c *stsCredentialsCache) getStsCredentials(accessor Accessor) (*credentials
 		SecretAccessKey: stsToken.SecretAccessKey,
 		SessionToken:    stsToken.SessionToken,
 	})
+	return stsToken.GetExpiration(), awsCredentials, nil
+}
 
-	c.add(stsToken.GetExpiration(), awsCredentials)
+func initStsRefresher(ctx context.Context, cache *stsCredentialsCache, accessor Accessor) {
+	go stsRefreshRoutine(ctx, cache, accessor)
+}
 
-	return awsCredentials, nil
+// stsRefreshRoutine keeps STS credentials warm once something has requested them,
+// refreshing them before they enter TokenRefreshWindow so that callers never have to
+// wait on the token service
+func stsRefreshRoutine(ctx context.Context, cache *stsCredentialsCache, accessor Accessor) {
+	const idleSleep = 5 * time.Minute
+	// credentials that are already in their refresh window when they arrive, e.g.
+	// because they're short-lived or the clock is off, are refreshed no more often
+	// than this
+	const minRefreshInterval = time.Minute
+
+	bo := backoff.NewExponentialBackOff()
+	bo.InitialInterval = 10 * time.Second
+	bo.Multiplier = 2
+	bo.MaxInterval = 5 * time.Minute
+	// keep retrying as long as there's anything to refresh
+	bo.MaxElapsedTime = 0
+
+	for {
+		wait, ok := cache.untilRefresh()
+		if !ok {
+			// nobody has needed credentials yet
+			if util.SleepSelect(idleSleep, ctx.Done()) {
+				return
+			}
+			continue
+		}
+		if wait > 0 {
+			if util.SleepSelect(wait, ctx.Done()) {
+				return
+			}
+			continue
+		}
+
+		if _, err := cache.refresh(accessor); err != nil {
+			retry := bo.NextBackOff()
+			log.Println("STS refresh error, retrying in", retry, "-", err)
+			if util.SleepSelect(retry, ctx.Done()) {
+				return
+			}
+			continue
+		}
+		bo.Reset()
+		log.Println("STS credentials refreshed")
+		if util.SleepSelect(minRefreshInterval, ctx.Done()) {
+			return
+		}
+	}
 }
@@ -64,7 +64,8 @@ func (s *StsSuite) TestStsCaching() {
 
 	for _, test := range cacheTests {
 		if test.expirationString != "" {
-			s.stsTestCache.add(test.expirationString, testCredentials)
+			err := s.stsTestCache.add(test.expirationString, testCredentials)
+			s.Equal(test.expectedCredentials == nil, err != nil, test.name)
 		}
 
 		s.Equal(test.isExpired, s.stsTestCache.expired(), test.name)
@@ -106,6 +107,94 @@ func (s *StsSuite) TestStsTokenExpiration() {
 	}
 }
 
+func (s *StsSuite) TestStsUntilRefresh() {
+	_, ok := s.stsTestCache.untilRefresh()
+	s.False(ok, "empty-cache")
+
+	testableTime := testableTime.(testtime.TestableTime)
+	s.stsTestCache.add(time.Now().UTC().Add(3*time.Hour).Format(time.RFC3339), testCredentials)
+
+	testableTime.WithNowDelta(0, func() {
+		wait, ok := s.stsTestCache.untilRefresh()
+		s.True(ok)
+		s.InDelta(float64(3*time.Hour-TokenRefreshWindow), float64(wait), float64(time.Minute))
+	})
+	testableTime.WithNowDelta(3*time.Hour, func() {
+		wait, ok := s.stsTestCache.untilRefresh()
+		s.True(ok)
+		s.True(wait <= 0, "within-refresh-window")
+	})
+}
+
+func (s *StsSuite) TestStsCacheShared() {
+	server := new(Server)
+	a1 := GetAccessor(nil, server).(*accessor)
+	a2 := GetAccessor(nil, server).(*accessor)
+	s.True(a1.stsCache == a2.stsCache)
+
+	a3 := GetAccessor(nil, nil).(*accessor)
+	s.NotNil(a3.stsCache)
+	s.False(a1.stsCache == a3.stsCache)
+}
+
+// userAccessor is an Accessor for the given user, which is all the cache asks of it
+// when fetching is stubbed out
+type userAccessor struct {
+	Accessor
+	userID string
+}
+
+func (a userAccessor) UserID() string { return a.userID }
+
+func (s *StsSuite) TestStsFetchNotBlocking() {
+	fetched := make(chan struct{}, 10)
+	release := make(chan struct{})
+	expiration := time.Now().Add(3 * time.Hour).UTC().Format(time.RFC3339)
+	s.stsTestCache.request = func(Accessor) (string, *credentials.Credentials, error) {
+		fetched <- struct{}{}
+		<-release
+		return expiration, testCredentials, nil
+	}
+	accessor := userAccessor{userID: "user"}
+
+	// concurrent callers share one fetch
+	results := make(chan *credentials.Credentials, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			creds, err := s.stsTestCache.getStsCredentials(accessor)
+			s.NoError(err)
+			results <- creds
+		}()
+	}
+	<-fetched
+	close(release)
+	s.Equal(testCredentials, <-results)
+	s.Equal(testCredentials, <-results)
+	s.Len(fetched, 0)
+
+	// credentials in their refresh window are still served while a refresh is made
+	testableTime := testableTime.(testtime.TestableTime)
+	release = make(chan struct{})
+	refreshed := make(chan struct{})
+	go func() {
+		s.stsTestCache.refresh(accessor)
+		close(refreshed)
+	}()
+	<-fetched
+	testableTime.WithNowDelta(150*time.Minute, func() {
+		creds, err := s.stsTestCache.getStsCredentials(accessor)
+		s.NoError(err)
+		s.Equal(testCredentials, creds)
+	})
+
+	// and clearing them doesn't wait for it, or let it put them back
+	s.stsTestCache.clear()
+	close(release)
+	<-refreshed
+	_, ok := s.stsTestCache.untilRefresh()
+	s.False(ok)
+}
+
 func TestStsSuite(t *testing.T) {
 	suite.Run(t, new(StsSuite))
 }
@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/digital-dream-labs/vector-cloud/internal/clad/cloud"
 
@@ -19,10 +21,20 @@ type Server struct {
 	queue            tokenQueue
 	identityProvider identity.Provider
 	backoffHandler   *backoffHandler
+	stsOnce          sync.Once
+	stsCache         *stsCredentialsCache
 }
 
 type RequestHandler interface {
-	handleRequest(req *cloud.TokenRequest) (*cloud.TokenResponse, error)
+	handleRequest(ctx context.Context, req *cloud.TokenRequest) (*cloud.TokenResponse, error)
+}
+
+// stsCredentials returns the STS credentials cache shared by the server's accessors
+func (s *Server) stsCredentials() *stsCredentialsCache {
+	s.stsOnce.Do(func() {
+		s.stsCache = new(stsCredentialsCache)
+	})
+	return s.stsCache
 }
 
 func (s *Server) ErrorListener() util.ErrorListener {
@@ -60,12 +72,20 @@ func (s *Server) Run(ctx context.Context, optionValues ...Option) {
 		}
 	}
 
+	s.queue.onRevoked = func(userID string) {
+		// STS credentials obtained with the revoked token shouldn't outlive it
+		s.stsCredentials().clear()
+		if opts.onRevoked != nil {
+			opts.onRevoked(userID)
+		}
+	}
 	if err := s.queue.init(ctx, s.backoffHandler, s.identityProvider); err != nil {
 		log.Println("Error initializing request queue:", err)
 		return
 	}
 
 	initRefresher(ctx, &s.queue, s.identityProvider)
+	initStsRefresher(ctx, s.stsCredentials(), GetAccessor(s.identityProvider, s))
 
 	if opts.server {
 		socketName := "token_server"
@@ -80,14 +100,14 @@ func (s *Server) Run(ctx context.Context, optionValues ...Option) {
 		}
 
 		for c := range serv.NewConns() {
-			go s.handleConn(c)
+			go s.handleConn(ctx, c)
 		}
 	}
 	// if server isn't requested, our background routines will handle requests
 	// and there's no need for this function to block
 }
 
-func (s *Server) handleConn(c ipc.Conn) {
+func (s *Server) handleConn(ctx context.Context, c ipc.Conn) {
 	for {
 		buf := c.ReadBlock()
 		// TODO: will this ever close?
@@ -100,7 +120,7 @@ func (s *Server) handleConn(c ipc.Conn) {
 			continue
 		}
 
-		resp, err := s.handleRequest(&msg)
+		resp, err := s.handleRequest(ctx, &msg)
 		if err != nil {
 			log.Println("Error handling token request:", err)
 		}
@@ -116,13 +136,52 @@ func (s *Server) handleConn(c ipc.Conn) {
 }
 
 // HandleRequest will process the given request and return a response. It may block,
-// either due to waiting for other requests to process or due to waiting for gRPC.
-func (s *Server) handleRequest(m *cloud.TokenRequest) (*cloud.TokenResponse, error) {
-	req := request{m: m, ch: make(chan *response)}
-	defer close(req.ch)
-	s.queue.queue <- req
-	resp := <-req.ch
-	return resp.resp, resp.err
+// either due to waiting for other requests to process or due to waiting for gRPC, until
+// ctx is done.
+func (s *Server) handleRequest(ctx context.Context, m *cloud.TokenRequest) (*cloud.TokenResponse, error) {
+	// status requests are answered directly so they still work when the queue is
+	// stuck waiting on the server, which is when they're most useful
+	if m.Tag() == cloud.TokenRequestTag_Status {
+		return cloud.NewTokenResponseWithStatus(s.status()), nil
+	}
+	return s.queue.handleRequest(ctx, m)
+}
+
+// maxStatusError is the longest error message that fits in a StatusResponse
+const maxStatusError = 255
+
+// status reports the state of the service's credentials; times are in seconds since
+// the epoch, or 0 if unknown
+func (s *Server) status() *cloud.StatusResponse {
+	unix := func(t time.Time) int64 {
+		if t.IsZero() {
+			return 0
+		}
+		return t.Unix()
+	}
+
+	var resp cloud.StatusResponse
+	if s.identityProvider != nil {
+		if tok := s.identityProvider.GetToken(); tok != nil {
+			resp.UserId = tok.UserID()
+			resp.RequestorId = tok.RequestorID()
+			resp.IssuedAt = unix(tok.IssuedAt())
+			resp.RefreshAt = unix(tok.RefreshTime())
+			resp.ExpiresAt = unix(tok.ExpiresAt())
+		}
+	}
+	resp.StsExpiresAt = unix(s.stsCredentials().expiresAt())
+	if s.backoffHandler != nil {
+		resp.BackingOff, resp.Denied = s.backoffHandler.state()
+	}
+	if errTime, err := s.queue.lastErr(); err != nil {
+		resp.LastError = err.Error()
+		if len(resp.LastError) > maxStatusError {
+			resp.LastError = resp.LastError[:maxStatusError]
+		}
+		resp.LastErrorTime = unix(errTime)
+	}
+	return &resp
 }
 
 func (s *Server) initServer(ctx context.Context, socketName string) (ipc.Server, error) {
@@ -48,6 +48,11 @@ func TimeFuncMs(function func()) float64 {
 	return float64(time.Now().Sub(callStart).Nanoseconds()) / float64(time.Millisecond/time.Nanosecond)
 }
 
+// TimeSinceMs returns the time, in milliseconds, elapsed since the given time
+func TimeSinceMs(t time.Time) float64 {
+	return float64(time.Since(t).Nanoseconds()) / float64(time.Millisecond/time.Nanosecond)
+}
+
 type chanWriter struct {
 	ch chan<- []byte
 }
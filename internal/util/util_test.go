@@ -72,6 +72,11 @@ func TestTimeFunc(t *testing.T) {
 	assert.True(t, dur >= 5.0)
 }
 
+func TestTimeSinceMs(t *testing.T) {
+	assert.True(t, util.TimeSinceMs(time.Now()) < 1)
+	assert.True(t, util.TimeSinceMs(time.Now().Add(-5*time.Millisecond)) >= 5.0)
+}
+
 // func TestSleepSelect(t *testing.T) {
 // 	ch := make(chan struct{})
 // 	assert.False(t, util.SleepSelect(time.Millisecond, ch))
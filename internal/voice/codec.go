@@ -0,0 +1,274 @@
+package voice
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sync"
+
+	"github.com/digital-dream-labs/vector-cloud/internal/clad/cloud"
+	"github.com/digital-dream-labs/vector-cloud/internal/log"
+
+	"github.com/digital-dream-labs/api-clients/chipper"
+)
+
+// Codec determines how audio is encoded before it's uploaded
+type Codec interface {
+	// Name identifies the codec in config files and logs
+	Name() string
+	// CompressOpts returns the chipper options that produce the codec's encoding
+	CompressOpts() chipper.CompressOpts
+}
+
+// RawCodec uploads uncompressed 16-bit PCM
+type RawCodec struct{}
+
+// Name implements Codec
+func (RawCodec) Name() string { return "raw" }
+
+// CompressOpts implements Codec
+func (RawCodec) CompressOpts() chipper.CompressOpts { return chipper.CompressOpts{} }
+
+// OpusCodec compresses audio with Ogg Opus
+type OpusCodec struct {
+	Profile    string
+	Bitrate    uint
+	Complexity uint
+	FrameSize  float32
+}
+
+// Opus profiles that can be selected by name
+var (
+	// OpusDefault is the profile vic-cloud has always used
+	OpusDefault = OpusCodec{Profile: "opus", Bitrate: 66 * 1024, Complexity: 0, FrameSize: 60}
+	// OpusLowBandwidth trades quality for size on constrained links
+	OpusLowBandwidth = OpusCodec{Profile: "opus-low", Bitrate: 24 * 1024, Complexity: 0, FrameSize: 60}
+	// OpusHighQuality spends more CPU and bandwidth for better recognition
+	OpusHighQuality = OpusCodec{Profile: "opus-high", Bitrate: 96 * 1024, Complexity: 5, FrameSize: 20}
+)
+
+// Name implements Codec
+func (c OpusCodec) Name() string { return c.Profile }
+
+// CompressOpts implements Codec
+func (c OpusCodec) CompressOpts() chipper.CompressOpts {
+	return chipper.CompressOpts{
+		Compress:   true,
+		Bitrate:    c.Bitrate,
+		Complexity: c.Complexity,
+		FrameSize:  c.FrameSize,
+	}
+}
+
+var codecsByName = map[string]Codec{
+	RawCodec{}.Name():       RawCodec{},
+	OpusDefault.Name():      OpusDefault,
+	OpusLowBandwidth.Name(): OpusLowBandwidth,
+	OpusHighQuality.Name():  OpusHighQuality,
+}
+
+// ErrFlacUnsupported is returned for the FLAC codec: chipper's protocol only has raw
+// PCM and Ogg Opus audio encodings, so lossless compressed uploads need server support
+// first
+var ErrFlacUnsupported = errors.New("flac codec isn't supported by chipper's protocol")
+
+// CodecByName returns the codec or Opus profile with the given name
+func CodecByName(name string) (Codec, error) {
+	if c, ok := codecsByName[name]; ok {
+		return c, nil
+	}
+	if name == "flac" {
+		return nil, ErrFlacUnsupported
+	}
+	return nil, fmt.Errorf("unknown codec %q", name)
+}
+
+// CodecConfig selects codecs by stream mode, as loaded from a JSON config file, by
+// codec name
+type CodecConfig struct {
+	Default string `json:"default"`
+	// Modes overrides Default for particular modes, keyed by "normal", "blackjack" or
+	// "knowledge_graph"
+	Modes map[string]string `json:"modes,omitempty"`
+	// AdaptiveBitrate, if set, lowers Opus bitrates when uploads are slow
+	AdaptiveBitrate *BitrateConfig `json:"adaptive_bitrate,omitempty"`
+}
+
+var modeNames = map[string]cloud.StreamType{
+	"normal":          cloud.StreamType_Normal,
+	"blackjack":       cloud.StreamType_Blackjack,
+	"knowledge_graph": cloud.StreamType_KnowledgeGraph,
+}
+
+// LoadCodecConfig reads a CodecConfig from the given JSON file and returns the
+// options that apply it
+func LoadCodecConfig(filename string) ([]Option, error) {
+	buf, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var cfg CodecConfig
+	if err := json.Unmarshal(buf, &cfg); err != nil {
+		return nil, err
+	}
+	return cfg.Options()
+}
+
+// Options returns the options that apply this config to the voice process
+func (cfg *CodecConfig) Options() ([]Option, error) {
+	var ret []Option
+	if cfg.Default != "" {
+		codec, err := CodecByName(cfg.Default)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, WithCodec(codec))
+	}
+	for name, codecName := range cfg.Modes {
+		mode, ok := modeNames[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown stream mode %q", name)
+		}
+		codec, err := CodecByName(codecName)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, WithModeCodec(mode, codec))
+	}
+	if cfg.AdaptiveBitrate != nil {
+		ret = append(ret, WithAdaptiveBitrate(*cfg.AdaptiveBitrate))
+	}
+	return ret, nil
+}
+
+// codecFor returns the codec for a stream of the given mode
+func (p *Process) codecFor(mode cloud.StreamType) Codec {
+	if codec, ok := p.opts.modeCodecs[mode]; ok {
+		return codec
+	}
+	if p.opts.codec != nil {
+		return p.opts.codec
+	}
+	// no codec configured, fall back to the compression toggle
+	if p.opts.compress {
+		return OpusDefault
+	}
+	return RawCodec{}
+}
+
+// compressOpts returns the chipper compression options for a stream of the given mode
+func (p *Process) compressOpts(mode cloud.StreamType) chipper.CompressOpts {
+	codec := p.codecFor(mode)
+	opts := codec.CompressOpts()
+	if opts.Compress && p.bitrate != nil {
+		opts.Bitrate = p.bitrate.limit(opts.Bitrate)
+	}
+	logVerbose("Using codec", codec.Name(), "at bitrate", opts.Bitrate)
+	return opts
+}
+
+// adaptBitrate adjusts the upload bitrate based on the send latency observed so far;
+// it's called once per voice request, before the request's options are chosen
+func (p *Process) adaptBitrate(mode cloud.StreamType) {
+	if p.bitrate == nil {
+		return
+	}
+	if opts := p.codecFor(mode).CompressOpts(); opts.Compress {
+		p.bitrate.adapt(opts.Bitrate)
+	}
+}
+
+// Default values for BitrateConfig fields left at zero
+const (
+	DefaultMinBitrate    = 16 * 1024
+	DefaultBitrateStep   = 8 * 1024
+	DefaultSlowSendMs    = 150
+	DefaultFastSendMs    = 50
+	bitrateSmoothing     = 0.2
+	maxBitrateForAdapter = ^uint(0)
+)
+
+// BitrateConfig configures adaptation of the Opus bitrate to upload latency. The time
+// each audio chunk takes to send is averaged; when it's above SlowSendMs, the bitrate
+// of the next request is reduced by Step, and when it's below FastSendMs, it's raised
+// by Step, back up to the codec's own bitrate.
+type BitrateConfig struct {
+	Min        uint    `json:"min"`
+	Step       uint    `json:"step"`
+	SlowSendMs float64 `json:"slow_send_ms"`
+	FastSendMs float64 `json:"fast_send_ms"`
+}
+
+type bitrateAdapter struct {
+	cfg     BitrateConfig
+	mu      sync.Mutex
+	current uint
+	avgMs   float64
+}
+
+func newBitrateAdapter(cfg BitrateConfig) *bitrateAdapter {
+	if cfg.Min == 0 {
+		cfg.Min = DefaultMinBitrate
+	}
+	if cfg.Step == 0 {
+		cfg.Step = DefaultBitrateStep
+	}
+	if cfg.SlowSendMs <= 0 {
+		cfg.SlowSendMs = DefaultSlowSendMs
+	}
+	if cfg.FastSendMs <= 0 {
+		cfg.FastSendMs = DefaultFastSendMs
+	}
+	return &bitrateAdapter{cfg: cfg, current: maxBitrateForAdapter}
+}
+
+// observe records how long sending a chunk of audio took
+func (b *bitrateAdapter) observe(sendMs float64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.avgMs == 0 {
+		b.avgMs = sendMs
+	} else {
+		b.avgMs += (sendMs - b.avgMs) * bitrateSmoothing
+	}
+}
+
+// limit returns the bitrate the next request should use, given the codec's bitrate
+func (b *bitrateAdapter) limit(codecBitrate uint) uint {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.current > codecBitrate {
+		return codecBitrate
+	}
+	return b.current
+}
+
+// adapt steps the bitrate down if sends have been slow, or back up towards the
+// codec's bitrate if they've been fast
+func (b *bitrateAdapter) adapt(codecBitrate uint) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.current > codecBitrate {
+		b.current = codecBitrate
+	}
+	prev := b.current
+	switch {
+	case b.avgMs > b.cfg.SlowSendMs && b.current > b.cfg.Min:
+		if b.current-b.cfg.Min < b.cfg.Step {
+			b.current = b.cfg.Min
+		} else {
+			b.current -= b.cfg.Step
+		}
+	case b.avgMs != 0 && b.avgMs < b.cfg.FastSendMs && b.current < codecBitrate:
+		b.current += b.cfg.Step
+		if b.current > codecBitrate {
+			b.current = codecBitrate
+		}
+	}
+	if b.current != prev {
+		log.Println("Adjusting upload bitrate from", prev, "to", b.current, "after average send of",
+			int(b.avgMs), "ms")
+		log.Das("voice.bitrate", (&log.DasFields{}).SetInts(int(b.current), int(b.avgMs)))
+	}
+}
@@ -0,0 +1,66 @@
+package voice
+
+import (
+	"testing"
+
+	"github.com/digital-dream-labs/vector-cloud/internal/clad/cloud"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCodecSelection(t *testing.T) {
+	// with nothing configured, the compression toggle decides
+	p := &Process{}
+	assert.Equal(t, RawCodec{}, p.codecFor(cloud.StreamType_Normal))
+	p.opts.compress = true
+	assert.Equal(t, OpusDefault, p.codecFor(cloud.StreamType_Normal))
+
+	cfg := CodecConfig{
+		Default: "raw",
+		Modes:   map[string]string{"knowledge_graph": "opus-low"},
+	}
+	opts, err := cfg.Options()
+	require.NoError(t, err)
+	for _, o := range opts {
+		o(&p.opts)
+	}
+	assert.Equal(t, RawCodec{}, p.codecFor(cloud.StreamType_Normal))
+	assert.Equal(t, OpusLowBandwidth, p.codecFor(cloud.StreamType_KnowledgeGraph))
+
+	cfg.Default = "flac"
+	_, err = cfg.Options()
+	assert.Equal(t, ErrFlacUnsupported, err)
+	cfg.Default = "mp3"
+	_, err = cfg.Options()
+	assert.Error(t, err)
+}
+
+func TestAdaptiveBitrate(t *testing.T) {
+	b := newBitrateAdapter(BitrateConfig{Min: 20, Step: 10, SlowSendMs: 100, FastSendMs: 20})
+	// no observations yet, keep the codec's bitrate
+	b.adapt(64)
+	assert.Equal(t, uint(64), b.limit(64))
+
+	// reading the limit doesn't change it
+	b.observe(300)
+	assert.Equal(t, uint(64), b.limit(64))
+	assert.Equal(t, uint(64), b.limit(64))
+
+	// each request steps it down
+	for _, want := range []uint{54, 44, 34, 24, 20, 20} {
+		b.adapt(64)
+		assert.Equal(t, want, b.limit(64))
+	}
+	// and codecs with a lower bitrate of their own keep it
+	assert.Equal(t, uint(16), b.limit(16))
+
+	// sends speed back up; the average recovers gradually
+	for i := 0; i < 30; i++ {
+		b.observe(1)
+	}
+	for _, want := range []uint{30, 40, 50, 60, 64, 64} {
+		b.adapt(64)
+		assert.Equal(t, want, b.limit(64))
+	}
+}
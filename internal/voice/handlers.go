@@ -0,0 +1,12 @@
+package voice
+
+import "net/http"
+
+var devHandlers func(*http.ServeMux)
+
+// GetDevHandlers adds the voice process's handlers to the dev HTTP server
+func GetDevHandlers(s *http.ServeMux) {
+	if devHandlers != nil {
+		devHandlers(s)
+	}
+}
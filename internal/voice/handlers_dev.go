@@ -0,0 +1,21 @@
+// +build !shipping
+
+package voice
+
+import (
+	"net/http"
+
+	"github.com/digital-dream-labs/vector-cloud/internal/voice/stream"
+)
+
+func init() {
+	devHandlers = func(s *http.ServeMux) {
+		s.HandleFunc("/voice/metrics", metricsHandler)
+	}
+}
+
+// metricsHandler serves voice metrics in the Prometheus text format
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	stream.DefaultMetrics.WritePrometheus(w)
+}
@@ -0,0 +1,115 @@
+package voice
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	pb "github.com/digital-dream-labs/api/go/chipperpb"
+)
+
+// LocaleConfig is the JSON form of a LocaleRegistry
+type LocaleConfig struct {
+	// Languages maps a locale ("en-GB") or a bare language ("fr") to the name of the
+	// chipper language that recognizes it ("ENGLISH_UK")
+	Languages map[string]string `json:"languages"`
+	// Fallbacks maps a locale or bare language with no language of its own to another
+	// locale that should be tried in its place, e.g. "es-MX" to "es-ES"
+	Fallbacks map[string]string `json:"fallbacks,omitempty"`
+}
+
+// LocaleRegistry determines which chipper language will handle requests for a
+// given locale. A locale is looked up by its exact name, then by its bare language,
+// then by following the fallback for each of those in turn; a locale that can't be
+// resolved this way is unsupported.
+type LocaleRegistry struct {
+	languages map[string]pb.LanguageCode
+	fallbacks map[string]string
+}
+
+// NewLocaleRegistry validates the given config and returns a registry for it
+func NewLocaleRegistry(cfg LocaleConfig) (*LocaleRegistry, error) {
+	r := &LocaleRegistry{
+		languages: make(map[string]pb.LanguageCode),
+		fallbacks: make(map[string]string),
+	}
+	for locale, name := range cfg.Languages {
+		code, ok := pb.LanguageCode_value[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown language %s for locale %s", name, locale)
+		}
+		r.languages[normalizeLocale(locale)] = pb.LanguageCode(code)
+	}
+	for from, to := range cfg.Fallbacks {
+		r.fallbacks[normalizeLocale(from)] = normalizeLocale(to)
+	}
+	return r, nil
+}
+
+// LoadLocaleRegistry reads a JSON encoded LocaleConfig from the given file
+func LoadLocaleRegistry(filename string) (*LocaleRegistry, error) {
+	buf, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var cfg LocaleConfig
+	if err := json.Unmarshal(buf, &cfg); err != nil {
+		return nil, err
+	}
+	return NewLocaleRegistry(cfg)
+}
+
+// DefaultLocaleRegistry returns the registry used when none is configured, covering
+// the languages chipper has always supported
+func DefaultLocaleRegistry() *LocaleRegistry {
+	r, err := NewLocaleRegistry(LocaleConfig{
+		Languages: map[string]string{
+			"en":    pb.LanguageCode_ENGLISH_US.String(),
+			"en-US": pb.LanguageCode_ENGLISH_US.String(),
+			"en-GB": pb.LanguageCode_ENGLISH_UK.String(),
+			"en-AU": pb.LanguageCode_ENGLISH_AU.String(),
+			"fr":    pb.LanguageCode_FRENCH.String(),
+			"de":    pb.LanguageCode_GERMAN.String(),
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+// Language returns the chipper language that should handle requests for the given
+// locale, or an error if the locale isn't supported
+func (r *LocaleRegistry) Language(locale string) (pb.LanguageCode, error) {
+	norm := normalizeLocale(locale)
+	if strings.Count(norm, "-") != 1 {
+		return 0, fmt.Errorf("invalid locale string %s", locale)
+	}
+
+	visited := make(map[string]bool)
+	for cur := norm; cur != "" && !visited[cur]; {
+		visited[cur] = true
+		lang := strings.SplitN(cur, "-", 2)[0]
+		if code, ok := r.languages[cur]; ok {
+			return code, nil
+		}
+		if code, ok := r.languages[lang]; ok {
+			return code, nil
+		}
+		next, ok := r.fallbacks[cur]
+		if !ok {
+			next = r.fallbacks[lang]
+		}
+		if next != "" && next != cur {
+			logVerbose("Locale", cur, "falling back to", next)
+		}
+		cur = next
+	}
+	return 0, fmt.Errorf("unsupported locale %s", locale)
+}
+
+// normalizeLocale lowercases a locale and separates its parts with -
+func normalizeLocale(locale string) string {
+	return strings.ToLower(strings.Replace(locale, "_", "-", -1))
+}
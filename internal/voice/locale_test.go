@@ -0,0 +1,46 @@
+package voice
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	pb "github.com/digital-dream-labs/api/go/chipperpb"
+)
+
+func TestLocaleRegistry(t *testing.T) {
+	r := DefaultLocaleRegistry()
+	for locale, expected := range map[string]pb.LanguageCode{
+		"en-US": pb.LanguageCode_ENGLISH_US,
+		"en_gb": pb.LanguageCode_ENGLISH_UK,
+		"EN-AU": pb.LanguageCode_ENGLISH_AU,
+		"en-CA": pb.LanguageCode_ENGLISH_US,
+		"fr-CA": pb.LanguageCode_FRENCH,
+		"de-DE": pb.LanguageCode_GERMAN,
+	} {
+		code, err := r.Language(locale)
+		assert.NoError(t, err, locale)
+		assert.Equal(t, expected, code, locale)
+	}
+
+	// unsupported locales are no longer silently treated as English
+	_, err := r.Language("es-MX")
+	assert.Error(t, err)
+	_, err = r.Language("english")
+	assert.Error(t, err)
+
+	r, err = NewLocaleRegistry(LocaleConfig{
+		Languages: map[string]string{"en-US": "ENGLISH_US", "fr": "FRENCH"},
+		Fallbacks: map[string]string{"es-MX": "es-ES", "es": "en-US", "it-IT": "it-CH", "it-CH": "it-IT"},
+	})
+	require.NoError(t, err)
+	code, err := r.Language("es-MX")
+	assert.NoError(t, err)
+	assert.Equal(t, pb.LanguageCode_ENGLISH_US, code)
+	_, err = r.Language("it-IT")
+	assert.Error(t, err)
+
+	_, err = NewLocaleRegistry(LocaleConfig{Languages: map[string]string{"es": "SPANISH_XX"}})
+	assert.Error(t, err)
+}
@@ -1,8 +1,13 @@
 package voice
 
 import (
+	"time"
+
+	"github.com/digital-dream-labs/vector-cloud/internal/clad/cloud"
 	"github.com/digital-dream-labs/vector-cloud/internal/token"
 	"github.com/digital-dream-labs/vector-cloud/internal/util"
+	"github.com/digital-dream-labs/vector-cloud/internal/voice/stream"
+	"github.com/digital-dream-labs/vector-cloud/internal/voice/stream/localconn"
 
 	pb "github.com/digital-dream-labs/api/go/chipperpb"
 )
@@ -26,13 +31,23 @@ const (
 type Option func(o *options)
 
 type options struct {
-	compress     bool
-	chunkMs      uint
-	handler      Handler
-	saveAudio    bool
-	tokener      token.Accessor
-	requireToken bool
-	errListener  util.ErrorListener
+	compress        bool
+	chunkMs         uint
+	handler         Handler
+	saveAudio       bool
+	tokener         token.Accessor
+	requireToken    bool
+	errListener     util.ErrorListener
+	recordDir       string
+	connectFn       stream.ConnectFunc
+	vadOpts         *stream.VADOpts
+	prerollMs       uint
+	codec           Codec
+	modeCodecs      map[cloud.StreamType]Codec
+	bitrate         *BitrateConfig
+	locales         *LocaleRegistry
+	metrics         *stream.Metrics
+	metricsInterval time.Duration
 }
 
 // WithCompression sets whether compression will be performed on audio
@@ -44,6 +59,56 @@ func WithCompression(value bool) Option {
 	}
 }
 
+// WithCodec sets the codec used to upload audio; if given, it takes precedence over
+// WithCompression
+func WithCodec(codec Codec) Option {
+	return func(o *options) {
+		o.codec = codec
+	}
+}
+
+// WithModeCodec sets the codec used to upload audio for streams of the given mode,
+// overriding WithCodec
+func WithModeCodec(mode cloud.StreamType, codec Codec) Option {
+	return func(o *options) {
+		if o.modeCodecs == nil {
+			o.modeCodecs = make(map[cloud.StreamType]Codec)
+		}
+		o.modeCodecs[mode] = codec
+	}
+}
+
+// WithAdaptiveBitrate enables lowering the Opus bitrate of new requests when
+// uploading audio is slow, and raising it again when it recovers
+func WithAdaptiveBitrate(cfg BitrateConfig) Option {
+	return func(o *options) {
+		o.bitrate = &cfg
+	}
+}
+
+// WithLocales sets the registry used to pick the language that handles each request;
+// requests for locales it doesn't support will fail with an InvalidConfig error
+func WithLocales(registry *LocaleRegistry) Option {
+	return func(o *options) {
+		o.locales = registry
+	}
+}
+
+// WithMetrics sets where voice request latency, errors and upload volume are
+// recorded, instead of stream.DefaultMetrics
+func WithMetrics(m *stream.Metrics) Option {
+	return func(o *options) {
+		o.metrics = m
+	}
+}
+
+// WithMetricsInterval sets how often a summary of voice metrics is sent to DAS
+func WithMetricsInterval(interval time.Duration) Option {
+	return func(o *options) {
+		o.metricsInterval = interval
+	}
+}
+
 // WithChunkMs determines how often the cloud process will stream data to the cloud
 func WithChunkMs(value uint) Option {
 	return func(o *options) {
@@ -89,3 +154,46 @@ func WithErrorListener(value util.ErrorListener) Option {
 		o.errListener = value
 	}
 }
+
+// WithLocalIntents specifies that intent requests should be handled on the robot by
+// the given engine instead of being streamed to chipper; it replaces the connection
+// used for voice requests, like WithConnectFunc
+func WithLocalIntents(engine *localconn.Engine) Option {
+	return func(o *options) {
+		o.connectFn = localConnect(engine)
+	}
+}
+
+// WithRecordDir specifies that every voice request should be saved to the given
+// directory, so it can later be replayed (see the recording package)
+func WithRecordDir(dir string) Option {
+	return func(o *options) {
+		o.recordDir = dir
+	}
+}
+
+// WithConnectFunc replaces the connection used for voice requests, allowing tests to
+// run requests against a stub instead of a server
+func WithConnectFunc(connectFn stream.ConnectFunc) Option {
+	return func(o *options) {
+		o.connectFn = connectFn
+	}
+}
+
+// WithVAD enables client-side voice activity detection, which trims leading silence
+// from requests and ends them once the speaker stops talking, instead of waiting for
+// the mic to signal the end of audio. Zero-valued thresholds use defaults.
+func WithVAD(opts stream.VADOpts) Option {
+	return func(o *options) {
+		o.vadOpts = &opts
+	}
+}
+
+// WithPrerollMs specifies how much of the mic audio received just before a hotword
+// is sent at the start of its request, so speech that arrives before a stream is
+// ready isn't lost
+func WithPrerollMs(value uint) Option {
+	return func(o *options) {
+		o.prerollMs = value
+	}
+}
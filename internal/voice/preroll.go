@@ -0,0 +1,63 @@
+package voice
+
+import "time"
+
+// preroll is a fixed-size ring of the most recent mic audio, along with when each
+// sample arrived, so that the audio from just before a hotword can be sent ahead of
+// live audio once its stream opens
+type preroll struct {
+	// window is how far back from a trigger audio is kept for; the ring holds as
+	// many samples as that covers
+	window  time.Duration
+	samples []int16
+	times   []time.Time
+	// start is where the oldest sample held is, and size how many are held
+	start int
+	size  int
+}
+
+func newPreroll(samples int) *preroll {
+	return &preroll{
+		window:  time.Duration(samples) * time.Second / SampleRate,
+		samples: make([]int16, samples),
+		times:   make([]time.Time, samples),
+	}
+}
+
+// add records samples that arrived at the given time, overwriting the oldest audio
+// once the ring is full
+func (r *preroll) add(samples []int16, at time.Time) {
+	max := len(r.samples)
+	if max == 0 || len(samples) == 0 {
+		return
+	}
+	if len(samples) > max {
+		samples = samples[len(samples)-max:]
+	}
+	for _, s := range samples {
+		i := (r.start + r.size) % max
+		r.samples[i], r.times[i] = s, at
+		if r.size < max {
+			r.size++
+		} else {
+			r.start = (r.start + 1) % max
+		}
+	}
+}
+
+// flush returns the audio that arrived in the window before the given trigger,
+// oldest first, along with the number of samples held that were outside it, and
+// empties the ring
+func (r *preroll) flush(trigger time.Time) ([]int16, int) {
+	cutoff := trigger.Add(-r.window)
+	ret := make([]int16, 0, r.size)
+	for n := 0; n < r.size; n++ {
+		i := (r.start + n) % len(r.samples)
+		if at := r.times[i]; at.After(cutoff) && !at.After(trigger) {
+			ret = append(ret, r.samples[i])
+		}
+	}
+	stale := r.size - len(ret)
+	r.start, r.size = 0, 0
+	return ret, stale
+}
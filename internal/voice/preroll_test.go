@@ -0,0 +1,69 @@
+package voice
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPreroll(t *testing.T) {
+	r := newPreroll(5)
+	now := time.Now()
+	samples, stale := r.flush(now)
+	assert.Empty(t, samples)
+	assert.Equal(t, 0, stale)
+
+	r.add([]int16{1, 2, 3}, now)
+	samples, stale = r.flush(now)
+	assert.Equal(t, []int16{1, 2, 3}, samples)
+	assert.Equal(t, 0, stale)
+
+	// only the most recent audio is kept, the oldest being overwritten
+	r.add([]int16{1, 2, 3}, now)
+	r.add([]int16{4, 5, 6, 7}, now)
+	samples, stale = r.flush(now)
+	assert.Equal(t, []int16{3, 4, 5, 6, 7}, samples)
+	assert.Equal(t, 0, stale)
+
+	// a chunk bigger than the buffer keeps only its tail
+	r.add([]int16{1, 2}, now)
+	r.add([]int16{3, 4, 5, 6, 7, 8, 9}, now)
+	samples, stale = r.flush(now)
+	assert.Equal(t, []int16{5, 6, 7, 8, 9}, samples)
+	assert.Equal(t, 0, stale)
+
+	// and the ring keeps wrapping after a flush
+	r.add([]int16{1, 2, 3, 4}, now)
+	r.add([]int16{5, 6, 7}, now)
+	samples, _ = r.flush(now)
+	assert.Equal(t, []int16{3, 4, 5, 6, 7}, samples)
+
+	// adding audio doesn't allocate
+	chunk := []int16{1, 2, 3}
+	assert.Zero(t, testing.AllocsPerRun(100, func() { r.add(chunk, now) }))
+}
+
+func TestPrerollWindow(t *testing.T) {
+	// 1600 samples cover 100ms
+	r := newPreroll(1600)
+	trigger := time.Now()
+
+	// audio from long before the hotword isn't sent, however little arrived since
+	r.add(make([]int16, 160), trigger.Add(-time.Second))
+	r.add([]int16{1, 2}, trigger.Add(-50*time.Millisecond))
+	r.add([]int16{3}, trigger)
+	samples, stale := r.flush(trigger)
+	assert.Equal(t, []int16{1, 2, 3}, samples)
+	assert.Equal(t, 160, stale)
+
+	// nor is audio received after it
+	r.add([]int16{1}, trigger.Add(-10*time.Millisecond))
+	r.add([]int16{2}, trigger.Add(10*time.Millisecond))
+	samples, _ = r.flush(trigger)
+	assert.Equal(t, []int16{1}, samples)
+
+	// and nothing is sent twice
+	samples, _ = r.flush(trigger)
+	assert.Empty(t, samples)
+}
@@ -3,17 +3,20 @@ package voice
 import (
 	"context"
 	"fmt"
-	"strings"
 	"time"
 
 	"github.com/digital-dream-labs/vector-cloud/internal/clad/cloud"
 
 	"github.com/digital-dream-labs/vector-cloud/internal/config"
 	"github.com/digital-dream-labs/vector-cloud/internal/log"
+	"github.com/digital-dream-labs/vector-cloud/internal/util"
+	"github.com/digital-dream-labs/vector-cloud/internal/voice/recording"
 	"github.com/digital-dream-labs/vector-cloud/internal/voice/stream"
+	"github.com/digital-dream-labs/vector-cloud/internal/voice/stream/localconn"
 
 	"github.com/digital-dream-labs/api-clients/chipper"
 	pb "github.com/digital-dream-labs/api/go/chipperpb"
+	"github.com/google/uuid"
 )
 
 var (
@@ -31,6 +34,8 @@ const (
 	SampleBits = 16
 	// DefaultTimeout is the length of time before the process will cancel a voice request
 	DefaultTimeout = 9 * time.Second
+	// DefaultMetricsInterval is how often a summary of voice metrics is sent to DAS
+	DefaultMetricsInterval = time.Hour
 )
 
 // Process contains the data associated with an instance of the cloud process,
@@ -42,6 +47,11 @@ type Process struct {
 	kill      chan struct{}
 	msg       chan messageEvent
 	opts      options
+	endpoints *stream.Endpoints
+	recorder  *recording.Recorder
+	bitrate   *bitrateAdapter
+	locales   *LocaleRegistry
+	metrics   *stream.Metrics
 }
 
 // AddReceiver adds the given Receiver to the list of sources the
@@ -58,6 +68,16 @@ func (p *Process) AddReceiver(r *Receiver) {
 	p.receivers = append(p.receivers, r)
 }
 
+// AddRoutedReceiver adds the given Receiver to the list of sources the cloud
+// process will listen to for data, like AddReceiver, except that the results of
+// requests it starts are sent only to the given writer rather than to every writer
+// added with AddIntentWriter. This allows several clients to make requests at once
+// without seeing each other's results.
+func (p *Process) AddRoutedReceiver(r *Receiver, intents MsgSender) {
+	r.intents = intents
+	p.AddReceiver(r)
+}
+
 // AddTestReceiver adds the given Receiver to the list of sources the
 // cloud process will listen to for data. Additionally, it will be
 // marked as a test receiver, which means that data sent on this
@@ -69,8 +89,8 @@ func (p *Process) AddTestReceiver(r *Receiver) {
 }
 
 type messageEvent struct {
-	msg    *cloud.Message
-	isTest bool
+	msg  *cloud.Message
+	recv *Receiver
 }
 
 func (p *Process) addMultiplexRoutine(r *Receiver) {
@@ -80,7 +100,7 @@ func (p *Process) addMultiplexRoutine(r *Receiver) {
 			case <-p.kill:
 				return
 			case msg := <-r.msg:
-				p.msg <- messageEvent{msg: msg, isTest: r.isTest}
+				p.msg <- messageEvent{msg: msg, recv: r}
 			}
 		}
 	}()
@@ -101,6 +121,7 @@ type strmReceiver struct {
 	err        chan cloudError
 	open       chan cloudOpen
 	connection chan cloudConnCheck
+	partial    chan cloudPartial
 }
 
 func (c *strmReceiver) OnIntent(r *cloud.IntentResult) {
@@ -127,10 +148,20 @@ func (c *strmReceiver) OnConnectionResult(r *cloud.ConnectionResult) {
 	c.connection <- cloudConnCheck{c, r}
 }
 
+func (c *strmReceiver) OnPartialTranscript(transcript string, isFinal bool) {
+	if c.partial == nil {
+		return
+	}
+	c.partial <- cloudPartial{c, transcript, isFinal}
+}
+
 func (c *strmReceiver) Close() {
 	if c.intent != nil {
 		close(c.intent)
 	}
+	if c.partial != nil {
+		close(c.partial)
+	}
 	close(c.err)  // should never be nil
 	close(c.open) // should never be nil
 	if c.connection != nil {
@@ -148,11 +179,38 @@ func (p *Process) Run(ctx context.Context, options ...Option) {
 	for _, opt := range options {
 		opt(&p.opts)
 	}
+	// endpoint health is shared between streams so a dead server is skipped by
+	// subsequent requests, not just the one that discovered it
+	p.endpoints = stream.NewEndpoints(config.Env.ChipperURLs(), p.opts.errListener)
+	if p.opts.bitrate != nil {
+		p.bitrate = newBitrateAdapter(*p.opts.bitrate)
+	}
+	p.metrics = p.opts.metrics
+	if p.metrics == nil {
+		p.metrics = stream.DefaultMetrics
+	}
+	metricsInterval := p.opts.metricsInterval
+	if metricsInterval == 0 {
+		metricsInterval = DefaultMetricsInterval
+	}
+	summaryTicker := time.NewTicker(metricsInterval)
+	defer summaryTicker.Stop()
+	p.locales = p.opts.locales
+	if p.locales == nil {
+		p.locales = DefaultLocaleRegistry()
+	}
+	if p.opts.recordDir != "" {
+		var err error
+		if p.recorder, err = recording.NewRecorder(p.opts.recordDir); err != nil {
+			log.Println("Error creating voice recording directory, not recording:", err)
+		}
+	}
 
 	cloudChans := &strmReceiver{
-		intent: make(chan cloudIntent),
-		err:    make(chan cloudError),
-		open:   make(chan cloudOpen),
+		intent:  make(chan cloudIntent),
+		err:     make(chan cloudError),
+		open:    make(chan cloudOpen),
+		partial: make(chan cloudPartial),
 	}
 	defer cloudChans.Close()
 
@@ -163,25 +221,27 @@ func (p *Process) Run(ctx context.Context, options ...Option) {
 	}
 	defer connCheck.Close()
 
-	var strm *stream.Streamer
+	sessions := newSessions()
+	prerollSamples := int(p.opts.prerollMs) * SampleRate / 1000
 procloop:
 	for {
 		// the cases in this select should NOT block! if messages that others send us
 		// are not promptly read, socket buffers can fill up and break voice processing
 		select {
 		case msg := <-p.msg:
+			c := sessions.client(msg.recv, prerollSamples)
 			switch msg.msg.Tag() {
 			case cloud.MessageTag_Hotword:
 				// hotword = get ready to stream data
-				if strm != nil {
+				triggered := time.Now()
+				if c.request != nil {
 					log.Println("Got hotword event while already streaming, weird...")
-					if err := strm.Close(); err != nil {
-						log.Println("Error closing context:")
-					}
+					p.finishRecording(c.request)
+					sessions.close(c.request)
 				}
 
 				// if this is from a test receiver, notify the mic to send the AI a hotword on our behalf
-				if msg.isTest {
+				if msg.recv.isTest {
 					p.writeMic(cloud.NewMessageWithTestStarted(&cloud.Void{}))
 				}
 
@@ -189,7 +249,7 @@ procloop:
 				mode := hw.Mode
 				serverMode, ok := modeMap[mode]
 				if !ok && mode != cloud.StreamType_KnowledgeGraph {
-					p.writeError(cloud.ErrorType_InvalidConfig, fmt.Errorf("unknown mode %d", mode))
+					p.writeError(c, cloud.ErrorType_InvalidConfig, fmt.Errorf("unknown mode %d", mode))
 					continue
 				}
 
@@ -197,13 +257,14 @@ procloop:
 				if locale == "" {
 					locale = "en-US"
 				}
-				language, err := getLanguage(locale)
+				language, err := p.locales.Language(locale)
 				if err != nil {
-					p.writeError(cloud.ErrorType_InvalidConfig, err)
+					p.writeError(c, cloud.ErrorType_InvalidConfig, err)
 					continue
 				}
 
-				chipperOpts := p.defaultChipperOptions()
+				p.adaptBitrate(mode)
+				chipperOpts := p.defaultChipperOptions(mode)
 				chipperOpts.SaveAudio = p.opts.saveAudio
 				chipperOpts.Language = language
 				chipperOpts.NoDas = hw.NoLogging
@@ -224,18 +285,29 @@ procloop:
 					}, mode)
 				}
 				logVerbose("Got hotword event", serverMode)
+				strmOpts := []stream.Option{option}
+				if p.opts.vadOpts != nil {
+					vadOpts := *p.opts.vadOpts
+					vadOpts.SampleRate = SampleRate
+					strmOpts = append(strmOpts, stream.WithVAD(vadOpts))
+				}
 				newReceiver := *cloudChans
-				strm = p.newStream(ctx, &newReceiver, option)
+				strm := p.newStream(ctx, &newReceiver, strmOpts...)
 				newReceiver.stream = strm
+				c.request = sessions.add(c, strm)
+				if p.recorder != nil {
+					c.request.recording = recording.NewSession(hw)
+				}
+				p.flushPreroll(c, triggered)
 
 			case cloud.MessageTag_DebugFile:
-				p.writeResponse(msg.msg)
+				p.writeResponse(c, msg.msg)
 
 			case cloud.MessageTag_AudioDone:
 				// no more audio is coming - close send on the stream
-				if strm != nil {
+				if c.request != nil {
 					logVerbose("Got notification mic is done sending audio")
-					if err := strm.CloseSend(); err != nil {
+					if err := c.request.strm.CloseSend(); err != nil {
 						log.Println("Error closing stream send:", err)
 					}
 				}
@@ -243,103 +315,135 @@ procloop:
 			case cloud.MessageTag_Audio:
 				// add samples to our buffer
 				buf := msg.msg.GetAudio().Data
-				if strm != nil {
-					strm.AddSamples(buf)
-				} else {
+				if c.preroll != nil {
+					// kept even while streaming, so a hotword right after a request
+					// still gets the audio just before it
+					c.preroll.add(buf, time.Now())
+				}
+				if c.request != nil {
+					c.request.strm.AddSamples(buf)
+					if c.request.recording != nil {
+						c.request.recording.AddAudio(buf)
+					}
+				} else if c.preroll == nil {
 					logVerbose("No active context, discarding", len(buf), "samples")
 				}
 
 			case cloud.MessageTag_ConnectionCheck:
 				logVerbose("Got connection check request")
-				// connection check = open a stream to check connection quality
-				if strm != nil {
-					log.Println("Got connection check request while already streaming, closing current stream")
-					if err := strm.Close(); err != nil {
-						log.Println("Error closing context:")
-					}
+				// connection check = open a stream to check connection quality; this can
+				// run alongside a voice request
+				if c.check != nil {
+					log.Println("Got connection check request while already checking, closing current check")
+					sessions.close(c.check)
 				}
 
-				chipperOpts := p.defaultChipperOptions()
+				chipperOpts := p.defaultChipperOptions(cloud.StreamType_Normal)
 				connectOpts := chipper.ConnectOpts{
 					StreamOpts:        chipperOpts,
 					TotalAudioMs:      DefaultAudioLenMs,
 					AudioPerRequestMs: DefaultChunkMs,
 				}
 
-				strm = p.newStream(ctx, connCheck, stream.WithConnectionCheckOptions(connectOpts))
+				c.check = sessions.add(c, p.newStream(ctx, connCheck, stream.WithConnectionCheckOptions(connectOpts)))
 			}
 
 		case intent := <-cloudChans.intent:
-			if intent.recvr.stream != strm {
+			sess := sessions.lookup(intent.recvr.stream)
+			if sess == nil {
 				log.Println("Ignoring result from prior stream:", intent.result)
 				continue
 			}
 			logVerbose("Received intent from cloud:", intent.result)
+			p.metrics.Intent.Observe(util.TimeSinceMs(sess.started))
 
 			// we got an answer from the cloud, tell mic to stop...
-			p.signalMicStop()
+			p.signalMicStop(sess.client)
 
 			// send intent to AI
-			p.writeResponse(cloud.NewMessageWithResult(intent.result))
+			p.writeResponse(sess.client, cloud.NewMessageWithResult(intent.result))
+			if sess.recording != nil {
+				sess.recording.Result = intent.result
+			}
+			p.finishRecording(sess)
 
 			// stop streaming until we get another hotword event
-			if err := strm.Close(); err != nil {
-				log.Println("Error closing context:")
-			}
-			strm = nil
+			sessions.close(sess)
 
 		case err := <-cloudChans.err:
-			if err.recvr.stream != strm {
+			sess := sessions.lookup(err.recvr.stream)
+			if sess == nil {
 				log.Println("Ignoring error from prior stream:", err.err)
 				continue
 			}
 			logVerbose("Received error from cloud:", err.err)
-			p.signalMicStop()
-			p.writeError(err.kind, err.err)
+			p.signalMicStop(sess.client)
+			p.writeError(sess.client, err.kind, err.err)
 			if p.opts.errListener != nil {
 				p.opts.errListener.OnError(err.err)
 			}
-			if err := strm.Close(); err != nil {
-				log.Println("Error closing context:")
+			if sess.recording != nil {
+				sess.recording.Error = &cloud.IntentError{Error: err.kind, Extra: err.err.Error()}
 			}
-			strm = nil
+			p.finishRecording(sess)
+			sessions.close(sess)
 
 		case open := <-cloudChans.open:
-			if open.recvr.stream != strm {
+			sess := sessions.lookup(open.recvr.stream)
+			if sess == nil {
 				log.Println("Ignoring stream open from prior stream:", open.session)
 				continue
 			}
-			p.writeResponse(cloud.NewMessageWithStreamOpen(&cloud.StreamOpen{Session: open.session}))
+			p.metrics.StreamOpen.Observe(util.TimeSinceMs(sess.started))
+			p.writeResponse(sess.client, cloud.NewMessageWithStreamOpen(&cloud.StreamOpen{Session: open.session}))
+			sess.id = open.session
+			if sess.recording != nil {
+				sess.recording.Session = open.session
+			}
+
+		case partial := <-cloudChans.partial:
+			sess := sessions.lookup(partial.recvr.stream)
+			if sess == nil {
+				continue
+			}
+			// pass interim results straight on, so the AI can react before the intent arrives
+			p.writeResponse(sess.client, cloud.NewMessageWithPartialTranscript(&cloud.PartialTranscript{
+				Session:    sess.id,
+				Transcript: partial.transcript,
+				IsFinal:    partial.isFinal,
+			}))
 
 		case err := <-connCheck.err:
-			if err.recvr.stream != strm {
+			sess := sessions.lookup(err.recvr.stream)
+			if sess == nil {
 				log.Println("Ignoring error from prior connection check:", err)
 				continue
 			}
 			logVerbose("Received error from conn check:", err)
-			p.respondToConnectionCheck(nil, &err)
-			if err := strm.Close(); err != nil {
-				log.Println("Error closing context:")
-			}
-			strm = nil
+			p.respondToConnectionCheck(sess.client, nil, &err)
+			sessions.close(sess)
 
 		case <-connCheck.open:
 			// don't care
 
 		case r := <-connCheck.connection:
-			if r.recvr.stream != strm {
+			sess := sessions.lookup(r.recvr.stream)
+			if sess == nil {
 				log.Println("Ignoring connection result from prior check:", r.result)
 				continue
 			}
 			logVerbose("Received connection check result from cloud:", r.result)
-			p.respondToConnectionCheck(r.result, nil)
-			if err := strm.Close(); err != nil {
-				log.Println("Error closing context:")
-			}
-			strm = nil
+			p.respondToConnectionCheck(sess.client, r.result, nil)
+			sessions.close(sess)
+
+		case <-summaryTicker.C:
+			p.metrics.Summarize()
 
 		case <-ctx.Done():
 			logVerbose("Received stop notification")
+			for _, sess := range sessions.all() {
+				p.finishRecording(sess)
+			}
 			if p.kill != nil {
 				close(p.kill)
 			}
@@ -364,43 +468,43 @@ func SetVerbose(value bool) {
 	stream.SetVerbose(value)
 }
 
-func (p *Process) defaultChipperOptions() chipper.StreamOpts {
+func (p *Process) defaultChipperOptions(mode cloud.StreamType) chipper.StreamOpts {
 	return chipper.StreamOpts{
-		CompressOpts: chipper.CompressOpts{
-			Compress:   p.opts.compress,
-			Bitrate:    66 * 1024,
-			Complexity: 0,
-			FrameSize:  60},
-		Timeout: DefaultTimeout,
+		CompressOpts: p.compressOpts(mode),
+		Timeout:      DefaultTimeout,
 	}
 }
 
 func (p *Process) newStream(ctx context.Context, receiver *strmReceiver, strmopts ...stream.Option) *stream.Streamer {
 	strmopts = append(strmopts, stream.WithTokener(p.opts.tokener, p.opts.requireToken),
-		stream.WithChipperURL(config.Env.Chipper))
+		stream.WithChipperURL(config.Env.Chipper), stream.WithChipperEndpoints(p.endpoints))
+	if p.opts.connectFn != nil {
+		strmopts = append(strmopts, stream.WithConnectFunc(p.opts.connectFn))
+	}
+	if p.bitrate != nil {
+		strmopts = append(strmopts, stream.WithSendObserver(p.bitrate.observe))
+	}
+	strmopts = append(strmopts, stream.WithMetrics(p.metrics))
 	newReceiver := *receiver
 	stream := stream.NewStreamer(ctx, &newReceiver, p.StreamSize(), strmopts...)
 	newReceiver.stream = stream
 	return stream
 }
 
-func (p *Process) writeError(reason cloud.ErrorType, err error) {
-	p.writeResponse(cloud.NewMessageWithError(&cloud.IntentError{Error: reason, Extra: err.Error()}))
-}
-
-func (p *Process) writeResponse(response *cloud.Message) {
-	for _, r := range p.intents {
-		err := r.Send(response)
+// localConnect returns a ConnectFunc whose streams are handled by the given local
+// intent engine instead of chipper
+func localConnect(engine *localconn.Engine) stream.ConnectFunc {
+	return func(ctx context.Context) (stream.Conn, *stream.CloudError) {
+		sessionID := uuid.New().String()[:16]
+		conn, err := engine.Connect(ctx, stream.Language(ctx), sessionID)
 		if err != nil {
-			log.Println("AI write error:", err)
+			log.Println("Error creating local intent stream:", err)
+			return nil, &stream.CloudError{Kind: cloud.ErrorType_Connecting, Err: err}
 		}
+		return conn, nil
 	}
 }
 
-func (p *Process) signalMicStop() {
-	p.writeMic(cloud.NewMessageWithStopSignal(&cloud.Void{}))
-}
-
 func (p *Process) writeMic(msg *cloud.Message) {
 	for _, r := range p.receivers {
 		err := r.writeBack(msg)
@@ -410,12 +514,13 @@ func (p *Process) writeMic(msg *cloud.Message) {
 	}
 }
 
-func (p *Process) respondToConnectionCheck(result *cloud.ConnectionResult, cErr *cloudError) {
+func (p *Process) respondToConnectionCheck(c *client, result *cloud.ConnectionResult, cErr *cloudError) {
 	toSend := &cloud.ConnectionResult{
 		NumPackets:      uint8(0),
 		ExpectedPackets: uint8(DefaultAudioLenMs / DefaultChunkMs),
 	}
 	if cErr != nil {
+		p.metrics.AddError(cErr.kind)
 		toSend.Status = cErr.err.Error()
 		switch cErr.kind {
 		case cloud.ErrorType_TLS:
@@ -434,7 +539,9 @@ func (p *Process) respondToConnectionCheck(result *cloud.ConnectionResult, cErr
 	} else {
 		toSend = result
 	}
-	p.writeMic(cloud.NewMessageWithConnectionResult(toSend))
+	if err := c.recv.writeBack(cloud.NewMessageWithConnectionResult(toSend)); err != nil {
+		log.Println("Mic write error:", err)
+	}
 }
 
 func logVerbose(a ...interface{}) {
@@ -444,40 +551,6 @@ func logVerbose(a ...interface{}) {
 	log.Println(a...)
 }
 
-func getLanguage(locale string) (pb.LanguageCode, error) {
-	// split on _ and -
-	strs := strings.Split(locale, "-")
-	if len(strs) != 2 {
-		strs = strings.Split(locale, "_")
-	}
-	if len(strs) != 2 {
-		return 0, fmt.Errorf("invalid locale string %s", locale)
-	}
-
-	lang := strings.ToLower(strs[0])
-	country := strings.ToLower(strs[1])
-
-	switch lang {
-	case "fr":
-		return pb.LanguageCode_FRENCH, nil
-	case "de":
-		return pb.LanguageCode_GERMAN, nil
-	case "en":
-		break
-	default:
-		// unknown == default to en_US
-		return pb.LanguageCode_ENGLISH_US, nil
-	}
-
-	switch country {
-	case "gb": // ISO2 code for UK is 'GB'
-		return pb.LanguageCode_ENGLISH_UK, nil
-	case "au":
-		return pb.LanguageCode_ENGLISH_AU, nil
-	}
-	return pb.LanguageCode_ENGLISH_US, nil
-}
-
 var modeMap = map[cloud.StreamType]pb.RobotMode{
 	cloud.StreamType_Normal:    pb.RobotMode_VOICE_COMMAND,
 	cloud.StreamType_Blackjack: pb.RobotMode_GAME,
@@ -503,3 +576,48 @@ type cloudConnCheck struct {
 	recvr  *strmReceiver
 	result *cloud.ConnectionResult
 }
+
+type cloudPartial struct {
+	recvr      *strmReceiver
+	transcript string
+	isFinal    bool
+}
+
+// flushPreroll sends the audio the client's pre-roll buffer received just before
+// the hotword that triggered its new request, ahead of live audio
+func (p *Process) flushPreroll(c *client, triggered time.Time) {
+	if c.preroll == nil {
+		return
+	}
+	samples, stale := c.preroll.flush(triggered)
+	log.Das("voice.preroll", (&log.DasFields{}).SetInts(len(samples)*1000/SampleRate,
+		stale*1000/SampleRate))
+	logVerbose("Flushing", len(samples), "pre-roll samples to new stream,", stale, "too old to use")
+	if len(samples) == 0 {
+		return
+	}
+	c.request.strm.AddSamples(samples)
+	if c.request.recording != nil {
+		c.request.recording.AddAudio(samples)
+	}
+}
+
+// finishRecording saves the given session's recording, if it has one
+func (p *Process) finishRecording(sess *session) {
+	if sess.recording != nil {
+		p.saveRecording(sess.recording)
+		sess.recording = nil
+	}
+}
+
+// saveRecording writes the given session to disk in the background, so the main
+// routine isn't held up by file I/O
+func (p *Process) saveRecording(session *recording.Session) {
+	go func() {
+		if filename, err := p.recorder.Save(session); err != nil {
+			log.Println("Error saving voice recording:", err)
+		} else {
+			logVerbose("Saved voice recording to", filename)
+		}
+	}()
+}
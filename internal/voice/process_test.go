@@ -0,0 +1,124 @@
+package voice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/digital-dream-labs/vector-cloud/internal/clad/cloud"
+	"github.com/digital-dream-labs/vector-cloud/internal/voice/stream"
+
+	"github.com/digital-dream-labs/api-clients/chipper"
+	pb "github.com/digital-dream-labs/api/go/chipperpb"
+	"github.com/stretchr/testify/assert"
+)
+
+// echoConn responds with an intent named after the first audio sample it was sent
+type echoConn struct {
+	mu       sync.Mutex
+	first    []byte
+	sendDone chan struct{}
+	once     sync.Once
+}
+
+func (c *echoConn) Close() error {
+	return c.CloseSend()
+}
+
+func (c *echoConn) CloseSend() error {
+	c.once.Do(func() { close(c.sendDone) })
+	return nil
+}
+
+func (c *echoConn) SendAudio(buf []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.first == nil {
+		c.first = buf
+	}
+	return nil
+}
+
+func (c *echoConn) WaitForResponse() (interface{}, error) {
+	<-c.sendDone
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return &chipper.IntentGraphResponse{
+		IsFinal:      true,
+		ResponseType: pb.IntentGraphMode_INTENT,
+		IntentResult: &chipper.IntentResult{Action: fmt.Sprint("intent_", c.first[0])},
+	}, nil
+}
+
+func echoConnect(context.Context) (stream.Conn, *stream.CloudError) {
+	return &echoConn{sendDone: make(chan struct{})}, nil
+}
+
+func TestConcurrentClients(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	const clients = 3
+	process := &Process{}
+	ios := make([]MsgIO, clients)
+	results := make([]chan *cloud.Message, clients)
+	for i := range ios {
+		var receiver *Receiver
+		ios[i], receiver = NewMemPipe()
+		results[i] = make(chan *cloud.Message, 10)
+		process.AddRoutedReceiver(receiver, &ChanMsgSender{Ch: results[i]})
+	}
+	go process.Run(ctx, WithConnectFunc(echoConnect))
+
+	// start all requests, then interleave their audio
+	for _, io := range ios {
+		io.Send(cloud.NewMessageWithHotword(&cloud.Hotword{Mode: cloud.StreamType_Normal}))
+	}
+	for chunk := 0; chunk < 4; chunk++ {
+		for i, io := range ios {
+			samples := make([]int16, SampleRate*DefaultChunkMs/1000)
+			for j := range samples {
+				samples[j] = int16(i + 1)
+			}
+			io.Send(cloud.NewMessageWithAudio(&cloud.AudioData{Data: samples}))
+		}
+	}
+	for _, io := range ios {
+		io.Send(cloud.NewMessageWithAudioDone(&cloud.Void{}))
+	}
+
+	// each client should only see its own result
+	for i := range ios {
+		select {
+		case msg := <-results[i]:
+			assert.Equal(t, cloud.MessageTag_Result, msg.Tag())
+			assert.Equal(t, fmt.Sprint("intent_", i+1), msg.GetResult().Intent)
+		case <-time.After(time.Second):
+			t.Fatal("no result for client", i)
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+	for i := range ios {
+		assert.Empty(t, results[i])
+	}
+}
+
+func TestConnectionCheckErrors(t *testing.T) {
+	mic := make(chan *cloud.Message, 1)
+	metrics := stream.NewMetrics()
+	process := &Process{metrics: metrics}
+	c := &client{recv: &Receiver{writer: &ChanMsgSender{Ch: mic}}}
+
+	// a failed check is reported to the client, and counted like any other error
+	process.respondToConnectionCheck(c, nil, &cloudError{kind: cloud.ErrorType_Connectivity, err: errors.New("no route")})
+	select {
+	case msg := <-mic:
+		assert.Equal(t, cloud.ConnectionCode_Connectivity, msg.GetConnectionResult().Code)
+	case <-time.After(time.Second):
+		t.Fatal("no connection result")
+	}
+	assert.Equal(t, uint64(1), metrics.Errors(cloud.ErrorType_Connectivity))
+}
@@ -17,6 +17,9 @@ type Receiver struct {
 	msg    chan *cloud.Message
 	writer MsgSender
 	isTest bool
+	// if set, results of requests from this receiver are only sent here, instead of
+	// to all of the process's intent writers
+	intents MsgSender
 }
 
 func (r *Receiver) writeBack(msg *cloud.Message) error {
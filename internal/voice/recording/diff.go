@@ -0,0 +1,59 @@
+package recording
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/digital-dream-labs/vector-cloud/internal/clad/cloud"
+)
+
+// Diff compares a session's recorded outcome with the given replayed one, and
+// describes the differences; it returns an empty string if they match. Intent
+// metadata is not compared, since it contains timing-dependent values like confidence.
+func (s *Session) Diff(result *cloud.IntentResult, err *cloud.IntentError) string {
+	switch {
+	case s.Error != nil && err != nil:
+		if s.Error.Error != err.Error {
+			return fmt.Sprintf("error: recorded %v, replayed %v", s.Error.Error, err.Error)
+		}
+		return ""
+	case s.Error != nil:
+		return fmt.Sprintf("error: recorded %v, replayed intent %s", s.Error.Error, describe(result))
+	case err != nil:
+		return fmt.Sprintf("intent: recorded %s, replayed error %v (%s)", describe(s.Result), err.Error, err.Extra)
+	case s.Result == nil || result == nil:
+		if s.Result == result {
+			return ""
+		}
+		return fmt.Sprintf("intent: recorded %s, replayed %s", describe(s.Result), describe(result))
+	}
+
+	if s.Result.Intent != result.Intent {
+		return fmt.Sprintf("intent: recorded %s, replayed %s", s.Result.Intent, result.Intent)
+	}
+	// parameters are JSON encoded maps; compare them decoded so key order doesn't matter
+	recorded, replayed := decodeParams(s.Result.Parameters), decodeParams(result.Parameters)
+	if !reflect.DeepEqual(recorded, replayed) {
+		return fmt.Sprintf("parameters: recorded %v, replayed %v", recorded, replayed)
+	}
+	return ""
+}
+
+func describe(result *cloud.IntentResult) string {
+	if result == nil {
+		return "<none>"
+	}
+	return result.Intent
+}
+
+func decodeParams(params string) map[string]string {
+	ret := map[string]string{}
+	if params != "" {
+		if err := json.Unmarshal([]byte(params), &ret); err != nil {
+			// not a map - compare the raw strings instead
+			return map[string]string{"": params}
+		}
+	}
+	return ret
+}
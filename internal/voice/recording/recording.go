@@ -0,0 +1,130 @@
+// Package recording persists voice sessions (the hotword that started them, the
+// audio that was streamed, and the resulting intent or error) so they can later be
+// replayed through the voice pipeline as regression tests.
+package recording
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/digital-dream-labs/vector-cloud/internal/clad/cloud"
+)
+
+// fileExt is the extension given to recorded sessions
+const fileExt = ".json"
+
+// Chunk is a single piece of audio received from the mic during a session
+type Chunk struct {
+	// OffsetMs is the time the chunk was received, relative to the hotword
+	OffsetMs int64 `json:"offset_ms"`
+	// Data is 16-bit little endian PCM
+	Data []byte `json:"data"`
+}
+
+// Samples returns the chunk's audio as it was received from the mic
+func (c *Chunk) Samples() []int16 {
+	samples := make([]int16, len(c.Data)/2)
+	binary.Read(bytes.NewReader(c.Data), binary.LittleEndian, samples)
+	return samples
+}
+
+// Session is a single recorded voice request
+type Session struct {
+	Started time.Time `json:"started"`
+	// Session is the ID of the stream opened for this request, if one was opened
+	Session string              `json:"session,omitempty"`
+	Hotword cloud.Hotword       `json:"hotword"`
+	Audio   []Chunk             `json:"audio"`
+	Result  *cloud.IntentResult `json:"result,omitempty"`
+	Error   *cloud.IntentError  `json:"error,omitempty"`
+}
+
+// NewSession begins recording a session started by the given hotword
+func NewSession(hw *cloud.Hotword) *Session {
+	return &Session{Started: time.Now(), Hotword: *hw}
+}
+
+// AddAudio records a chunk of mic audio
+func (s *Session) AddAudio(samples []int16) {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, samples)
+	s.Audio = append(s.Audio, Chunk{
+		OffsetMs: int64(time.Since(s.Started) / time.Millisecond),
+		Data:     buf.Bytes(),
+	})
+}
+
+// Complete returns true if the session ended in a result or error
+func (s *Session) Complete() bool {
+	return s.Result != nil || s.Error != nil
+}
+
+// Recorder saves sessions to a directory, one file per session
+type Recorder struct {
+	dir string
+}
+
+// NewRecorder returns a Recorder that saves to the given directory, creating it if
+// necessary
+func NewRecorder(dir string) (*Recorder, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Recorder{dir: dir}, nil
+}
+
+// Save writes the given session to the recorder's directory and returns the name of
+// the file it was written to
+func (r *Recorder) Save(s *Session) (string, error) {
+	buf, err := json.Marshal(s)
+	if err != nil {
+		return "", err
+	}
+	name := s.Started.UTC().Format("20060102-150405.000")
+	if s.Session != "" {
+		name += "-" + s.Session
+	}
+	filename := filepath.Join(r.dir, name+fileExt)
+
+	// write to a temp file first so readers never see a partial session
+	tmp := filename + ".tmp"
+	if err := ioutil.WriteFile(tmp, buf, 0644); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp, filename); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	return filename, nil
+}
+
+// Load reads a recorded session from the given file
+func Load(filename string) (*Session, error) {
+	buf, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var s Session
+	if err := json.Unmarshal(buf, &s); err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err)
+	}
+	return &s, nil
+}
+
+// Files returns the recorded session files in the given directory, oldest first
+func Files(dir string) ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*"+fileExt))
+	if err != nil {
+		return nil, err
+	}
+	// names start with a timestamp, so this orders them by recording time
+	sort.Strings(files)
+	return files, nil
+}
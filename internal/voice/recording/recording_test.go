@@ -0,0 +1,82 @@
+package recording_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/digital-dream-labs/vector-cloud/internal/clad/cloud"
+	"github.com/digital-dream-labs/vector-cloud/internal/voice/recording"
+
+	"github.com/digital-dream-labs/api-clients/chipper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSaveLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "recording")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	rec, err := recording.NewRecorder(dir)
+	assert.NoError(t, err)
+
+	s := recording.NewSession(&cloud.Hotword{Mode: cloud.StreamType_Normal, Locale: "en-US"})
+	s.AddAudio([]int16{1, -2, 3})
+	s.AddAudio([]int16{32767, -32768})
+	s.Session = "abc123"
+	s.Result = &cloud.IntentResult{Intent: "intent_greeting_hello"}
+	filename, err := rec.Save(s)
+	assert.NoError(t, err)
+
+	files, err := recording.Files(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{filename}, files)
+
+	loaded, err := recording.Load(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, s.Hotword, loaded.Hotword)
+	assert.Equal(t, "abc123", loaded.Session)
+	assert.Len(t, loaded.Audio, 2)
+	assert.Equal(t, []int16{1, -2, 3}, loaded.Audio[0].Samples())
+	assert.Equal(t, []int16{32767, -32768}, loaded.Audio[1].Samples())
+	assert.True(t, loaded.Complete())
+	assert.Equal(t, "", loaded.Diff(s.Result, nil))
+}
+
+func TestDiff(t *testing.T) {
+	s := &recording.Session{Result: &cloud.IntentResult{Intent: "intent_clock_settimer_extend",
+		Parameters: `{"timer_duration":"5","unit":"m"}`}}
+
+	// parameter order and metadata don't matter
+	assert.Equal(t, "", s.Diff(&cloud.IntentResult{Intent: "intent_clock_settimer_extend",
+		Parameters: `{"unit":"m","timer_duration":"5"}`, Metadata: "confidence: 0.5"}, nil))
+	assert.NotEqual(t, "", s.Diff(&cloud.IntentResult{Intent: "intent_clock_settimer_extend",
+		Parameters: `{"timer_duration":"6","unit":"m"}`}, nil))
+	assert.NotEqual(t, "", s.Diff(&cloud.IntentResult{Intent: "intent_system_sleep"}, nil))
+	assert.NotEqual(t, "", s.Diff(nil, &cloud.IntentError{Error: cloud.ErrorType_Timeout}))
+
+	s = &recording.Session{Error: &cloud.IntentError{Error: cloud.ErrorType_Timeout}}
+	assert.Equal(t, "", s.Diff(nil, &cloud.IntentError{Error: cloud.ErrorType_Timeout}))
+	assert.NotEqual(t, "", s.Diff(nil, &cloud.IntentError{Error: cloud.ErrorType_Server}))
+}
+
+func TestStubConnect(t *testing.T) {
+	s := &recording.Session{Result: &cloud.IntentResult{Intent: "intent_clock_settimer_extend",
+		Parameters: `{"timer_duration":"5"}`}}
+	conn, cerr := recording.StubConnect(s)(context.Background())
+	assert.Nil(t, cerr)
+	assert.NoError(t, conn.SendAudio(make([]byte, 10)))
+	assert.NoError(t, conn.CloseSend())
+	resp, err := conn.WaitForResponse()
+	assert.NoError(t, err)
+	ig := resp.(*chipper.IntentGraphResponse)
+	assert.True(t, chipper.IsIntent(*ig))
+	assert.Equal(t, "intent_clock_settimer_extend", ig.IntentResult.Action)
+	assert.Equal(t, map[string]string{"timer_duration": "5"}, ig.IntentResult.Parameters)
+
+	s = &recording.Session{Error: &cloud.IntentError{Error: cloud.ErrorType_Timeout, Extra: "too slow"}}
+	_, cerr = recording.StubConnect(s)(context.Background())
+	assert.Equal(t, cloud.ErrorType_Timeout, cerr.Kind)
+	assert.EqualError(t, cerr.Err, "too slow")
+}
@@ -0,0 +1,71 @@
+package recording
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+
+	"github.com/digital-dream-labs/vector-cloud/internal/clad/cloud"
+	"github.com/digital-dream-labs/vector-cloud/internal/voice/stream"
+
+	"github.com/digital-dream-labs/api-clients/chipper"
+	pb "github.com/digital-dream-labs/api/go/chipperpb"
+)
+
+// StubConnect returns a stream.ConnectFunc that reproduces the given session's
+// outcome without a server: recorded errors are returned when connecting, and
+// recorded results are returned once all audio has been sent
+func StubConnect(s *Session) stream.ConnectFunc {
+	return func(ctx context.Context) (stream.Conn, *stream.CloudError) {
+		if s.Error != nil {
+			return nil, &stream.CloudError{Kind: s.Error.Error, Err: errors.New(s.Error.Extra)}
+		}
+		if s.Result == nil {
+			return nil, &stream.CloudError{Kind: cloud.ErrorType_InvalidConfig,
+				Err: errors.New("recorded session has no result")}
+		}
+		result := &chipper.IntentResult{Action: s.Result.Intent}
+		if s.Result.Parameters != "" {
+			if err := json.Unmarshal([]byte(s.Result.Parameters), &result.Parameters); err != nil {
+				return nil, &stream.CloudError{Kind: cloud.ErrorType_Json, Err: err}
+			}
+		}
+		return &stubConn{ctx: ctx, result: result, sendDone: make(chan struct{})}, nil
+	}
+}
+
+type stubConn struct {
+	ctx      context.Context
+	result   *chipper.IntentResult
+	sendDone chan struct{}
+	sendOnce sync.Once
+}
+
+func (c *stubConn) Close() error {
+	return c.CloseSend()
+}
+
+func (c *stubConn) CloseSend() error {
+	c.sendOnce.Do(func() {
+		close(c.sendDone)
+	})
+	return nil
+}
+
+func (c *stubConn) SendAudio([]byte) error {
+	return nil
+}
+
+func (c *stubConn) WaitForResponse() (interface{}, error) {
+	select {
+	case <-c.sendDone:
+	case <-c.ctx.Done():
+		return nil, c.ctx.Err()
+	}
+	return &chipper.IntentGraphResponse{
+		ResponseType: pb.IntentGraphMode_INTENT,
+		IsFinal:      true,
+		IntentResult: c.result,
+	}, nil
+}
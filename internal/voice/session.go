@@ -0,0 +1,124 @@
+package voice
+
+import (
+	"time"
+
+	"github.com/digital-dream-labs/vector-cloud/internal/clad/cloud"
+
+	"github.com/digital-dream-labs/vector-cloud/internal/log"
+	"github.com/digital-dream-labs/vector-cloud/internal/voice/recording"
+	"github.com/digital-dream-labs/vector-cloud/internal/voice/stream"
+)
+
+// client holds the requests in flight for a single Receiver. Each Receiver can have
+// one voice request and one connection check active at a time, independently of
+// every other Receiver.
+type client struct {
+	recv    *Receiver
+	request *session
+	check   *session
+	preroll *preroll
+}
+
+// session is a single stream opened on behalf of a client
+type session struct {
+	client *client
+	strm   *stream.Streamer
+	// id is the session ID the server assigned the stream, once it's opened
+	id        string
+	recording *recording.Session
+	// started is when the session was requested, to measure latency from
+	started time.Time
+}
+
+// sessions tracks every active stream, so results coming back from a stream can be
+// routed to the client that started it
+type sessions struct {
+	clients map[*Receiver]*client
+	streams map[*stream.Streamer]*session
+}
+
+func newSessions() *sessions {
+	return &sessions{
+		clients: make(map[*Receiver]*client),
+		streams: make(map[*stream.Streamer]*session),
+	}
+}
+
+// client returns the state for the given receiver, creating it if necessary
+func (s *sessions) client(r *Receiver, prerollSamples int) *client {
+	c, ok := s.clients[r]
+	if !ok {
+		c = &client{recv: r}
+		if prerollSamples > 0 {
+			c.preroll = newPreroll(prerollSamples)
+		}
+		s.clients[r] = c
+	}
+	return c
+}
+
+// add starts tracking a new stream for the given client
+func (s *sessions) add(c *client, strm *stream.Streamer) *session {
+	sess := &session{client: c, strm: strm, started: time.Now()}
+	s.streams[strm] = sess
+	return sess
+}
+
+// lookup returns the active session for the given stream, or nil if the stream has
+// since been closed or replaced
+func (s *sessions) lookup(strm *stream.Streamer) *session {
+	return s.streams[strm]
+}
+
+// close stops the given session's stream and forgets it
+func (s *sessions) close(sess *session) {
+	if sess == nil {
+		return
+	}
+	if err := sess.strm.Close(); err != nil {
+		log.Println("Error closing context:", err)
+	}
+	delete(s.streams, sess.strm)
+	if sess.client.request == sess {
+		sess.client.request = nil
+	}
+	if sess.client.check == sess {
+		sess.client.check = nil
+	}
+}
+
+// all returns every active session
+func (s *sessions) all() []*session {
+	ret := make([]*session, 0, len(s.streams))
+	for _, sess := range s.streams {
+		ret = append(ret, sess)
+	}
+	return ret
+}
+
+// writeResponse sends a message to the AI on behalf of the given client: to the
+// client's own intent writer if it has one, otherwise to all intent writers
+func (p *Process) writeResponse(c *client, response *cloud.Message) {
+	writers := p.intents
+	if c != nil && c.recv.intents != nil {
+		writers = []MsgSender{c.recv.intents}
+	}
+	for _, w := range writers {
+		if err := w.Send(response); err != nil {
+			log.Println("AI write error:", err)
+		}
+	}
+}
+
+func (p *Process) writeError(c *client, reason cloud.ErrorType, err error) {
+	p.metrics.AddError(reason)
+	p.writeResponse(c, cloud.NewMessageWithError(&cloud.IntentError{Error: reason, Extra: err.Error()}))
+}
+
+// signalMicStop tells the given client's mic to stop sending audio
+func (p *Process) signalMicStop(c *client) {
+	if err := c.recv.writeBack(cloud.NewMessageWithStopSignal(&cloud.Void{})); err != nil {
+		log.Println("Mic write error:", err)
+	}
+}
@@ -20,6 +20,7 @@ func NewStreamer(ctx context.Context, receiver Receiver, streamSize int, opts ..
 	// set default connector before applying options
 	strm.opts.connectFn = strm.newChipperConn
 	strm.opts.streamOpts = new(chipper.StreamOpts)
+	strm.opts.metrics = DefaultMetrics
 	for _, o := range opts {
 		o(&strm.opts)
 	}
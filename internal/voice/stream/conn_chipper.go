@@ -1,11 +1,14 @@
 package stream
 
 import (
+	"context"
 	"errors"
+	"sync"
 
 	"github.com/digital-dream-labs/vector-cloud/internal/util"
 
 	"github.com/digital-dream-labs/api-clients/chipper"
+	"google.golang.org/grpc"
 )
 
 type Conn interface {
@@ -15,9 +18,26 @@ type Conn interface {
 	WaitForResponse() (interface{}, error)
 }
 
+// SessionConn is a Conn with its own session ID, which the streamer reports to its
+// receiver once connected; chipper connections report theirs themselves
+type SessionConn interface {
+	Conn
+	Session() string
+}
+
 type chipperConn struct {
 	conn   *chipper.Conn
 	stream chipper.Stream
+	// interim receives the non-final responses of an intent graph stream, which
+	// chipper's WaitForResponse reads and drops; nil for other streams
+	interim  chan *chipper.IntentGraphResponse
+	final    chan response
+	waitOnce sync.Once
+}
+
+type response struct {
+	resp interface{}
+	err  error
 }
 
 func (c *chipperConn) Close() error {
@@ -43,5 +63,76 @@ func (c *chipperConn) SendAudio(samples []byte) error {
 }
 
 func (c *chipperConn) WaitForResponse() (interface{}, error) {
-	return c.stream.WaitForResponse()
+	if c.interim == nil {
+		return c.stream.WaitForResponse()
+	}
+	c.waitOnce.Do(func() {
+		c.final = make(chan response, 1)
+		go func() {
+			resp, err := c.stream.WaitForResponse()
+			c.final <- response{resp, err}
+		}()
+	})
+	// interim is unbuffered, so every interim response has been taken before chipper
+	// reads the final one
+	select {
+	case r := <-c.interim:
+		return r, nil
+	case r := <-c.final:
+		return r.resp, r.err
+	}
+}
+
+// chipperInterceptor counts the encoded audio sent on a stream as uploaded, and, if
+// interim is non-nil, passes the interim transcripts received on it to interim as
+// chipper reads them
+func chipperInterceptor(metrics *Metrics, interim chan<- *chipper.IntentGraphResponse) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string,
+		streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		s, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			return nil, err
+		}
+		return &chipperStream{ClientStream: s, ctx: ctx, metrics: metrics, interim: interim}, nil
+	}
+}
+
+type chipperStream struct {
+	grpc.ClientStream
+	ctx     context.Context
+	metrics *Metrics
+	interim chan<- *chipper.IntentGraphResponse
+}
+
+// audioRequest is implemented by the requests of every kind of chipper stream
+type audioRequest interface {
+	GetInputAudio() []byte
+}
+
+func (s *chipperStream) SendMsg(m interface{}) error {
+	if err := s.ClientStream.SendMsg(m); err != nil {
+		return err
+	}
+	// chipper has encoded the audio by now, so this is what was really uploaded
+	if r, ok := m.(audioRequest); ok {
+		s.metrics.AddBytes(len(r.GetInputAudio()))
+	}
+	return nil
+}
+
+func (s *chipperStream) RecvMsg(m interface{}) error {
+	if err := s.ClientStream.RecvMsg(m); err != nil {
+		return err
+	}
+	if s.interim == nil {
+		return nil
+	}
+	// chipper allocates each message it reads, so it can be handed on as it is
+	if r, ok := m.(*chipper.IntentGraphResponse); ok && !r.IsFinal && r.SpeechResult != nil {
+		select {
+		case s.interim <- r:
+		case <-s.ctx.Done():
+		}
+	}
+	return nil
 }
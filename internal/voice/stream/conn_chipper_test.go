@@ -0,0 +1,145 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/digital-dream-labs/vector-cloud/internal/clad/cloud"
+
+	"github.com/digital-dream-labs/api-clients/chipper"
+	pb "github.com/digital-dream-labs/api/go/chipperpb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// interimServer answers the first audio of an intent graph stream with an interim
+// transcript, and the end of audio with an intent
+type interimServer struct {
+	pb.UnimplementedChipperGrpcServer
+}
+
+func (s *interimServer) StreamingIntentGraph(srv pb.ChipperGrpc_StreamingIntentGraphServer) error {
+	if _, err := srv.Recv(); err != nil {
+		return err
+	}
+	if err := srv.Send(&pb.IntentGraphResponse{
+		ResponseType: pb.IntentGraphMode_INTENT,
+		SpeechResult: &pb.SpeechResult{Transcript: "set a timer"},
+	}); err != nil {
+		return err
+	}
+	for {
+		if _, err := srv.Recv(); err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+	return srv.Send(&pb.IntentGraphResponse{
+		ResponseType: pb.IntentGraphMode_INTENT,
+		IsFinal:      true,
+		IntentResult: &pb.IntentResult{Action: "intent_clock_settimer"},
+	})
+}
+
+// finalOnlyStream reads responses the way chipper's intent graph stream does, which
+// needs TLS to a trusted server to be used directly
+type finalOnlyStream struct {
+	client pb.ChipperGrpc_StreamingIntentGraphClient
+}
+
+func (s *finalOnlyStream) SendAudio(data []byte) error {
+	return s.client.Send(&pb.StreamingIntentGraphRequest{InputAudio: data})
+}
+
+func (s *finalOnlyStream) WaitForResponse() (interface{}, error) {
+	for {
+		resp := new(chipper.IntentGraphResponse)
+		if err := s.client.RecvMsg(resp); err != nil {
+			return nil, err
+		} else if !resp.IsFinal {
+			continue
+		}
+		return resp, nil
+	}
+}
+
+func (s *finalOnlyStream) Close() error {
+	return s.client.CloseSend()
+}
+
+func (s *finalOnlyStream) CloseSend() error {
+	return s.client.CloseSend()
+}
+
+// dialInterimServer opens an intent graph stream to an interimServer through the
+// interceptor chipper connections use
+func dialInterimServer(t *testing.T, metrics *Metrics) *chipperConn {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := grpc.NewServer()
+	pb.RegisterChipperGrpcServer(server, &interimServer{})
+	go server.Serve(l)
+	t.Cleanup(server.Stop)
+
+	c := &chipperConn{interim: make(chan *chipper.IntentGraphResponse)}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	rpcConn, err := grpc.DialContext(ctx, l.Addr().String(), grpc.WithInsecure(),
+		grpc.WithChainStreamInterceptor(chipperInterceptor(metrics, c.interim)))
+	require.NoError(t, err)
+	t.Cleanup(func() { rpcConn.Close() })
+	client, err := pb.NewChipperGrpcClient(rpcConn).StreamingIntentGraph(ctx)
+	require.NoError(t, err)
+	c.stream = &finalOnlyStream{client}
+	return c
+}
+
+func TestChipperInterimResponses(t *testing.T) {
+	c := dialInterimServer(t, NewMetrics())
+
+	// the interim transcript the stream skips over is still returned
+	require.NoError(t, c.SendAudio(make([]byte, 100)))
+	resp, err := c.WaitForResponse()
+	require.NoError(t, err)
+	interim, ok := resp.(*chipper.IntentGraphResponse)
+	require.True(t, ok)
+	assert.False(t, interim.IsFinal)
+	assert.Equal(t, "set a timer", interim.SpeechResult.Transcript)
+
+	// followed by the final response
+	require.NoError(t, c.CloseSend())
+	resp, err = c.WaitForResponse()
+	require.NoError(t, err)
+	final, ok := resp.(*chipper.IntentGraphResponse)
+	require.True(t, ok)
+	assert.True(t, final.IsFinal)
+	assert.Equal(t, "intent_clock_settimer", final.IntentResult.Action)
+}
+
+func TestChipperUploadMetrics(t *testing.T) {
+	metrics := NewMetrics()
+	c := dialInterimServer(t, metrics)
+
+	// what's counted is what goes on the wire, not the audio handed to the stream
+	require.NoError(t, c.SendAudio(make([]byte, 100)))
+	require.NoError(t, c.SendAudio(make([]byte, 60)))
+	assert.Equal(t, uint64(160), metrics.Bytes())
+}
+
+func TestEndpointFault(t *testing.T) {
+	// endpoints are only failed over for errors reaching them
+	assert.True(t, endpointFault(&CloudError{cloud.ErrorType_Connecting, errors.New("dial failed")}))
+	assert.True(t, endpointFault(&CloudError{cloud.ErrorType_NewStream, status.Error(codes.Unavailable, "down")}))
+	assert.True(t, endpointFault(&CloudError{cloud.ErrorType_NewStream, status.Error(codes.DeadlineExceeded, "slow")}))
+
+	// not for the server refusing the request, which any of them would
+	assert.False(t, endpointFault(&CloudError{cloud.ErrorType_NewStream, status.Error(codes.Unauthenticated, "bad token")}))
+	assert.False(t, endpointFault(&CloudError{cloud.ErrorType_NewStream, status.Error(codes.InvalidArgument, "bad request")}))
+}
@@ -18,7 +18,10 @@ import (
 
 	"github.com/digital-dream-labs/api-clients/chipper"
 	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
 )
 
 const (
@@ -97,9 +100,13 @@ func (strm *Streamer) newChipperConn(ctx context.Context) (Conn, *CloudError) {
 
 	sessionID := uuid.New().String()[:16]
 	var c chipperConn
+	if strm.opts.intentGraphOpts != nil {
+		c.interim = make(chan *chipper.IntentGraphResponse)
+	}
+	grpcOpts := []grpc.DialOption{grpc.WithChainStreamInterceptor(chipperInterceptor(strm.opts.metrics, c.interim))}
 	var cerr *CloudError
 	connectTime := util.TimeFuncMs(func() {
-		c.conn, c.stream, cerr = strm.openChipperStream(ctx, creds, sessionID)
+		c.conn, c.stream, cerr = strm.openChipperStream(ctx, creds, sessionID, grpcOpts)
 	})
 	if cerr != nil {
 		log.Println("Error creating Chipper:", cerr.Err)
@@ -116,10 +123,75 @@ func (strm *Streamer) newChipperConn(ctx context.Context) (Conn, *CloudError) {
 }
 
 func (strm *Streamer) openChipperStream(ctx context.Context, creds credentials.PerRPCCredentials,
-	sessionID string) (*chipper.Conn, chipper.Stream, *CloudError) {
+	sessionID string, grpcOpts []grpc.DialOption) (*chipper.Conn, chipper.Stream, *CloudError) {
+
+	if strm.opts.endpoints == nil {
+		return strm.openChipperStreamURL(ctx, 0, strm.opts.url, creds, sessionID, grpcOpts)
+	}
+
+	// try each endpoint in turn, putting ones that fail into cool-down
+	urls := strm.opts.endpoints.Candidates()
+	if len(urls) == 0 {
+		return nil, nil, &CloudError{cloud.ErrorType_InvalidConfig, errors.New("no chipper endpoints configured")}
+	}
+	// an endpoint that silently drops packets would otherwise use up the whole
+	// stream's deadline, so each gets a limited time to connect
+	timeout := strm.opts.endpoints.AttemptTimeout()
+	var cerr *CloudError
+	for i, url := range urls {
+		var conn *chipper.Conn
+		var stream chipper.Stream
+		start := time.Now()
+		conn, stream, cerr = strm.openChipperStreamURL(ctx, timeout, url, creds, sessionID, grpcOpts)
+		if cerr == nil {
+			strm.opts.endpoints.OnSuccess(url, time.Since(start))
+			return conn, stream, nil
+		}
+		if ctx.Err() != nil || !endpointFault(cerr) {
+			// stream was closed or timed out, or the request itself was refused; that's
+			// not the endpoint's fault, and another wouldn't do better
+			return nil, nil, cerr
+		}
+		var next string
+		if i+1 < len(urls) {
+			next = urls[i+1]
+		}
+		strm.opts.endpoints.OnFailure(url, cerr.Err, next)
+	}
+	return nil, nil, cerr
+}
+
+// endpointFault returns whether an error opening a stream means the endpoint couldn't
+// be reached or isn't serving, so that another should be tried
+func endpointFault(cerr *CloudError) bool {
+	if cerr.Kind == cloud.ErrorType_Connecting {
+		return true
+	}
+	switch status.Code(cerr.Err) {
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return true
+	}
+	return false
+}
 
-	opts := platformOpts
-	if grpcOpts := util.CommonGRPC(); grpcOpts != nil {
+// openChipperStreamURL opens a stream on the given server, with the given gRPC options
+// in addition to the common ones; if dialTimeout is non-zero, the connection must be
+// established within it
+func (strm *Streamer) openChipperStreamURL(ctx context.Context, dialTimeout time.Duration, url string,
+	creds credentials.PerRPCCredentials, sessionID string, extraGrpcOpts []grpc.DialOption) (*chipper.Conn,
+	chipper.Stream, *CloudError) {
+
+	// platformOpts is shared by every stream, copy it before adding to it
+	opts := append([]chipper.ConnOpt(nil), platformOpts...)
+	grpcOpts := append(append([]grpc.DialOption(nil), util.CommonGRPC()...), extraGrpcOpts...)
+	dialCtx := ctx
+	if dialTimeout > 0 {
+		var cancel context.CancelFunc
+		dialCtx, cancel = context.WithTimeout(ctx, dialTimeout)
+		defer cancel()
+		grpcOpts = append(grpcOpts, grpc.WithBlock())
+	}
+	if len(grpcOpts) > 0 {
 		opts = append(opts, chipper.WithGrpcOptions(grpcOpts...))
 	}
 	if creds != nil {
@@ -128,7 +200,7 @@ func (strm *Streamer) openChipperStream(ctx context.Context, creds credentials.P
 	opts = append(opts, chipper.WithSessionID(sessionID))
 	opts = append(opts, chipper.WithFirmwareVersion(robot.OSVersion()))
 	opts = append(opts, chipper.WithBootID(robot.BootID()))
-	conn, err := chipper.NewConn(ctx, strm.opts.url, "", opts...)
+	conn, err := chipper.NewConn(dialCtx, url, "", opts...)
 	if err != nil {
 		log.Println("Error getting chipper connection:", err)
 		return nil, nil, &CloudError{cloud.ErrorType_Connecting, err}
@@ -147,6 +219,7 @@ func (strm *Streamer) openChipperStream(ctx context.Context, creds credentials.P
 	}
 
 	if err != nil {
+		conn.Close()
 		return nil, nil, &CloudError{cloud.ErrorType_NewStream, err}
 	}
 	return conn, stream, nil
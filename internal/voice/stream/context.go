@@ -29,6 +29,9 @@ func (strm *Streamer) sendAudio(samples []byte) error {
 		return err
 	}
 	logVerbose("Sent", len(samples), "bytes to Chipper (call took", int(sendTime), "ms)")
+	if strm.opts.sendObserver != nil {
+		strm.opts.sendObserver(sendTime)
+	}
 	return nil
 }
 
@@ -39,12 +42,22 @@ func (strm *Streamer) bufferRoutine(streamSize int) {
 	defer close(strm.byteChan)
 	defer close(strm.audioStream)
 	audioBuf := make([]byte, 0, streamSize*2)
+	var detector *vad
+	if strm.opts.vadOpts != nil && strm.opts.checkOpts == nil {
+		detector = newVAD(*strm.opts.vadOpts)
+	}
+	var speechEnded, endSent bool
 	// function to enable/disable streaming case depending on whether we have enough bytes
 	// to send audio
 	streamData := func() (chan<- []byte, []byte) {
 		if len(audioBuf) >= streamSize {
 			return strm.audioStream, audioBuf[:streamSize]
 		}
+		if speechEnded && !endSent {
+			// flush what's left; once empty, this signals the upload routine that
+			// speech is over
+			return strm.audioStream, audioBuf
+		}
 		return nil, nil
 	}
 	for {
@@ -53,8 +66,14 @@ func (strm *Streamer) bufferRoutine(streamSize int) {
 		streamChan, streamBuf := streamData()
 		select {
 		case streamChan <- streamBuf:
-			audioBuf = audioBuf[streamSize:]
+			if len(streamBuf) == 0 {
+				endSent = true
+			}
+			audioBuf = audioBuf[len(streamBuf):]
 		case buf := <-strm.byteChan:
+			if detector != nil {
+				buf, speechEnded = detector.process(buf)
+			}
 			audioBuf = append(audioBuf, buf...)
 		case <-strm.ctx.Done():
 			return
@@ -90,6 +109,12 @@ func (strm *Streamer) testRoutine(streamSize int) {
 // to send back to the main routine on the given channels
 func (strm *Streamer) responseRoutine() {
 	resp, err := strm.conn.WaitForResponse()
+	if err == nil {
+		strm.opts.metrics.FirstByte.Observe(util.TimeSinceMs(strm.firstSend))
+	}
+	for err == nil && strm.sendPartial(resp) {
+		resp, err = strm.conn.WaitForResponse()
+	}
 	strm.respOnce.Do(func() {
 		if strm.closed {
 			if err != nil {
@@ -122,6 +147,29 @@ func (strm *Streamer) responseRoutine() {
 	})
 }
 
+// sendPartial passes interim transcripts on to the receiver, and returns true if
+// the given response was one
+func (strm *Streamer) sendPartial(resp interface{}) bool {
+	var transcript *Transcript
+	switch r := resp.(type) {
+	case *Transcript:
+		transcript = r
+	case *chipper.IntentGraphResponse:
+		if r.IsFinal || r.SpeechResult == nil {
+			return false
+		}
+		transcript = &Transcript{Text: r.SpeechResult.Transcript, IsFinal: r.SpeechResult.IsFinal}
+	default:
+		return false
+	}
+	if strm.closed {
+		return true
+	}
+	logVerbose("Partial transcript ->", transcript.Text)
+	strm.receiver.OnPartialTranscript(transcript.Text, transcript.IsFinal)
+	return true
+}
+
 func (strm *Streamer) cancelResponse() {
 	done := strm.ctx.Done()
 	if done == nil {
@@ -0,0 +1,164 @@
+package stream
+
+import (
+	"sync"
+	"time"
+
+	"github.com/digital-dream-labs/vector-cloud/internal/log"
+	"github.com/digital-dream-labs/vector-cloud/internal/util"
+)
+
+const (
+	// DefaultEndpointCooldown is how long a chipper endpoint is skipped after its first
+	// failure; repeated failures double this, up to MaxEndpointCooldown
+	DefaultEndpointCooldown = 30 * time.Second
+	// MaxEndpointCooldown is the longest an unhealthy endpoint will be skipped for
+	MaxEndpointCooldown = 10 * time.Minute
+	// DefaultAttemptTimeout is how long connecting to one endpoint may take before
+	// the stream fails over to the next
+	DefaultAttemptTimeout = 3 * time.Second
+)
+
+// Endpoints tracks the health of an ordered list of chipper servers, so that streams
+// can fail over to the next server when one is unreachable. It is safe to share one
+// instance between streams.
+type Endpoints struct {
+	mu        sync.Mutex
+	endpoints []*endpoint
+	cooldown  time.Duration
+	attempt   time.Duration
+	listener  util.ErrorListener
+}
+
+type endpoint struct {
+	url       string
+	failures  int
+	downUntil time.Time
+	latency   time.Duration
+}
+
+// NewEndpoints returns an endpoint set for the given URLs, in order of preference.
+// Failovers are reported to the given listener, which may be nil.
+func NewEndpoints(urls []string, listener util.ErrorListener) *Endpoints {
+	e := &Endpoints{cooldown: DefaultEndpointCooldown, attempt: DefaultAttemptTimeout, listener: listener}
+	for _, url := range urls {
+		e.endpoints = append(e.endpoints, &endpoint{url: url})
+	}
+	return e
+}
+
+// SetCooldown changes the base duration an endpoint is skipped for after failing
+func (e *Endpoints) SetCooldown(cooldown time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.cooldown = cooldown
+}
+
+// SetAttemptTimeout changes how long connecting to one endpoint may take
+func (e *Endpoints) SetAttemptTimeout(timeout time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.attempt = timeout
+}
+
+// AttemptTimeout returns how long connecting to one endpoint may take
+func (e *Endpoints) AttemptTimeout() time.Duration {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.attempt
+}
+
+// Candidates returns the URLs that should be tried, in order: healthy endpoints in
+// order of preference, followed by endpoints in cool-down ordered by which comes out
+// of it soonest (so there's always something to try)
+func (e *Endpoints) Candidates() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	now := time.Now()
+	var healthy, cooling []*endpoint
+	for _, ep := range e.endpoints {
+		if now.Before(ep.downUntil) {
+			cooling = append(cooling, ep)
+		} else {
+			healthy = append(healthy, ep)
+		}
+	}
+	// few endpoints - insertion sort is fine
+	for i := 1; i < len(cooling); i++ {
+		for j := i; j > 0 && cooling[j].downUntil.Before(cooling[j-1].downUntil); j-- {
+			cooling[j], cooling[j-1] = cooling[j-1], cooling[j]
+		}
+	}
+	ret := make([]string, 0, len(e.endpoints))
+	for _, ep := range append(healthy, cooling...) {
+		ret = append(ret, ep.url)
+	}
+	return ret
+}
+
+// OnSuccess records that a stream was opened on the given endpoint in the given time
+func (e *Endpoints) OnSuccess(url string, latency time.Duration) {
+	e.mu.Lock()
+	ep := e.find(url)
+	if ep != nil {
+		if ep.failures > 0 {
+			log.Println("Chipper endpoint", url, "is healthy again")
+		}
+		ep.failures = 0
+		ep.downUntil = time.Time{}
+		ep.latency = latency
+	}
+	e.mu.Unlock()
+	log.Das("chipper.endpoint.connect", (&log.DasFields{}).SetStrings(url).
+		SetInts(int(latency/time.Millisecond)))
+}
+
+// OnFailure records that the given endpoint could not be reached and puts it into
+// cool-down. If next is non-empty, the stream is failing over to that endpoint.
+func (e *Endpoints) OnFailure(url string, err error, next string) {
+	e.mu.Lock()
+	var cooldown time.Duration
+	if ep := e.find(url); ep != nil {
+		cooldown = e.cooldown << uint(ep.failures)
+		if cooldown <= 0 || cooldown > MaxEndpointCooldown {
+			cooldown = MaxEndpointCooldown
+		}
+		ep.failures++
+		ep.downUntil = time.Now().Add(cooldown)
+	}
+	listener := e.listener
+	e.mu.Unlock()
+
+	log.Println("Chipper endpoint", url, "failed, cooling down for", cooldown, "-", err)
+	if next != "" {
+		log.Das("chipper.endpoint.failover", (&log.DasFields{}).SetStrings(url, next, err.Error()).
+			SetInts(int(cooldown/time.Second)))
+	} else {
+		log.Das("chipper.endpoint.failed", (&log.DasFields{}).SetStrings(url, err.Error()).
+			SetInts(int(cooldown/time.Second)))
+	}
+	if listener != nil {
+		// pass the original error along, listeners may care about its gRPC status
+		listener.OnError(err)
+	}
+}
+
+// Latency returns the time it last took to open a stream on the given endpoint, or 0
+// if it's never been used
+func (e *Endpoints) Latency(url string) time.Duration {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if ep := e.find(url); ep != nil {
+		return ep.latency
+	}
+	return 0
+}
+
+func (e *Endpoints) find(url string) *endpoint {
+	for _, ep := range e.endpoints {
+		if ep.url == url {
+			return ep
+		}
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package stream_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/digital-dream-labs/vector-cloud/internal/clad/cloud"
+	"github.com/digital-dream-labs/vector-cloud/internal/voice/stream"
+
+	"github.com/digital-dream-labs/api-clients/chipper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type errCounter struct {
+	errs []error
+}
+
+func (e *errCounter) OnError(err error) {
+	e.errs = append(e.errs, err)
+}
+
+func TestEndpointFailover(t *testing.T) {
+	listener := &errCounter{}
+	e := stream.NewEndpoints([]string{"a:443", "b:443", "c:443"}, listener)
+	assert.Equal(t, []string{"a:443", "b:443", "c:443"}, e.Candidates())
+
+	// a failing endpoint moves to the back until its cool-down expires
+	e.OnFailure("a:443", errors.New("unreachable"), "b:443")
+	assert.Equal(t, []string{"b:443", "c:443", "a:443"}, e.Candidates())
+	assert.Len(t, listener.errs, 1)
+
+	// endpoints in cool-down are ordered by which recovers first
+	e.OnFailure("b:443", errors.New("unreachable"), "c:443")
+	assert.Equal(t, []string{"c:443", "a:443", "b:443"}, e.Candidates())
+
+	// success restores an endpoint's place
+	e.OnSuccess("a:443", 20*time.Millisecond)
+	assert.Equal(t, []string{"a:443", "c:443", "b:443"}, e.Candidates())
+	assert.Equal(t, 20*time.Millisecond, e.Latency("a:443"))
+}
+
+func TestEndpointCooldownExpires(t *testing.T) {
+	e := stream.NewEndpoints([]string{"a:443", "b:443"}, nil)
+	e.SetCooldown(time.Millisecond)
+	e.OnFailure("a:443", errors.New("unreachable"), "b:443")
+	assert.Equal(t, []string{"b:443", "a:443"}, e.Candidates())
+	time.Sleep(5 * time.Millisecond)
+	assert.Equal(t, []string{"a:443", "b:443"}, e.Candidates())
+}
+
+// blackhole accepts connections and never answers, like a server whose packets are dropped
+func blackhole(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		var conns []net.Conn
+		defer func() {
+			for _, c := range conns {
+				c.Close()
+			}
+		}()
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conns = append(conns, c)
+		}
+	}()
+	return l.Addr().String()
+}
+
+func TestEndpointAttemptTimeout(t *testing.T) {
+	listener := &errCounter{}
+	urls := []string{blackhole(t), blackhole(t)}
+	e := stream.NewEndpoints(urls, listener)
+	e.SetAttemptTimeout(50 * time.Millisecond)
+
+	// each endpoint gets its own short deadline, within the stream's
+	receiver := newReceiver()
+	start := time.Now()
+	stream.NewStreamer(context.Background(), receiver, 1, stream.WithChipperEndpoints(e),
+		stream.WithIntentOptions(chipper.IntentOpts{StreamOpts: chipper.StreamOpts{Timeout: 10 * time.Second}},
+			cloud.StreamType_Normal))
+
+	err := <-receiver.err
+	assert.Equal(t, cloud.ErrorType_Connecting, err.Kind)
+	assert.True(t, time.Since(start) < 5*time.Second)
+	assert.Len(t, listener.errs, 2)
+	assert.Equal(t, []string{urls[0], urls[1]}, e.Candidates())
+}
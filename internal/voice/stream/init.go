@@ -1,5 +1,12 @@
 package stream
 
+import (
+	"context"
+	"time"
+
+	"github.com/digital-dream-labs/vector-cloud/internal/log"
+)
+
 func (strm *Streamer) init(streamSize int) {
 	// set up error response if context times out/is canceled
 	go strm.cancelResponse()
@@ -12,20 +19,34 @@ func (strm *Streamer) init(streamSize int) {
 
 	// connect to server
 	var err *CloudError
-	if strm.conn, err = strm.opts.connectFn(strm.ctx); err != nil {
+	ctx := context.WithValue(strm.ctx, languageKey{}, strm.opts.streamOpts.Language)
+	if strm.conn, err = strm.opts.connectFn(ctx); err != nil {
 		strm.receiver.OnError(err.Kind, err.Err)
 		strm.cancel()
 		return
 	}
+	if conn, ok := strm.conn.(SessionConn); ok {
+		// the _absence_ of this is used to detect server timeout errors
+		strm.receiver.OnStreamOpen(conn.Session())
+	}
 
 	// start routine to upload audio via GRPC until response or error
 	go func() {
 		responseInited := false
 		for data := range strm.audioStream {
+			if len(data) == 0 {
+				// VAD detected the end of speech, and everything before it has been sent
+				logVerbose("End of speech detected, closing stream send")
+				if err := strm.conn.CloseSend(); err != nil {
+					log.Println("Error closing stream send:", err)
+				}
+				continue
+			}
 			if err := strm.sendAudio(data); err != nil {
 				return
 			}
 			if !responseInited {
+				strm.firstSend = time.Now()
 				go strm.responseRoutine()
 				responseInited = true
 			}
@@ -0,0 +1,220 @@
+package localconn
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+
+	"github.com/digital-dream-labs/api-clients/chipper"
+)
+
+// Rule maps a set of spoken patterns to a single intent. Patterns are plain
+// words, with two special tokens: `{name}` captures one or more words into the
+// intent parameter `name`, and `*` matches any number of words.
+type Rule struct {
+	Intent   string   `json:"intent"`
+	Patterns []string `json:"patterns"`
+	// Parameters are static parameters attached to every match of this rule
+	Parameters map[string]string `json:"parameters,omitempty"`
+}
+
+// Grammar is an ordered set of rules used to match transcribed speech to intents
+type Grammar struct {
+	Rules []Rule `json:"rules"`
+	// Unmatched is the intent returned when no rule matches; if blank,
+	// DefaultUnmatchedIntent is used
+	Unmatched string `json:"unmatched,omitempty"`
+
+	compiled []compiledRule
+}
+
+// DefaultUnmatchedIntent is returned when speech was heard but no rule matched it
+const DefaultUnmatchedIntent = "intent_system_unmatched"
+
+type compiledRule struct {
+	rule    *Rule
+	pattern *regexp.Regexp
+	words   int
+}
+
+// NewGrammar compiles the given rules into a Grammar
+func NewGrammar(rules ...Rule) (*Grammar, error) {
+	g := &Grammar{Rules: rules}
+	if err := g.compile(); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// LoadGrammar reads a JSON encoded Grammar from the given file
+func LoadGrammar(filename string) (*Grammar, error) {
+	buf, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var g Grammar
+	if err := json.Unmarshal(buf, &g); err != nil {
+		return nil, err
+	}
+	if err := g.compile(); err != nil {
+		return nil, err
+	}
+	return &g, nil
+}
+
+// DefaultGrammar returns a small grammar covering basic robot commands, used when
+// no grammar is configured
+func DefaultGrammar() *Grammar {
+	g, err := NewGrammar(
+		Rule{Intent: "intent_greeting_hello", Patterns: []string{"hello", "hi", "hey"}},
+		Rule{Intent: "intent_greeting_goodbye", Patterns: []string{"goodbye", "bye"}},
+		Rule{Intent: "intent_imperative_come", Patterns: []string{"come here", "come to me"}},
+		Rule{Intent: "intent_imperative_lookatme", Patterns: []string{"look at me"}},
+		Rule{Intent: "intent_imperative_dance", Patterns: []string{"dance"}},
+		Rule{Intent: "intent_imperative_quiet", Patterns: []string{"be quiet", "quiet", "shush"}},
+		Rule{Intent: "intent_system_sleep", Patterns: []string{"go to sleep", "sleep"}},
+		Rule{Intent: "intent_clock_time", Patterns: []string{"what time is it", "what's the time"}},
+		Rule{Intent: "intent_names_ask", Patterns: []string{"what's my name", "what is my name"}},
+		Rule{Intent: "intent_clock_settimer_extend", Patterns: []string{"set a timer for {timer_duration}",
+			"timer for {timer_duration}"}},
+	)
+	if err != nil {
+		// the default rules are static; failing to compile them is a programming error
+		panic(err)
+	}
+	return g
+}
+
+// Match returns the intent that best matches the given transcript. The second return
+// value is false if no rule matched, in which case the Unmatched intent is returned.
+func (g *Grammar) Match(text string) (*chipper.IntentResult, bool) {
+	text = normalize(text)
+	words := len(strings.Fields(text))
+
+	var best *chipper.IntentResult
+	var bestScore float32
+	for i := range g.compiled {
+		c := &g.compiled[i]
+		match := c.pattern.FindStringSubmatch(text)
+		if match == nil {
+			continue
+		}
+		// score by how much of the utterance the literal part of the pattern covers, so
+		// "look at me" beats "look" for the utterance "look at me"
+		score := float32(1)
+		if words > 0 {
+			score = float32(c.words) / float32(words)
+			if score > 1 {
+				score = 1
+			}
+		}
+		if best != nil && score <= bestScore {
+			continue
+		}
+		params := make(map[string]string, len(c.rule.Parameters))
+		for k, v := range c.rule.Parameters {
+			params[k] = v
+		}
+		for i, name := range c.pattern.SubexpNames() {
+			if name != "" {
+				params[name] = strings.TrimSpace(match[i])
+			}
+		}
+		best = &chipper.IntentResult{
+			QueryText:            text,
+			Action:               c.rule.Intent,
+			IntentConfidence:     score,
+			Parameters:           params,
+			AllParametersPresent: true,
+		}
+		bestScore = score
+	}
+	if best != nil {
+		return best, true
+	}
+	unmatched := g.Unmatched
+	if unmatched == "" {
+		unmatched = DefaultUnmatchedIntent
+	}
+	return &chipper.IntentResult{QueryText: text, Action: unmatched}, false
+}
+
+func (g *Grammar) compile() error {
+	g.compiled = g.compiled[:0]
+	for i := range g.Rules {
+		rule := &g.Rules[i]
+		if rule.Intent == "" {
+			return fmt.Errorf("grammar rule %d has no intent", i)
+		}
+		for _, p := range rule.Patterns {
+			re, words, err := compilePattern(p)
+			if err != nil {
+				return fmt.Errorf("intent %s: %s", rule.Intent, err)
+			}
+			g.compiled = append(g.compiled, compiledRule{rule: rule, pattern: re, words: words})
+		}
+	}
+	return nil
+}
+
+var slotRegex = regexp.MustCompile(`^\{([a-z_][a-z0-9_]*)\}$`)
+
+func compilePattern(pattern string) (*regexp.Regexp, int, error) {
+	tokens := strings.Fields(normalizePattern(pattern))
+	if len(tokens) == 0 {
+		return nil, 0, fmt.Errorf("empty pattern")
+	}
+	// tokens are separated by a space, except that a wildcard matching nothing
+	// leaves only one
+	var expr strings.Builder
+	sep, words := "", 0
+	for i, tok := range tokens {
+		switch {
+		case tok == "*" && i+1 < len(tokens) && tokens[i+1] == "*":
+			continue
+		case tok == "*" && i == len(tokens)-1:
+			expr.WriteString(`(?:` + sep + `\S+(?: \S+)*)?`)
+		case tok == "*":
+			expr.WriteString(sep + `(?:\S+ )*`)
+			sep = ""
+			continue
+		case slotRegex.MatchString(tok):
+			name := slotRegex.FindStringSubmatch(tok)[1]
+			expr.WriteString(sep + `(?P<` + name + `>\S+(?: \S+)*)`)
+			words++
+		default:
+			expr.WriteString(sep + regexp.QuoteMeta(tok))
+			words++
+		}
+		sep = " "
+	}
+	// patterns are keyword matches: they may appear anywhere in the utterance, but only
+	// on word boundaries
+	re, err := regexp.Compile(`(?:^| )` + expr.String() + `(?: |$)`)
+	if err != nil {
+		return nil, 0, err
+	}
+	return re, words, nil
+}
+
+var punctRegex = regexp.MustCompile(`[^a-z0-9' ]+`)
+
+// normalize lowercases the given text and strips punctuation, so transcripts from
+// different recognizers can be matched the same way
+func normalize(text string) string {
+	text = punctRegex.ReplaceAllString(strings.ToLower(text), " ")
+	return strings.Join(strings.Fields(text), " ")
+}
+
+func normalizePattern(pattern string) string {
+	fields := strings.Fields(strings.ToLower(pattern))
+	for i, f := range fields {
+		if f == "*" || slotRegex.MatchString(f) {
+			continue
+		}
+		fields[i] = normalize(f)
+	}
+	return strings.Join(fields, " ")
+}
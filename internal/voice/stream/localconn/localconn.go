@@ -0,0 +1,108 @@
+// Package localconn implements a stream.Conn that matches intents on the robot
+// itself, so basic voice commands keep working when the chipper server can't be
+// reached.
+package localconn
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/digital-dream-labs/api-clients/chipper"
+	pb "github.com/digital-dream-labs/api/go/chipperpb"
+)
+
+// Engine creates local connections that transcribe audio with a Recognizer and
+// match the resulting text against a Grammar
+type Engine struct {
+	recognizer Recognizer
+	grammar    *Grammar
+}
+
+// NewEngine returns an Engine using the given recognizer and grammar; if grammar
+// is nil, DefaultGrammar() is used
+func NewEngine(recognizer Recognizer, grammar *Grammar) *Engine {
+	if grammar == nil {
+		grammar = DefaultGrammar()
+	}
+	return &Engine{recognizer: recognizer, grammar: grammar}
+}
+
+// Connect starts a new local stream for a single utterance
+func (e *Engine) Connect(ctx context.Context, language pb.LanguageCode, session string) (*Conn, error) {
+	if e.recognizer == nil {
+		return nil, errors.New("no local speech recognizer configured")
+	}
+	rs, err := e.recognizer.NewSession(language)
+	if err != nil {
+		return nil, err
+	}
+	return &Conn{
+		ctx:       ctx,
+		session:   session,
+		recognize: rs,
+		grammar:   e.grammar,
+		sendDone:  make(chan struct{}),
+	}, nil
+}
+
+// Conn is a stream.Conn whose responses are produced on the device
+type Conn struct {
+	ctx       context.Context
+	session   string
+	recognize RecognizerSession
+	grammar   *Grammar
+	sendDone  chan struct{}
+	sendOnce  sync.Once
+	closeOnce sync.Once
+}
+
+// Session returns the session ID the stream was started with
+func (c *Conn) Session() string {
+	return c.session
+}
+
+// Close ends the stream and releases the recognizer
+func (c *Conn) Close() error {
+	c.CloseSend()
+	var err error
+	c.closeOnce.Do(func() {
+		err = c.recognize.Close()
+	})
+	return err
+}
+
+// CloseSend signals that the utterance is over, which allows WaitForResponse to return
+func (c *Conn) CloseSend() error {
+	c.sendOnce.Do(func() {
+		close(c.sendDone)
+	})
+	return nil
+}
+
+// SendAudio passes raw PCM audio on to the recognizer
+func (c *Conn) SendAudio(samples []byte) error {
+	return c.recognize.AcceptAudio(samples)
+}
+
+// WaitForResponse blocks until the utterance is over, and then returns a
+// *chipper.IntentGraphResponse with the matched intent
+func (c *Conn) WaitForResponse() (interface{}, error) {
+	select {
+	case <-c.sendDone:
+	case <-c.ctx.Done():
+		return nil, c.ctx.Err()
+	}
+	text, err := c.recognize.Final()
+	if err != nil {
+		return nil, err
+	}
+	result, _ := c.grammar.Match(text)
+	return &chipper.IntentGraphResponse{
+		Session:      c.session,
+		ResponseType: pb.IntentGraphMode_INTENT,
+		IsFinal:      true,
+		IntentResult: result,
+		QueryText:    result.QueryText,
+	}, nil
+}
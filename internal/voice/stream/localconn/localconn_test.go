@@ -0,0 +1,128 @@
+package localconn_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/digital-dream-labs/vector-cloud/internal/voice/stream"
+	"github.com/digital-dream-labs/vector-cloud/internal/voice/stream/localconn"
+
+	"github.com/digital-dream-labs/api-clients/chipper"
+	pb "github.com/digital-dream-labs/api/go/chipperpb"
+	"github.com/stretchr/testify/assert"
+)
+
+// fixedRecognizer "transcribes" every utterance to the same text
+type fixedRecognizer struct {
+	text  string
+	audio []byte
+}
+
+func (r *fixedRecognizer) NewSession(pb.LanguageCode) (localconn.RecognizerSession, error) {
+	return r, nil
+}
+
+func (r *fixedRecognizer) AcceptAudio(pcm []byte) error {
+	r.audio = append(r.audio, pcm...)
+	return nil
+}
+
+func (r *fixedRecognizer) Final() (string, error) {
+	return r.text, nil
+}
+
+func (r *fixedRecognizer) Close() error {
+	return nil
+}
+
+var _ stream.Conn = (*localconn.Conn)(nil)
+
+func TestGrammarMatch(t *testing.T) {
+	g := localconn.DefaultGrammar()
+
+	tests := []struct {
+		text    string
+		intent  string
+		matched bool
+		params  map[string]string
+	}{
+		{"Hey Vector, look at me!", "intent_imperative_lookatme", true, map[string]string{}},
+		{"go to sleep", "intent_system_sleep", true, map[string]string{}},
+		{"set a timer for five minutes", "intent_clock_settimer_extend", true,
+			map[string]string{"timer_duration": "five minutes"}},
+		{"what is the meaning of life", localconn.DefaultUnmatchedIntent, false, nil},
+		{"sleepy", localconn.DefaultUnmatchedIntent, false, nil},
+		{"comehere", localconn.DefaultUnmatchedIntent, false, nil},
+	}
+	for _, test := range tests {
+		res, ok := g.Match(test.text)
+		assert.Equal(t, test.matched, ok, test.text)
+		assert.Equal(t, test.intent, res.Action, test.text)
+		if test.params != nil {
+			assert.Equal(t, test.params, res.Parameters, test.text)
+		}
+	}
+
+	// words either side of a wildcard are still separate words
+	g, err := localconn.NewGrammar(localconn.Rule{Intent: "intent_play_blackjack", Patterns: []string{"play * blackjack"}})
+	assert.NoError(t, err)
+	for text, matched := range map[string]bool{
+		"play blackjack":           true,
+		"play a game of blackjack": true,
+		"playblackjack":            false,
+	} {
+		_, ok := g.Match(text)
+		assert.Equal(t, matched, ok, text)
+	}
+}
+
+func TestGrammarErrors(t *testing.T) {
+	_, err := localconn.NewGrammar(localconn.Rule{Patterns: []string{"hello"}})
+	assert.Error(t, err)
+	_, err = localconn.NewGrammar(localconn.Rule{Intent: "intent_foo", Patterns: []string{"  "}})
+	assert.Error(t, err)
+}
+
+func TestConnResponse(t *testing.T) {
+	rec := &fixedRecognizer{text: "dance"}
+	engine := localconn.NewEngine(rec, nil)
+	conn, err := engine.Connect(context.Background(), pb.LanguageCode_ENGLISH_US, "session")
+	assert.NoError(t, err)
+
+	assert.NoError(t, conn.SendAudio(make([]byte, 10)))
+	assert.NoError(t, conn.SendAudio(make([]byte, 10)))
+	assert.Len(t, rec.audio, 20)
+
+	respChan := make(chan interface{})
+	go func() {
+		resp, _ := conn.WaitForResponse()
+		respChan <- resp
+	}()
+
+	// no response until the utterance is over
+	select {
+	case <-respChan:
+		t.Fatal("response before CloseSend")
+	case <-time.After(5 * time.Millisecond):
+	}
+
+	conn.CloseSend()
+	resp := (<-respChan).(*chipper.IntentGraphResponse)
+	assert.True(t, chipper.IsIntent(*resp))
+	assert.True(t, resp.IsFinal)
+	assert.Equal(t, "session", resp.Session)
+	assert.Equal(t, "intent_imperative_dance", resp.IntentResult.Action)
+	assert.NoError(t, conn.Close())
+}
+
+func TestConnTimeout(t *testing.T) {
+	engine := localconn.NewEngine(&fixedRecognizer{text: "hello"}, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	conn, err := engine.Connect(ctx, pb.LanguageCode_ENGLISH_US, "session")
+	assert.NoError(t, err)
+
+	_, err = conn.WaitForResponse()
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
@@ -0,0 +1,100 @@
+package localconn
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	pb "github.com/digital-dream-labs/api/go/chipperpb"
+)
+
+// Recognizer converts speech audio into text on the device
+type Recognizer interface {
+	// NewSession begins recognition of a single utterance in the given language
+	NewSession(language pb.LanguageCode) (RecognizerSession, error)
+}
+
+// RecognizerSession accepts the audio of a single utterance and produces its transcript
+type RecognizerSession interface {
+	// AcceptAudio adds 16kHz, 16-bit little endian mono PCM audio to the utterance
+	AcceptAudio(pcm []byte) error
+	// Final signals that no more audio is coming and returns the transcript
+	Final() (string, error)
+	// Close releases any resources associated with the session
+	Close() error
+}
+
+// NewCommandRecognizer returns a Recognizer that launches the given command for each
+// utterance, writes PCM audio to its stdin, and reads the transcript from its stdout.
+// The requested language is provided in the VECTOR_LANGUAGE environment variable.
+// This allows any offline speech recognizer installed on the robot to be used without
+// linking it into this process.
+func NewCommandRecognizer(name string, args ...string) Recognizer {
+	return &cmdRecognizer{name: name, args: args}
+}
+
+type cmdRecognizer struct {
+	name string
+	args []string
+}
+
+func (r *cmdRecognizer) NewSession(language pb.LanguageCode) (RecognizerSession, error) {
+	cmd := exec.Command(r.name, r.args...)
+	cmd.Env = append(os.Environ(), "VECTOR_LANGUAGE="+language.String())
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	s := &cmdSession{cmd: cmd, stdin: stdin}
+	cmd.Stdout = &s.stdout
+	cmd.Stderr = &s.stderr
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+type cmdSession struct {
+	cmd      *exec.Cmd
+	stdin    io.WriteCloser
+	stdout   bytes.Buffer
+	stderr   bytes.Buffer
+	waitOnce sync.Once
+	waitErr  error
+}
+
+func (s *cmdSession) AcceptAudio(pcm []byte) error {
+	_, err := s.stdin.Write(pcm)
+	return err
+}
+
+func (s *cmdSession) Final() (string, error) {
+	if err := s.wait(); err != nil {
+		if msg := strings.TrimSpace(s.stderr.String()); msg != "" {
+			return "", errors.New(err.Error() + ": " + msg)
+		}
+		return "", err
+	}
+	return strings.TrimSpace(s.stdout.String()), nil
+}
+
+func (s *cmdSession) Close() error {
+	// killing an already exited process is harmless, and unblocks a pending Final()
+	s.cmd.Process.Kill()
+	s.wait()
+	return nil
+}
+
+// wait closes the command's input and waits for it to exit; it can be called from
+// multiple routines
+func (s *cmdSession) wait() error {
+	s.waitOnce.Do(func() {
+		s.stdin.Close()
+		s.waitErr = s.cmd.Wait()
+	})
+	return s.waitErr
+}
@@ -0,0 +1,207 @@
+package stream
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/digital-dream-labs/vector-cloud/internal/clad/cloud"
+	"github.com/digital-dream-labs/vector-cloud/internal/log"
+)
+
+// LatencyBucketsMs are the upper bounds of the buckets voice latencies are counted in
+var LatencyBucketsMs = []float64{50, 100, 250, 500, 1000, 2500, 5000, 10000}
+
+var errorNames = map[cloud.ErrorType]string{
+	cloud.ErrorType_Server:        "server",
+	cloud.ErrorType_Timeout:       "timeout",
+	cloud.ErrorType_Json:          "json",
+	cloud.ErrorType_InvalidConfig: "invalid_config",
+	cloud.ErrorType_Connecting:    "connecting",
+	cloud.ErrorType_NewStream:     "new_stream",
+	cloud.ErrorType_Token:         "token",
+	cloud.ErrorType_TLS:           "tls",
+	cloud.ErrorType_Connectivity:  "connectivity",
+}
+
+func errorName(kind cloud.ErrorType) string {
+	if name, ok := errorNames[kind]; ok {
+		return name
+	}
+	return strconv.Itoa(int(kind))
+}
+
+// Histogram counts observed values in buckets, in the manner of a Prometheus histogram
+type Histogram struct {
+	name    string
+	help    string
+	bounds  []float64
+	mu      sync.Mutex
+	buckets []uint64
+	count   uint64
+	sum     float64
+	max     float64
+	// totals at the time of the last DAS summary
+	lastCount uint64
+	lastSum   float64
+}
+
+func newHistogram(name, help string, bounds []float64) *Histogram {
+	return &Histogram{name: name, help: help, bounds: bounds, buckets: make([]uint64, len(bounds))}
+}
+
+// Observe adds a value to the histogram
+func (h *Histogram) Observe(value float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, b := range h.bounds {
+		if value <= b {
+			h.buckets[i]++
+		}
+	}
+	h.count++
+	h.sum += value
+	if value > h.max {
+		h.max = value
+	}
+}
+
+// Count returns the number of values observed
+func (h *Histogram) Count() uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.count
+}
+
+func (h *Histogram) writePrometheus(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	for i, b := range h.bounds {
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", h.name, strconv.FormatFloat(b, 'f', -1, 64), h.buckets[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", h.name, h.count)
+	fmt.Fprintf(w, "%s_sum %s\n", h.name, strconv.FormatFloat(h.sum, 'f', -1, 64))
+	fmt.Fprintf(w, "%s_count %d\n", h.name, h.count)
+}
+
+// summarize sends a DAS event with the count, average and max of the values observed
+// since the last summary
+func (h *Histogram) summarize() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if n := h.count - h.lastCount; n > 0 {
+		avg := (h.sum - h.lastSum) / float64(n)
+		log.Das("voice.latency", (&log.DasFields{}).SetStrings(h.name).SetInts(int(n), int(avg), int(h.max)))
+	}
+	h.lastCount, h.lastSum, h.max = h.count, h.sum, 0
+}
+
+// Metrics records the latency, errors and upload volume of voice requests
+type Metrics struct {
+	// StreamOpen is the time from the hotword to the server accepting the stream
+	StreamOpen *Histogram
+	// FirstByte is the time from sending the first audio to the first response
+	FirstByte *Histogram
+	// Intent is the time from the hotword to receiving the intent
+	Intent *Histogram
+
+	mu         sync.Mutex
+	errors     map[cloud.ErrorType]uint64
+	bytes      uint64
+	lastErrors map[cloud.ErrorType]uint64
+	lastBytes  uint64
+}
+
+// DefaultMetrics is used by streams that aren't given other metrics, and is served
+// by the dev HTTP server
+var DefaultMetrics = NewMetrics()
+
+// NewMetrics returns an empty set of metrics
+func NewMetrics() *Metrics {
+	return &Metrics{
+		StreamOpen: newHistogram("voice_stream_open_ms",
+			"Time from hotword to stream open, in milliseconds.", LatencyBucketsMs),
+		FirstByte: newHistogram("voice_first_byte_ms",
+			"Time from the first audio upload to the first server response, in milliseconds.", LatencyBucketsMs),
+		Intent: newHistogram("voice_intent_ms",
+			"Time from hotword to intent result, in milliseconds.", LatencyBucketsMs),
+		errors:     make(map[cloud.ErrorType]uint64),
+		lastErrors: make(map[cloud.ErrorType]uint64),
+	}
+}
+
+// AddError counts an error reported for a voice request
+func (m *Metrics) AddError(kind cloud.ErrorType) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.errors[kind]++
+}
+
+// Errors returns the number of errors of the given kind reported so far
+func (m *Metrics) Errors(kind cloud.ErrorType) uint64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.errors[kind]
+}
+
+// AddBytes counts audio uploaded to the server, as it was sent after encoding
+func (m *Metrics) AddBytes(n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.bytes += uint64(n)
+}
+
+// Bytes returns the number of encoded audio bytes uploaded so far
+func (m *Metrics) Bytes() uint64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.bytes
+}
+
+func (m *Metrics) histograms() []*Histogram {
+	return []*Histogram{m.StreamOpen, m.FirstByte, m.Intent}
+}
+
+// WritePrometheus writes the metrics in the Prometheus text exposition format
+func (m *Metrics) WritePrometheus(w io.Writer) {
+	for _, h := range m.histograms() {
+		h.writePrometheus(w)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	fmt.Fprint(w, "# HELP voice_errors_total Voice request errors, by type.\n# TYPE voice_errors_total counter\n")
+	kinds := make([]cloud.ErrorType, 0, len(m.errors))
+	for k := range m.errors {
+		kinds = append(kinds, k)
+	}
+	sort.Slice(kinds, func(i, j int) bool { return kinds[i] < kinds[j] })
+	for _, k := range kinds {
+		fmt.Fprintf(w, "voice_errors_total{type=\"%s\"} %d\n", errorName(k), m.errors[k])
+	}
+	fmt.Fprint(w, "# HELP voice_upload_bytes_total Encoded audio bytes uploaded.\n# TYPE voice_upload_bytes_total counter\n")
+	fmt.Fprintf(w, "voice_upload_bytes_total %d\n", m.bytes)
+}
+
+// Summarize sends DAS events describing the metrics recorded since the last call
+func (m *Metrics) Summarize() {
+	for _, h := range m.histograms() {
+		h.summarize()
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for k, n := range m.errors {
+		if d := n - m.lastErrors[k]; d > 0 {
+			log.Das("voice.errors", (&log.DasFields{}).SetStrings(errorName(k)).SetInts(int(d)))
+		}
+		m.lastErrors[k] = n
+	}
+	if d := m.bytes - m.lastBytes; d > 0 {
+		log.Das("voice.upload_bytes", (&log.DasFields{}).SetInts(int(d)))
+	}
+	m.lastBytes = m.bytes
+}
@@ -0,0 +1,47 @@
+package stream_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/digital-dream-labs/vector-cloud/internal/clad/cloud"
+	"github.com/digital-dream-labs/vector-cloud/internal/voice/stream"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricsPrometheus(t *testing.T) {
+	m := stream.NewMetrics()
+	m.StreamOpen.Observe(40)
+	m.StreamOpen.Observe(300)
+	m.AddError(cloud.ErrorType_Timeout)
+	m.AddError(cloud.ErrorType_Timeout)
+	m.AddError(cloud.ErrorType_TLS)
+	m.AddBytes(3840)
+	m.AddBytes(3840)
+
+	var buf bytes.Buffer
+	m.WritePrometheus(&buf)
+	out := buf.String()
+	for _, line := range []string{
+		"# TYPE voice_stream_open_ms histogram\n",
+		"voice_stream_open_ms_bucket{le=\"50\"} 1\n",
+		"voice_stream_open_ms_bucket{le=\"250\"} 1\n",
+		"voice_stream_open_ms_bucket{le=\"500\"} 2\n",
+		"voice_stream_open_ms_bucket{le=\"+Inf\"} 2\n",
+		"voice_stream_open_ms_sum 340\n",
+		"voice_stream_open_ms_count 2\n",
+		"voice_intent_ms_count 0\n",
+		"voice_errors_total{type=\"timeout\"} 2\n",
+		"voice_errors_total{type=\"tls\"} 1\n",
+		"voice_upload_bytes_total 7680\n",
+	} {
+		assert.Contains(t, out, line)
+	}
+
+	// summarizing doesn't reset the cumulative values
+	m.Summarize()
+	assert.Equal(t, uint64(2), m.StreamOpen.Count())
+	assert.Equal(t, uint64(2), m.Errors(cloud.ErrorType_Timeout))
+	assert.Equal(t, uint64(7680), m.Bytes())
+}
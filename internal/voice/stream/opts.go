@@ -8,12 +8,21 @@ import (
 	"github.com/digital-dream-labs/vector-cloud/internal/token"
 
 	"github.com/digital-dream-labs/api-clients/chipper"
+	pb "github.com/digital-dream-labs/api/go/chipperpb"
 )
 
 var platformOpts []chipper.ConnOpt
 
 type ConnectFunc func(context.Context) (Conn, *CloudError)
 
+type languageKey struct{}
+
+// Language returns the language of the request a ConnectFunc is connecting for
+func Language(ctx context.Context) pb.LanguageCode {
+	language, _ := ctx.Value(languageKey{}).(pb.LanguageCode)
+	return language
+}
+
 type options struct {
 	tokener         token.Accessor
 	requireToken    bool
@@ -25,7 +34,11 @@ type options struct {
 	checkOpts       *chipper.ConnectOpts
 	streamOpts      *chipper.StreamOpts
 	url             string
+	endpoints       *Endpoints
 	connectFn       ConnectFunc
+	vadOpts         *VADOpts
+	sendObserver    func(sendMs float64)
+	metrics         *Metrics
 }
 
 type Option func(o *options)
@@ -74,6 +87,38 @@ func WithChipperURL(url string) Option {
 	}
 }
 
+// WithChipperEndpoints specifies a set of chipper servers to try in order; if given,
+// it takes precedence over WithChipperURL
+func WithChipperEndpoints(endpoints *Endpoints) Option {
+	return func(o *options) {
+		o.endpoints = endpoints
+	}
+}
+
+// WithVAD enables voice activity detection on the stream's audio: leading silence is
+// trimmed before upload, and the stream's send side is closed once speech ends
+func WithVAD(opts VADOpts) Option {
+	return func(o *options) {
+		o.vadOpts = &opts
+	}
+}
+
+// WithSendObserver specifies a function that will be called with the time, in
+// milliseconds, each chunk of audio took to send
+func WithSendObserver(fn func(sendMs float64)) Option {
+	return func(o *options) {
+		o.sendObserver = fn
+	}
+}
+
+// WithMetrics specifies where the stream's latency, errors and upload volume are
+// recorded, instead of DefaultMetrics
+func WithMetrics(m *Metrics) Option {
+	return func(o *options) {
+		o.metrics = m
+	}
+}
+
 // WithConnectFunc allows tests to provide a separate connection interface for the streamer, to
 // mock connections instead of using real ones
 func WithConnectFunc(connectFn ConnectFunc) Option {
@@ -13,14 +13,16 @@ import (
 	"github.com/digital-dream-labs/vector-cloud/internal/voice/stream/testconn"
 
 	"github.com/digital-dream-labs/api-clients/chipper"
+	pb "github.com/digital-dream-labs/api/go/chipperpb"
 	"github.com/stretchr/testify/assert"
 )
 
 type testReceiver struct {
-	err    chan *stream.CloudError
-	open   chan string
-	intent chan *cloud.IntentResult
-	result chan *cloud.ConnectionResult
+	err     chan *stream.CloudError
+	open    chan string
+	intent  chan *cloud.IntentResult
+	result  chan *cloud.ConnectionResult
+	partial chan *stream.Transcript
 }
 
 func (r *testReceiver) OnError(kind cloud.ErrorType, err error) {
@@ -39,11 +41,16 @@ func (r *testReceiver) OnConnectionResult(result *cloud.ConnectionResult) {
 	r.result <- result
 }
 
+func (r *testReceiver) OnPartialTranscript(text string, isFinal bool) {
+	r.partial <- &stream.Transcript{Text: text, IsFinal: isFinal}
+}
+
 func (r *testReceiver) Close() {
 	close(r.err)
 	close(r.open)
 	close(r.intent)
 	close(r.result)
+	close(r.partial)
 }
 
 func (r *testReceiver) CouldPull(shouldLog ...bool) bool {
@@ -66,6 +73,8 @@ func (r *testReceiver) CouldPull(shouldLog ...bool) bool {
 		maybeLog("can pull from intent channel")
 	case <-r.result:
 		maybeLog("can pull from connection result channel")
+	case <-r.partial:
+		maybeLog("can pull from partial transcript channel")
 	default:
 		return false
 	}
@@ -89,7 +98,8 @@ func newReceiver() *testReceiver {
 		make(chan *stream.CloudError),
 		make(chan string),
 		make(chan *cloud.IntentResult),
-		make(chan *cloud.ConnectionResult)}
+		make(chan *cloud.ConnectionResult),
+		make(chan *stream.Transcript)}
 }
 
 var receiver = newReceiver()
@@ -314,3 +324,71 @@ func TestTimeoutOption(t *testing.T) {
 	assert.False(t, receiver.CouldPull(true))
 	strm.Close()
 }
+
+func TestPartialTranscripts(t *testing.T) {
+	conn, fn, trigger := connector()
+
+	strm := stream.NewStreamer(context.Background(), receiver, 100, stream.WithConnectFunc(fn))
+	trigger()
+	// responses are only waited on once audio starts flowing
+	strm.AddBytes(make([]byte, 100))
+
+	go func() {
+		conn.TriggerPartial("what", false)
+		conn.TriggerPartial("what time", false)
+		conn.TriggerResponse(&chipper.IntentGraphResponse{IsFinal: false,
+			SpeechResult: &pb.SpeechResult{Transcript: "what time is it", IsFinal: true}}, nil)
+		conn.TriggerResponse(&chipper.IntentGraphResponse{IsFinal: true,
+			ResponseType: pb.IntentGraphMode_INTENT,
+			IntentResult: &chipper.IntentResult{Action: "intent_clock_time"}}, nil)
+	}()
+
+	// partials arrive in order, followed by the intent
+	assert.Equal(t, &stream.Transcript{Text: "what"}, <-receiver.partial)
+	assert.Equal(t, &stream.Transcript{Text: "what time"}, <-receiver.partial)
+	assert.Equal(t, &stream.Transcript{Text: "what time is it", IsFinal: true}, <-receiver.partial)
+	res := <-receiver.intent
+	assert.Equal(t, "intent_clock_time", res.Intent)
+	time.Sleep(5 * time.Millisecond)
+	assert.False(t, receiver.CouldPull(true))
+	strm.Close()
+}
+
+// pcm returns the given number of ms of 16kHz audio: a square wave of the given
+// amplitude, or silence if it's 0
+func pcm(ms int, amplitude int16) []int16 {
+	samples := make([]int16, ms*16)
+	for i := range samples {
+		if (i/8)%2 == 0 {
+			samples[i] = amplitude
+		} else {
+			samples[i] = -amplitude
+		}
+	}
+	return samples
+}
+
+func TestVAD(t *testing.T) {
+	conn, fn, trigger := connector()
+
+	const streamSize = 640 // 20ms
+	strm := stream.NewStreamer(context.Background(), receiver, streamSize, stream.WithConnectFunc(fn),
+		stream.WithVAD(stream.VADOpts{LeadingPaddingMs: 100, TrailingSilenceMs: 200}))
+	defer strm.Close()
+	trigger()
+
+	strm.AddSamples(pcm(1000, 0))    // leading silence, trimmed to 100ms of padding
+	strm.AddSamples(pcm(500, 3000))  // speech
+	strm.AddSamples(pcm(180, 0))     // pause that's too short to end the request
+	strm.AddSamples(pcm(100, 3000))  // more speech
+	strm.AddSamples(pcm(1000, 0))    // end of speech after 200ms
+	strm.AddSamples(pcm(1000, 3000)) // ignored
+	time.Sleep(20 * time.Millisecond)
+
+	assert.True(t, conn.SendClosed)
+	var sent int
+	for _, buf := range conn.AudioSends {
+		sent += len(buf)
+	}
+	assert.Equal(t, (100+500+180+100+200)*32, sent)
+}
@@ -1,6 +1,10 @@
 package testconn
 
-import "errors"
+import (
+	"errors"
+
+	"github.com/digital-dream-labs/vector-cloud/internal/voice/stream"
+)
 
 type response struct {
 	obj interface{}
@@ -11,6 +15,7 @@ type TestConn struct {
 	respChan    chan response
 	AudioSends  [][]byte
 	ErrorOnSend bool
+	SendClosed  bool
 }
 
 func NewTestConn() *TestConn {
@@ -26,6 +31,7 @@ func (c *TestConn) Close() error {
 }
 
 func (c *TestConn) CloseSend() error {
+	c.SendClosed = true
 	return nil
 }
 
@@ -45,3 +51,7 @@ func (c *TestConn) WaitForResponse() (interface{}, error) {
 func (c *TestConn) TriggerResponse(obj interface{}, err error) {
 	c.respChan <- response{obj, err}
 }
+
+func (c *TestConn) TriggerPartial(text string, isFinal bool) {
+	c.respChan <- response{&stream.Transcript{Text: text, IsFinal: isFinal}, nil}
+}
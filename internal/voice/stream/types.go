@@ -3,6 +3,7 @@ package stream
 import (
 	"context"
 	"sync"
+	"time"
 
 	"github.com/digital-dream-labs/vector-cloud/internal/clad/cloud"
 )
@@ -17,6 +18,8 @@ type Streamer struct {
 	receiver    Receiver
 	ctx         context.Context
 	cancel      func()
+	// firstSend is when the first audio was sent, to measure time to first response
+	firstSend time.Time
 }
 
 type Receiver interface {
@@ -24,6 +27,19 @@ type Receiver interface {
 	OnStreamOpen(string)
 	OnIntent(*cloud.IntentResult)
 	OnConnectionResult(*cloud.ConnectionResult)
+	// OnPartialTranscript receives interim speech recognition results before the
+	// final intent, if the connection provides them
+	OnPartialTranscript(transcript string, isFinal bool)
+}
+
+// Transcript can be returned by Conn.WaitForResponse to report an interim speech
+// recognition result; it is passed on to the Receiver, and the stream continues
+// waiting for a final response
+type Transcript struct {
+	Text string
+	// IsFinal is set when the recognizer won't revise this text any further (though
+	// the intent response may still be pending)
+	IsFinal bool
 }
 
 type CloudError struct {
@@ -0,0 +1,174 @@
+package stream
+
+import (
+	"encoding/binary"
+	"math"
+
+	"github.com/digital-dream-labs/vector-cloud/internal/log"
+)
+
+// Default values for VADOpts fields left at zero
+const (
+	DefaultVADFrameMs           = 20
+	DefaultVADEnergyThreshold   = 300
+	DefaultVADZCRThreshold      = 0.25
+	DefaultVADLeadingPaddingMs  = 300
+	DefaultVADMaxLeadingMs      = 3000
+	DefaultVADTrailingSilenceMs = 800
+	DefaultVADSampleRate        = 16000
+)
+
+// VADOpts configures client-side voice activity detection, which trims silence from
+// the start of a request and ends the request once the speaker stops talking
+type VADOpts struct {
+	// FrameMs is the length of audio that's classified as speech or silence at a time
+	FrameMs int
+	// EnergyThreshold is the RMS amplitude (out of 32767) above which a frame is speech
+	EnergyThreshold float64
+	// ZCRThreshold is the zero crossing rate (crossings per sample) above which a
+	// quieter frame, with at least half of EnergyThreshold, is also treated as speech;
+	// this catches unvoiced sounds like "s" and "f"
+	ZCRThreshold float64
+	// LeadingPaddingMs is how much audio before the start of speech is still uploaded
+	LeadingPaddingMs int
+	// MaxLeadingMs is how long to wait for speech to start before giving up on
+	// trimming and uploading audio anyway
+	MaxLeadingMs int
+	// TrailingSilenceMs is how much silence after speech ends the request
+	TrailingSilenceMs int
+	// SampleRate of the 16-bit mono audio being streamed
+	SampleRate int
+}
+
+func (o VADOpts) withDefaults() VADOpts {
+	if o.FrameMs <= 0 {
+		o.FrameMs = DefaultVADFrameMs
+	}
+	if o.EnergyThreshold <= 0 {
+		o.EnergyThreshold = DefaultVADEnergyThreshold
+	}
+	if o.ZCRThreshold <= 0 {
+		o.ZCRThreshold = DefaultVADZCRThreshold
+	}
+	if o.LeadingPaddingMs <= 0 {
+		o.LeadingPaddingMs = DefaultVADLeadingPaddingMs
+	}
+	if o.MaxLeadingMs <= 0 {
+		o.MaxLeadingMs = DefaultVADMaxLeadingMs
+	}
+	if o.TrailingSilenceMs <= 0 {
+		o.TrailingSilenceMs = DefaultVADTrailingSilenceMs
+	}
+	if o.SampleRate <= 0 {
+		o.SampleRate = DefaultVADSampleRate
+	}
+	return o
+}
+
+type vadState int
+
+const (
+	vadWaiting vadState = iota
+	vadSpeaking
+	vadEnded
+)
+
+// vad classifies audio frame by frame, holding back audio until speech starts and
+// reporting when it's over
+type vad struct {
+	opts       VADOpts
+	frameBytes int
+	state      vadState
+	pending    []byte   // partial frame not yet classified
+	padding    [][]byte // frames held before speech starts
+	waitedMs   int
+	silenceMs  int
+	trimmed    int
+}
+
+func newVAD(opts VADOpts) *vad {
+	opts = opts.withDefaults()
+	return &vad{
+		opts:       opts,
+		frameBytes: opts.SampleRate * opts.FrameMs / 1000 * 2,
+	}
+}
+
+// process takes the next bytes of audio and returns the audio that should be uploaded,
+// along with whether the end of speech has been reached. Once it has, no more audio
+// is returned.
+func (v *vad) process(buf []byte) ([]byte, bool) {
+	if v.state == vadEnded {
+		return nil, true
+	}
+	v.pending = append(v.pending, buf...)
+	var out []byte
+	for len(v.pending) >= v.frameBytes && v.state != vadEnded {
+		frame := v.pending[:v.frameBytes:v.frameBytes]
+		v.pending = v.pending[v.frameBytes:]
+		speech := v.isSpeech(frame)
+
+		switch v.state {
+		case vadWaiting:
+			v.padding = append(v.padding, frame)
+			v.waitedMs += v.opts.FrameMs
+			if speech || v.waitedMs >= v.opts.MaxLeadingMs {
+				if !speech {
+					log.Println("VAD: no speech detected after", v.waitedMs, "ms, uploading anyway")
+				}
+				v.state = vadSpeaking
+				for _, f := range v.padding {
+					out = append(out, f...)
+				}
+				v.padding = nil
+				continue
+			}
+			if maxFrames := v.opts.LeadingPaddingMs / v.opts.FrameMs; len(v.padding) > maxFrames {
+				v.trimmed += len(v.padding[0])
+				v.padding = v.padding[1:]
+			}
+
+		case vadSpeaking:
+			out = append(out, frame...)
+			if speech {
+				v.silenceMs = 0
+			} else {
+				v.silenceMs += v.opts.FrameMs
+			}
+			if v.silenceMs >= v.opts.TrailingSilenceMs {
+				v.state = vadEnded
+				logVerbose("VAD: end of speech detected, trimmed", v.trimmed, "leading bytes")
+			}
+		}
+	}
+	if v.state == vadEnded {
+		v.pending = nil
+	}
+	return out, v.state == vadEnded
+}
+
+// isSpeech classifies a frame of 16-bit little endian PCM by its energy and zero
+// crossing rate
+func (v *vad) isSpeech(frame []byte) bool {
+	samples := len(frame) / 2
+	if samples == 0 {
+		return false
+	}
+	var sum float64
+	var crossings int
+	var prev int16
+	for i := 0; i < samples; i++ {
+		s := int16(binary.LittleEndian.Uint16(frame[i*2:]))
+		sum += float64(s) * float64(s)
+		if i > 0 && (s >= 0) != (prev >= 0) {
+			crossings++
+		}
+		prev = s
+	}
+	rms := math.Sqrt(sum / float64(samples))
+	if rms >= v.opts.EnergyThreshold {
+		return true
+	}
+	zcr := float64(crossings) / float64(samples)
+	return rms >= v.opts.EnergyThreshold/2 && zcr >= v.opts.ZCRThreshold
+}
@@ -0,0 +1,123 @@
+// token-server runs a local token server, for robots configured to use it in place
+// of Anki's token service:
+//
+//	go build -o build/token-server ./token-server
+//
+// Robots identify themselves with their factory certificates, which are verified
+// against -client-ca, and users with the sessions listed in the config. Both can be
+// skipped with -insecure for testing. The server's JWKS can be written out with
+// -jwks for robots to verify the tokens it issues.
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/digital-dream-labs/vector-cloud/internal/log"
+	"github.com/digital-dream-labs/vector-cloud/internal/token/local"
+
+	pb "github.com/digital-dream-labs/api/go/tokenpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+func main() {
+	log.Tag = "token-server"
+
+	configFile := flag.String("config", "token-server.json", "local token server config file")
+	addr := flag.String("addr", ":8443", "address to listen on")
+	certFile := flag.String("cert", "", "TLS certificate file")
+	keyFile := flag.String("key", "", "TLS key file")
+	clientCA := flag.String("client-ca", "", "CA that robot certificates must be signed by")
+	jwksFile := flag.String("jwks", "", "file to write the token signing key set to")
+	insecure := flag.Bool("insecure", false, "trust robot certificates without -client-ca and accept any user session, for testing")
+	flag.Parse()
+
+	cfg, err := local.LoadConfig(*configFile)
+	if err != nil {
+		log.Println("Error loading config:", err)
+		os.Exit(1)
+	}
+	if !*insecure {
+		if *clientCA == "" {
+			log.Println("No client CA given; robot certificates can't be verified without one (use -insecure to allow this)")
+			os.Exit(1)
+		}
+		if len(cfg.Sessions) == 0 {
+			log.Println("No user sessions configured; any session would be accepted (use -insecure to allow this)")
+			os.Exit(1)
+		}
+	}
+	if len(cfg.Sessions) == 0 {
+		log.Println("Insecure: any user session will be accepted")
+	}
+	cfg.InsecureClientCerts = *insecure && *clientCA == ""
+	server, err := local.NewServer(cfg)
+	if err != nil {
+		log.Println("Error creating token server:", err)
+		os.Exit(1)
+	}
+
+	if *jwksFile != "" {
+		jwks, err := server.JWKS()
+		if err == nil {
+			err = ioutil.WriteFile(*jwksFile, jwks, 0644)
+		}
+		if err != nil {
+			log.Println("Error writing JWKS:", err)
+			os.Exit(1)
+		}
+	}
+
+	cert, err := tls.LoadX509KeyPair(*certFile, *keyFile)
+	if err != nil {
+		log.Println("Error loading TLS certificate:", err)
+		os.Exit(1)
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequestClientCert,
+	}
+	if *clientCA != "" {
+		buf, err := ioutil.ReadFile(*clientCA)
+		if err != nil {
+			log.Println("Error loading client CA:", err)
+			os.Exit(1)
+		}
+		tlsConfig.ClientCAs = x509.NewCertPool()
+		if !tlsConfig.ClientCAs.AppendCertsFromPEM(buf) {
+			log.Println("No certificates found in client CA file", *clientCA)
+			os.Exit(1)
+		}
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	} else {
+		log.Println("Insecure: robot certificates won't be verified")
+	}
+
+	lis, err := net.Listen("tcp", *addr)
+	if err != nil {
+		log.Println("Error listening:", err)
+		os.Exit(1)
+	}
+	grpcServer := grpc.NewServer(grpc.Creds(credentials.NewTLS(tlsConfig)))
+	pb.RegisterTokenServer(grpcServer, server)
+
+	go func() {
+		ch := make(chan os.Signal, 1)
+		signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM)
+		<-ch
+		grpcServer.GracefulStop()
+	}()
+
+	log.Println("Serving token requests on", *addr)
+	if err := grpcServer.Serve(lis); err != nil {
+		log.Println("Server error:", err)
+		os.Exit(1)
+	}
+}